@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// gzipMagic is how gzipped files start.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// logFile is a file being read through something which decodes it.
+type logFile struct {
+	io.Reader
+	f *os.File
+}
+
+func (lf *logFile) Close() error {
+	return lf.f.Close()
+}
+
+// openLog opens a log file for reading, decompressing it on the fly if it's
+// been gzipped, as archived logs often are, and decoding it if it's
+// UTF-16LE, as Domino writes its logs on Windows. That's decided by what's
+// in it rather than its name, unless -encoding says it's UTF-16LE.
+func openLog(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(len(gzipMagic))
+	gzipped := bytes.Equal(magic, gzipMagic)
+	if gzipped {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+		br = bufio.NewReader(zr)
+		magic, _ = br.Peek(len(utf16BOM))
+	}
+	switch {
+	case bytes.Equal(magic, utf16BOM) || wideEncoding:
+		return &logFile{&utf16Reader{r: br}, f}, nil
+	case gzipped:
+		return &logFile{br, f}, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// openGzipped opens a gzipped file for reading decompressed, without
+// decoding what's in it.
+func openGzipped(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return &logFile{zr, f}, nil
+}
+
+// skipTo reads from r up to an offset, reporting whether the bytes given
+// were just before it. It's matchesAt for files which can only be read from
+// the start.
+func skipTo(r io.Reader, offset int64, recent []byte) bool {
+	if _, err := io.CopyN(io.Discard, r, offset-int64(len(recent))); err != nil {
+		return false
+	}
+	buf := make([]byte, len(recent))
+	_, err := io.ReadFull(r, buf)
+	return err == nil && bytes.Equal(buf, recent)
+}
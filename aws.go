@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Where EC2 instances find their metadata, including the credentials for
+// their IAM role.
+const ec2MetadataURL = "http://169.254.169.254/latest"
+
+// awsCredentials are credentials for signing AWS requests.
+type awsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+// awsCredentialCache holds the credentials for AWS requests, fetching them
+// from the environment, or failing that, from the EC2 instance's IAM role,
+// and renewing the role's credentials before they expire.
+type awsCredentialCache struct {
+	mu    sync.Mutex
+	creds *awsCredentials
+}
+
+// get returns credentials for signing a request.
+func (cc *awsCredentialCache) get() (*awsCredentials, error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.creds != nil && (cc.creds.Expiration.IsZero() || time.Until(cc.creds.Expiration) > 5*time.Minute) {
+		return cc.creds, nil
+	}
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		cc.creds = &awsCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			Token:           os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		return cc.creds, nil
+	}
+	token, err := ec2MetadataToken()
+	if err != nil {
+		return nil, fmt.Errorf("no AWS credentials in the environment, and can't get them from EC2: %s", err)
+	}
+	role, err := ec2Metadata(token, "/meta-data/iam/security-credentials/")
+	if err != nil {
+		return nil, err
+	}
+	role = strings.TrimSpace(strings.SplitN(role, "\n", 2)[0])
+	js, err := ec2Metadata(token, "/meta-data/iam/security-credentials/"+role)
+	if err != nil {
+		return nil, err
+	}
+	var creds awsCredentials
+	if err := json.Unmarshal([]byte(js), &creds); err != nil {
+		return nil, fmt.Errorf("can't understand EC2 role credentials: %s", err)
+	}
+	cc.creds = &creds
+	return cc.creds, nil
+}
+
+// ec2MetadataToken gets a session token for the EC2 instance metadata
+// service, as IMDSv2 requires.
+func ec2MetadataToken() (string, error) {
+	req, err := http.NewRequest("PUT", ec2MetadataURL+"/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("EC2 metadata token: %s", resp.Status)
+	}
+	token, err := io.ReadAll(resp.Body)
+	return string(token), err
+}
+
+// ec2Metadata fetches an item of EC2 instance metadata.
+func ec2Metadata(token, path string) (string, error) {
+	req, err := http.NewRequest("GET", ec2MetadataURL+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("EC2 metadata %s: %s", path, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+// awsRegion returns the AWS region from the environment, or failing that,
+// the region of the EC2 instance we're on.
+func awsRegion() (string, error) {
+	for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(name); region != "" {
+			return region, nil
+		}
+	}
+	token, err := ec2MetadataToken()
+	if err != nil {
+		return "", fmt.Errorf("no AWS region given, and can't get it from EC2: %s", err)
+	}
+	return ec2Metadata(token, "/meta-data/placement/region")
+}
+
+// signAWS signs a request with AWS Signature Version 4. The request's
+// headers must all be set already.
+func signAWS(req *http.Request, body []byte, creds *awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	fmt.Fprintf(&canonical, "%s\n%s\n%s\n", req.Method, path, req.URL.RawQuery)
+	for _, name := range names {
+		fmt.Fprintf(&canonical, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+	fmt.Fprintf(&canonical, "\n%s\n%s", signedHeaders, sha256Hex(body))
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical.String()))
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Most data the Logs Ingestion API accepts in one request.
+const azureMaxBatch = 1000000
+
+// What access tokens for the Logs Ingestion API are for.
+const azureMonitorScope = "https://monitor.azure.com"
+
+// Where Azure VMs get access tokens for their managed identity.
+const azureIMDSTokenURL = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+func init() {
+	outputTypes["azure"] = func(arg string) (Output, error) {
+		return openAzure(arg)
+	}
+}
+
+// azureLogs sends events to a Log Analytics workspace through the Logs
+// Ingestion API, by way of a data collection rule.
+type azureLogs struct {
+	url string // of the stream to upload to
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// openAzure opens an Azure Monitor output, given the URL of the data
+// collection endpoint, with the immutable ID of the data collection rule and
+// the name of its stream as the rule and stream query parameters, such as
+// https://dce.westeurope-1.ingest.monitor.azure.com?rule=dcr-0123&stream=Custom-Domino_CL.
+func openAzure(arg string) (Output, error) {
+	u, err := url.Parse(arg)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("Azure data collection endpoint %q should be an https URL", arg)
+	}
+	params := queryParams(u.RawQuery)
+	rule, stream := params.Get("rule"), params.Get("stream")
+	if rule == "" || stream == "" {
+		return nil, fmt.Errorf("Azure output needs the data collection rule and stream, such as ?rule=dcr-0123&stream=Custom-Domino_CL")
+	}
+	u.RawQuery = "api-version=2023-01-01"
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/dataCollectionRules/" + url.PathEscape(rule) + "/streams/" + url.PathEscape(stream)
+	az := &azureLogs{url: u.String()}
+	return newBatchOutput("Azure Monitor stream "+stream, az.send), nil
+}
+
+// azureRecord returns an event as a row for Log Analytics. The fields
+// extracted by rules become columns of their own, alongside the usual ones,
+// as long as their names don't clash; the data collection rule's stream
+// declares which are kept.
+func azureRecord(ev *Event) map[string]interface{} {
+	rec := map[string]interface{}{
+		"TimeGenerated": ev.Time.UTC().Format(time.RFC3339Nano),
+		"Computer":      ev.host(),
+		"Severity":      priorityName(ev.Priority),
+		"Facility":      facilityName(ev.Facility),
+		"Tag":           ev.Tag,
+		"Message":       ev.Message,
+	}
+	for k, v := range map[string]string{"Task": ev.Task, "Thread": ev.Thread, "DominoTimestamp": ev.Timestamp, "Rule": ev.Rule} {
+		if v != "" {
+			rec[k] = v
+		}
+	}
+	for k, v := range ev.Fields {
+		if _, ok := rec[k]; !ok {
+			rec[k] = v
+		}
+	}
+	return rec
+}
+
+// send uploads a batch of events, in as many requests as it takes to stay
+// within the API's limit. If a request fails, the events which haven't been
+// sent yet are returned to be tried again.
+func (az *azureLogs) send(evs []*Event) error {
+	var batch []json.RawMessage
+	size, start := 0, 0
+	for i, ev := range evs {
+		rec, err := json.Marshal(azureRecord(ev))
+		if err != nil {
+			return permanentError{err}
+		}
+		if size+len(rec)+1 > azureMaxBatch && len(batch) > 0 {
+			if err := az.upload(batch); err != nil {
+				return retryFrom(evs[start:], err)
+			}
+			batch, size, start = nil, 0, i
+		}
+		batch = append(batch, rec)
+		size += len(rec) + 1
+	}
+	if err := az.upload(batch); err != nil {
+		return retryFrom(evs[start:], err)
+	}
+	return nil
+}
+
+// upload sends records to the stream.
+func (az *azureLogs) upload(batch []json.RawMessage) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return permanentError{err}
+	}
+	token, err := az.accessToken()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", az.url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	_, err = doHTTP(req)
+	return err
+}
+
+// accessToken returns an access token for the Logs Ingestion API, renewing
+// it before it expires. It's got with the client secret of the app
+// registration in AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET if
+// they're set, or else from the VM's managed identity.
+func (az *azureLogs) accessToken() (string, error) {
+	az.mu.Lock()
+	defer az.mu.Unlock()
+	if az.token != "" && time.Until(az.expires) > 5*time.Minute {
+		return az.token, nil
+	}
+	var req *http.Request
+	var err error
+	tenant, client, secret := os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID"), os.Getenv("AZURE_CLIENT_SECRET")
+	if tenant != "" && client != "" && secret != "" {
+		form := url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {client},
+			"client_secret": {secret},
+			"scope":         {azureMonitorScope + "/.default"},
+		}
+		req, err = http.NewRequest("POST", "https://login.microsoftonline.com/"+url.PathEscape(tenant)+"/oauth2/v2.0/token",
+			strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		query := url.Values{"api-version": {"2018-02-01"}, "resource": {azureMonitorScope}}
+		if client != "" {
+			// A user-assigned managed identity
+			query.Set("client_id", client)
+		}
+		req, err = http.NewRequest("GET", azureIMDSTokenURL+"?"+query.Encode(), nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata", "true")
+	}
+	body, err := doHTTP(req)
+	if err != nil {
+		return "", fmt.Errorf("can't get Azure access token: %s", err)
+	}
+	var tok struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"` // a string from the managed identity
+	}
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return "", fmt.Errorf("can't understand Azure access token: %s", err)
+	}
+	secs, _ := tok.ExpiresIn.Int64()
+	az.token = tok.AccessToken
+	az.expires = time.Now().Add(time.Duration(secs) * time.Second)
+	return az.token, nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Most events sent in one batch, and roughly the most bytes of them, if
+// that's limited; and the longest an event waits for a batch to fill before
+// it's sent anyway.
+var (
+	maxBatch      = 500
+	maxBatchBytes = 0
+	batchInterval = time.Second
+)
+
+// checkBatchSettings checks the batch settings make sense.
+func checkBatchSettings() error {
+	switch {
+	case maxBatch < 1:
+		return fmt.Errorf("batch size should be at least 1")
+	case maxBatchBytes < 0:
+		return fmt.Errorf("batch bytes can't be negative")
+	case batchInterval <= 0:
+		return fmt.Errorf("batch latency should be more than zero")
+	}
+	return nil
+}
+
+// eventSize estimates how many bytes an event takes up when it's sent, for
+// limiting the size of batches. Every output encodes events differently,
+// so it's only a rough guide.
+func eventSize(ev *Event) int {
+	// Allow for the time, severity and so on, and the encoding
+	n := 100 + len(ev.Tag) + len(ev.Task) + len(ev.Thread) + len(ev.Timestamp) + len(ev.Message) + len(ev.Rule)
+	for k, v := range ev.Fields {
+		n += len(k) + len(v) + 10
+	}
+	return n
+}
+
+// Client for the outputs which talk HTTP.
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// permanentError is an error which retrying won't fix, such as a server
+// rejecting a request as invalid.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// partialError is returned when some events in a batch were sent, and some
+// need to be sent again.
+type partialError struct {
+	retry []*Event
+	err   error
+}
+
+func (e partialError) Error() string {
+	return e.err.Error()
+}
+
+// batchOutput collects events into batches, and sends them with a function
+// for a particular kind of server, such as Elasticsearch. If sending fails,
+// it's retried with exponential backoff, unless the error is a
+// permanentError, or it's been tried attempts times, or retryAttempts, if
+// either is set. Events are queued meanwhile, up to syslogBuffer of them;
+// beyond that the oldest are dropped, and the number dropped is reported
+// once sending works again, unless the output is spooled, in which case new
+// events are refused.
+type batchOutput struct {
+	name     string // what we're sending to, for messages
+	send     func(evs []*Event) error
+	attempts int  // times to try a batch, if not forever; set before writing
+	refuse   bool // whether to refuse events when the queue is full
+
+	held *Event // which didn't fit in the last batch; only touched by run
+
+	mu      sync.Mutex
+	closed  bool
+	queue   chan *Event
+	closing chan struct{}  // closed to stop writes waiting for room
+	waiting sync.WaitGroup // writes waiting for room, without holding mu
+	stop    chan struct{}  // closed to make run give up
+	done    chan struct{}  // closed when run returns
+	dropped uint64
+}
+
+// newBatchOutput starts sending batches of events with send.
+func newBatchOutput(name string, send func(evs []*Event) error) *batchOutput {
+	bo := &batchOutput{
+		name:    name,
+		send:    send,
+		queue:   make(chan *Event, syslogBuffer),
+		closing: make(chan struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go bo.run()
+	return bo
+}
+
+func (bo *batchOutput) Write(ev *Event) error {
+	bo.mu.Lock()
+	if queueWait && !bo.closed {
+		// Wait without the lock, so as not to hold up Close, which
+		// doesn't close the queue until we're done
+		bo.waiting.Add(1)
+		bo.mu.Unlock()
+		defer bo.waiting.Done()
+		return waitToQueue(bo.queue, ev, bo.closing, bo.name)
+	}
+	defer bo.mu.Unlock()
+	if bo.closed {
+		return fmt.Errorf("output to %s is closed", bo.name)
+	}
+	for {
+		select {
+		case bo.queue <- ev:
+			return nil
+		default:
+			if bo.refuse {
+				return fmt.Errorf("queue for %s is full", bo.name)
+			}
+			// Full, so make room by dropping the oldest event
+			select {
+			case <-bo.queue:
+				atomic.AddUint64(&bo.dropped, 1)
+			default:
+			}
+		}
+	}
+}
+
+// spoolOverflow makes the output refuse events when its queue is full, for
+// a spool to keep, rather than dropping the oldest.
+func (bo *batchOutput) spoolOverflow() {
+	bo.mu.Lock()
+	defer bo.mu.Unlock()
+	bo.refuse = true
+}
+
+// run sends batches of events until the queue is closed.
+func (bo *batchOutput) run() {
+	defer close(bo.done)
+	for batch := bo.next(); batch != nil; batch = bo.next() {
+		if !bo.sendBatch(batch) {
+			return
+		}
+	}
+}
+
+// next waits for an event to send, then collects more until the batch is
+// full, by number or size, or batchInterval has passed. It returns nil once
+// the queue is closed and empty.
+func (bo *batchOutput) next() []*Event {
+	ev := bo.held
+	bo.held = nil
+	if ev == nil {
+		var ok bool
+		if ev, ok = <-bo.queue; !ok {
+			return nil
+		}
+	}
+	batch := []*Event{ev}
+	size := eventSize(ev)
+	timer := time.NewTimer(batchInterval)
+	defer timer.Stop()
+	for len(batch) < maxBatch {
+		select {
+		case ev, ok := <-bo.queue:
+			if !ok {
+				return batch
+			}
+			if maxBatchBytes > 0 && size+eventSize(ev) > maxBatchBytes {
+				// Save it for the next batch
+				bo.held = ev
+				return batch
+			}
+			batch = append(batch, ev)
+			size += eventSize(ev)
+		case <-timer.C:
+			return batch
+		}
+	}
+	return batch
+}
+
+// sendBatch sends a batch of events, retrying until it works, or fails
+// permanently. It returns false if it had to give up because the output
+// was closed.
+func (bo *batchOutput) sendBatch(batch []*Event) bool {
+	attempts := bo.attempts
+	if attempts == 0 {
+		attempts = retryAttempts
+	}
+	var delay retryDelay
+	for attempt := 1; ; attempt++ {
+		if n := atomic.SwapUint64(&bo.dropped, 0); n > 0 {
+			batch = append([]*Event{newEvent(LOG_WARNING,
+				fmt.Sprintf("dropped %d messages while %s was unavailable", n, bo.name))}, batch...)
+		}
+		err := bo.send(batch)
+		if err == nil {
+			return true
+		}
+		var perr permanentError
+		if errors.As(err, &perr) {
+			fmt.Fprintf(os.Stderr, "error sending %d messages to %s, giving up: %s\n", len(batch), bo.name, err)
+			countFailed(bo.name, len(batch))
+			deadLetter(bo.name, batch, err)
+			return true
+		}
+		var part partialError
+		if errors.As(err, &part) {
+			batch = part.retry
+		}
+		if attempts > 0 && attempt >= attempts {
+			fmt.Fprintf(os.Stderr, "error sending %d messages to %s, giving up after %d attempts: %s\n", len(batch), bo.name, attempt, err)
+			countFailed(bo.name, len(batch))
+			deadLetter(bo.name, batch, err)
+			return true
+		}
+		wait := delay.wait()
+		fmt.Fprintf(os.Stderr, "error sending %d messages to %s, retrying in %s: %s\n", len(batch), bo.name, wait.Round(time.Millisecond), err)
+		countRetry(bo.name)
+		select {
+		case <-time.After(wait):
+		case <-bo.stop:
+			return false
+		}
+	}
+}
+
+// Close sends any queued events, giving up as waitDrained says.
+func (bo *batchOutput) Close() error {
+	bo.mu.Lock()
+	if bo.closed {
+		bo.mu.Unlock()
+		return nil
+	}
+	bo.closed = true
+	close(bo.closing)
+	bo.mu.Unlock()
+	bo.waiting.Wait()
+	close(bo.queue)
+	if waitDrained(bo.queue, bo.done) {
+		return nil
+	}
+	close(bo.stop)
+	<-bo.done
+	return fmt.Errorf("gave up sending queued messages to %s", bo.name)
+}
+
+// doHTTP makes an HTTP request, returning the body of the response if it
+// was successful. Errors other than the server being busy or failing are
+// permanent.
+func doHTTP(req *http.Request) ([]byte, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return body, nil
+	}
+	if len(body) > 200 {
+		body = body[:200]
+	}
+	err = fmt.Errorf("%s: %s", resp.Status, body)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return nil, err
+	}
+	return nil, permanentError{err}
+}
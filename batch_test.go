@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBatchOutputCloseWhileWaiting(t *testing.T) {
+	defer func(buffer, batch int, wait time.Duration) {
+		syslogBuffer, maxBatch, queueWaitTimeout, queueWait = buffer, batch, wait, false
+	}(syslogBuffer, maxBatch, queueWaitTimeout)
+	syslogBuffer, maxBatch, queueWaitTimeout, queueWait = 1, 1, 100*time.Millisecond, true
+
+	// An output which is down, so that its queue fills
+	bo := newBatchOutput("test", func(evs []*Event) error {
+		return errors.New("down")
+	})
+	for i := 0; i < 2; i++ {
+		if err := bo.Write(newEvent(LOG_INFO, "queued")); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+	// Give run time to take the first event, so that the second fills the
+	// queue
+	time.Sleep(50 * time.Millisecond)
+	if err := bo.Write(newEvent(LOG_INFO, "no room")); err == nil {
+		t.Error("write to a full queue didn't give up")
+	}
+
+	written := make(chan error)
+	go func() { written <- bo.Write(newEvent(LOG_INFO, "waiting")) }()
+	time.Sleep(20 * time.Millisecond)
+	closed := make(chan error)
+	go func() { closed <- bo.Close() }()
+	select {
+	case err := <-written:
+		if err == nil {
+			t.Error("write waiting for room succeeded after close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("write waiting for room wasn't stopped by close")
+	}
+	select {
+	case err := <-closed:
+		if err == nil {
+			t.Error("close of an output which is down succeeded")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("close didn't give up on an output which is down")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// Minimum time to spend measuring overall throughput, and each rule, so that
+// small corpora still give stable numbers.
+const (
+	benchTime     = time.Second
+	ruleBenchTime = 100 * time.Millisecond
+)
+
+// benchRules reads a corpus of Domino output from the named files, or
+// standard input if there are none, and measures how quickly the current
+// rules classify it, both overall and rule by rule. It returns the exit
+// status for the program.
+func benchRules(files []string) int {
+	var msgs, tasks []string
+	add := func(r io.Reader) error {
+		scanner := newLineScanner(r)
+		for scanner.Scan() {
+			if _, _, _, msg, ok := parseLine(scanner.Bytes()); ok {
+				msgs = append(msgs, msg)
+				tasks = append(tasks, extractTask(msg))
+			}
+		}
+		return scanner.Err()
+	}
+	if len(files) == 0 {
+		if err := add(decodeStream(os.Stdin)); err != nil {
+			fmt.Fprintln(os.Stderr, "error reading input:", err)
+			return 1
+		}
+	}
+	for _, filename := range files {
+		f, err := openLog(filename)
+		if err == nil {
+			err = add(f)
+			f.Close()
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if len(msgs) == 0 {
+		fmt.Fprintln(os.Stderr, "no lines to benchmark")
+		return 1
+	}
+
+	// Overall throughput
+	elapsed, passes := measure(benchTime, func() {
+		for i, msg := range msgs {
+			classify(msg, tasks[i], nil)
+		}
+	})
+	lines := float64(len(msgs) * passes)
+	fmt.Printf("%d lines, %d rules: %.0f lines/sec, %s per line\n",
+		len(msgs), len(rules), lines/elapsed.Seconds(),
+		time.Duration(float64(elapsed)/lines))
+
+	// Cost of each rule, matching every line against it
+	type ruleCost struct {
+		rule    *Rule
+		perLine time.Duration
+		matched int
+	}
+	rulesLock.RLock()
+	costs := make([]ruleCost, len(rules))
+	for i := range rules {
+		rule := &rules[i]
+		matched := 0
+		elapsed, passes := measure(ruleBenchTime, func() {
+			matched = 0
+			for j, msg := range msgs {
+				if rule.matches(msg, tasks[j]) {
+					matched++
+				}
+			}
+		})
+		costs[i] = ruleCost{rule, time.Duration(float64(elapsed) / float64(len(msgs)*passes)), matched}
+	}
+	rulesLock.RUnlock()
+	sort.SliceStable(costs, func(i, j int) bool {
+		return costs[i].perLine > costs[j].perLine
+	})
+	fmt.Printf("\n%12s %8s  rule\n", "per line", "matches")
+	for _, c := range costs {
+		fmt.Printf("%12s %8d  %s %q\n", c.perLine, c.matched, c.rule.src, c.rule.re.String())
+	}
+	return 0
+}
+
+// measure runs f repeatedly until at least d has passed, returning the time
+// taken and the number of runs.
+func measure(d time.Duration, f func()) (time.Duration, int) {
+	start := time.Now()
+	passes := 0
+	for time.Since(start) < d {
+		f()
+		passes++
+	}
+	return time.Since(start), passes
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+func init() {
+	syslogFormatters["cef"] = formatCEF
+}
+
+// cefFields maps the names of fields which rules commonly extract to CEF's
+// predefined extension keys, so that ArcSight and other SIEMs recognize
+// them.
+var cefFields = map[string]string{
+	"user":     "suser",
+	"db":       "filePath",
+	"database": "filePath",
+	"ip":       "src",
+	"addr":     "src",
+	"address":  "src",
+	"src_ip":   "src",
+}
+
+// Characters escaped in CEF headers and in extension values.
+var (
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, "|", `\|`, "\r", " ", "\n", " ")
+	cefValueEscaper  = strings.NewReplacer(`\`, `\\`, "=", `\=`, "\r", `\r`, "\n", `\n`)
+)
+
+// formatCEF formats an event as an ArcSight CEF event, with a syslog header
+// as for LEEF. The Domino task is the signature ID, the message is the
+// name, and the severity is on LEEF's scale of 1 to 10, which CEF shares.
+// Fields with names in cefFields are given CEF's keys for them; others keep
+// their own.
+func formatCEF(ev *Event) string {
+	eventID := ev.Task
+	if eventID == "" {
+		eventID = "console"
+	}
+	name, _, _ := strings.Cut(ev.Message, "\n")
+	var b strings.Builder
+	fmt.Fprintf(&b, "CEF:0|HCL|Domino|%s|%s|%s|%d|", cefHeaderEscaper.Replace(version),
+		cefHeaderEscaper.Replace(eventID), cefHeaderEscaper.Replace(name), leefSeverities[ev.Priority&7])
+	first := true
+	ext := func(key, value string) {
+		if value == "" {
+			return
+		}
+		if !first {
+			b.WriteByte(' ')
+		}
+		first = false
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(cefValueEscaper.Replace(value))
+	}
+	ext("rt", fmt.Sprint(ev.Time.UnixMilli()))
+	ext("dvchost", ev.host())
+	ext("cat", priorityName(ev.Priority))
+	ext("task", ev.Task)
+	ext("thread", ev.Thread)
+	ext("dominoTimestamp", ev.Timestamp)
+	keys := make([]string, 0, len(ev.Fields))
+	for k := range ev.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if name, ok := cefFields[k]; ok {
+			ext(name, ev.Fields[k])
+		} else {
+			ext(k, ev.Fields[k])
+		}
+	}
+	ext("rule", ev.Rule)
+	ext("msg", ev.Message)
+	return fmt.Sprintf("<%d>%s %s %s", ev.Facility|ev.Priority, ev.Time.Format("Jan _2 15:04:05"), ev.host(), b.String())
+}
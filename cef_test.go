@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatCEF(t *testing.T) {
+	defer func(v string) { version = v }(version)
+	version = "1.2.3"
+	at := time.Date(2026, 10, 16, 9, 0, 0, 123000000, time.UTC)
+	tests := []struct {
+		name string
+		ev   Event
+		want string
+	}{
+		{
+			name: "fields",
+			ev: Event{Time: at, Host: "mail1", Facility: LOG_AUTH, Priority: LOG_WARNING, Task: "HTTP Server",
+				Message: "HTTP Server: ATTEMPT TO ACCESS SERVER by CN=Joe Bloggs/O=Example was denied",
+				Fields:  map[string]string{"user": "CN=Joe Bloggs/O=Example"}, Rule: "profile:verbose-security:4"},
+			want: `<36>Oct 16 09:00:00 mail1 CEF:0|HCL|Domino|1.2.3|HTTP Server|HTTP Server: ATTEMPT TO ACCESS SERVER by CN=Joe Bloggs/O=Example was denied|5|` +
+				`rt=1792141200123 dvchost=mail1 cat=warning task=HTTP Server suser=CN\=Joe Bloggs/O\=Example rule=profile:verbose-security:4 ` +
+				`msg=HTTP Server: ATTEMPT TO ACCESS SERVER by CN\=Joe Bloggs/O\=Example was denied`,
+		},
+		{
+			name: "no task",
+			ev:   Event{Time: at, Host: "mail1", Facility: LOG_DAEMON, Priority: LOG_CRIT, Message: "PANIC: out of memory", Thread: "[0A1C:0002-0B3C]"},
+			want: `<26>Oct 16 09:00:00 mail1 CEF:0|HCL|Domino|1.2.3|console|PANIC: out of memory|8|rt=1792141200123 dvchost=mail1 cat=crit thread=[0A1C:0002-0B3C] msg=PANIC: out of memory`,
+		},
+		{
+			name: "escaping",
+			ev: Event{Time: at, Host: "mail1", Facility: LOG_USER, Priority: LOG_INFO, Task: "a|b",
+				Message: `C:\notes|data` + "\nsecond line", Fields: map[string]string{"db": `C:\x.nsf`, "src_ip": "10.1.2.3"}},
+			want: `<14>Oct 16 09:00:00 mail1 CEF:0|HCL|Domino|1.2.3|a\|b|C:\\notes\|data|2|rt=1792141200123 dvchost=mail1 cat=info task=a|b ` +
+				`filePath=C:\\x.nsf src=10.1.2.3 msg=C:\\notes|data\nsecond line`,
+		},
+	}
+	for _, tt := range tests {
+		if got := formatCEF(&tt.ev); got != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+}
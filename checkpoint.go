@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Directory where tail keeps a checkpoint for each file it follows, saying
+// how far it's got, so that it carries on from there when it's restarted,
+// rather than skipping what was written meanwhile. There are none if it's
+// empty.
+var tailStateDir string
+
+// tailCheckpoint is how far a file has been read. Since files don't have an
+// identity which is the same everywhere, the file is recognized by the
+// bytes just before the offset instead, which also finds the copy Domino
+// made if it was rotated meanwhile.
+type tailCheckpoint struct {
+	File   string `json:"file"`
+	Offset int64  `json:"offset"` // of the end of the last complete line
+	Recent []byte `json:"recent"` // the bytes just before it
+}
+
+// checkpointNames makes a file name for a checkpoint out of the path of the
+// file followed.
+var checkpointNames = strings.NewReplacer("/", "_", `\`, "_", ":", "_")
+
+// resume opens the file where its checkpoint says we'd got to, if there is
+// one, reading what's left of the file it was taken for from Domino's copy
+// if it's been rotated since. It reports whether there was a checkpoint.
+func (t *tailer) resume(out Output) (bool, error) {
+	if tailStateDir == "" {
+		return false, nil
+	}
+	abs, err := filepath.Abs(t.path)
+	if err != nil {
+		return false, err
+	}
+	t.checkpoint = filepath.Join(tailStateDir, checkpointNames.Replace(abs)+".json")
+	data, err := os.ReadFile(t.checkpoint)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	var cp tailCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		fmt.Fprintf(os.Stderr, "ignoring unreadable checkpoint %s: %s\n", t.checkpoint, err)
+		return false, nil
+	}
+	if cp.Offset > 0 && len(cp.Recent) == 0 {
+		// Any file at least that long would match
+		fmt.Fprintf(os.Stderr, "ignoring checkpoint %s, which has nothing to recognize the file by\n", t.checkpoint)
+		return false, nil
+	}
+	if err := t.open(io.SeekStart); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if t.f != nil && matchesAt(t.f, cp.Offset, cp.Recent) {
+		if _, err := t.f.Seek(cp.Offset, io.SeekStart); err != nil {
+			return false, err
+		}
+		t.r.Reset(t.f)
+		t.offset, t.recent = cp.Offset, cp.Recent
+		fmt.Fprintf(os.Stderr, "resuming %s at offset %d\n", t.path, cp.Offset)
+		return true, nil
+	}
+	// It's been rotated or replaced since
+	t.close()
+	t.offset, t.recent = cp.Offset, cp.Recent
+	if err := t.readRotated(out); err != nil {
+		return false, err
+	}
+	t.flush(out)
+	t.close()
+	fmt.Fprintf(os.Stderr, "%s has changed since the checkpoint, reading it from the start\n", t.path)
+	if err := t.open(io.SeekStart); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// save saves a checkpoint, if there's somewhere to and anything's been read
+// since the last one. A line which hasn't been finished is left to be read
+// again.
+func (t *tailer) save() {
+	if t.checkpoint == "" || !t.dirty {
+		return
+	}
+	cp := tailCheckpoint{File: t.path, Offset: t.offset - int64(len(t.partial))}
+	if n := len(t.recent) - len(t.partial); n > 0 {
+		cp.Recent = t.recent[:n]
+	} else if cp.Offset > 0 && t.f != nil {
+		// The unfinished line is longer than the bytes we keep, so read
+		// those before it from the file again
+		buf := make([]byte, min(tailRecent, cp.Offset))
+		if _, err := t.f.ReadAt(buf, cp.Offset-int64(len(buf))); err == nil {
+			cp.Recent = buf
+		}
+	}
+	data, err := json.Marshal(cp)
+	if err == nil {
+		// Replace it all at once, so it's never left half written
+		tmp := t.checkpoint + ".tmp"
+		if err = os.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, t.checkpoint)
+		}
+	}
+	if err != nil {
+		if !t.warned {
+			fmt.Fprintf(os.Stderr, "can't save checkpoint for %s: %s\n", t.path, err)
+			t.warned = true
+		}
+		return
+	}
+	t.dirty, t.warned = false, false
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckpointLongPartialLine(t *testing.T) {
+	defer func(dir string) { tailStateDir = dir }(tailStateDir)
+	tailStateDir = t.TempDir()
+	path := filepath.Join(t.TempDir(), "console.log")
+	first := strings.Repeat("a", 2*tailRecent) + "\n"
+	unfinished := strings.Repeat("b", 2*tailRecent)
+	if err := os.WriteFile(path, []byte(first+unfinished), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := &tailer{path: path, handle: func([]byte, *source, Output) {}}
+	if _, err := tr.resume(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.open(io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.close()
+	if err := tr.read(nil); err != nil {
+		t.Fatal(err)
+	}
+	tr.save()
+	data, err := os.ReadFile(tr.checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cp tailCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		t.Fatal(err)
+	}
+	if cp.Offset != int64(len(first)) {
+		t.Errorf("offset %d, want %d", cp.Offset, len(first))
+	}
+	if want := first[len(first)-tailRecent:]; !bytes.Equal(cp.Recent, []byte(want)) {
+		t.Errorf("recent bytes %q, want %q", cp.Recent, want)
+	}
+}
+
+func TestCheckpointWithoutRecent(t *testing.T) {
+	defer func(dir string) { tailStateDir = dir }(tailStateDir)
+	tailStateDir = t.TempDir()
+	path := filepath.Join(t.TempDir(), "console.log")
+	if err := os.WriteFile(path, []byte("replaced\nafter rotation\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(tailCheckpoint{File: path, Offset: 9})
+	if err := os.WriteFile(filepath.Join(tailStateDir, checkpointNames.Replace(abs)+".json"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := &tailer{path: path}
+	resumed, err := tr.resume(nil)
+	defer tr.close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed {
+		t.Errorf("resumed at offset %d from a checkpoint with nothing to check", tr.offset)
+	}
+}
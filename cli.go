@@ -0,0 +1,429 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"text/template"
+)
+
+// Version number, set at build time with
+// -ldflags "-X main.version=1.2.3".
+var version = "dev"
+
+// Settings from flags and environment variables which aren't stored
+// directly in the variables they control.
+var (
+	rulesFile      string
+	facilityFlag   = "news"
+	priorityFlag   = "info"
+	stderrFlag     = "warning"
+	dominoServer   = defaultDominoServer
+	timestampFlags stringList
+	templateFlag   string
+)
+
+// stringList is a flag which can be given more than once, collecting all
+// the values.
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ", ")
+}
+
+func (sl *stringList) Set(value string) error {
+	*sl = append(*sl, value)
+	return nil
+}
+
+// addFlags defines the common flags on a flag set. They can be given either
+// before the command name or after it, so they're defined on each command's
+// flag set as well as the global one, bound to the same variables.
+func addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&rulesFile, "rules", rulesFile, "load classification rules from YAML `file`")
+	fs.StringVar(&profileName, "profile", profileName, "use the bundled rules profile `name`: "+strings.Join(profileNames(), ", "))
+	fs.StringVar(&fieldsFormat, "fields", fieldsFormat, "add fields extracted by rules as `format` sd or json")
+	fs.StringVar(&extractFlag, "extract", extractFlag, "work out `fields` from the text of every message, as a comma-separated list of "+strings.Join(extractorNames(), ", "))
+	fs.BoolVar(&sequenceIDs, "sequence", sequenceIDs, "add a sequence number and unique ID to each message, as the fields seq and uuid")
+	fs.StringVar(&templateFlag, "message-template", templateFlag, "format the text of messages with Go `template`, such as {{.Task}}: {{.Message}}")
+	fs.DurationVar(&minAccuracy, "accuracy", minAccuracy, "keep Domino's timestamp if it's more than `duration` from now")
+	fs.StringVar(&logTag, "tag", logTag, "syslog `tag` to log with")
+	fs.StringVar(&facilityFlag, "facility", facilityFlag, "default syslog `facility`, such as daemon or local0")
+	fs.StringVar(&priorityFlag, "default-priority", priorityFlag, "syslog `priority` for lines which match no rule")
+	fs.StringVar(&stderrFlag, "stderr-priority", stderrFlag, "syslog `priority` for lines written to standard error which match no rule")
+	fs.StringVar(&encodingName, "encoding", encodingName, "character set `name` Domino writes, such as utf-8, latin1, cp1252 or utf-16le, or auto to take lines which are valid UTF-8 as UTF-8 and others as cp1252")
+	fs.BoolVar(&crashSummary, "crash-summary", crashSummary, "collect the messages of a server crash, from the fatal error through NSD to fault recovery, into one alert summarizing it")
+	fs.BoolVar(&crashWebhooks, "crash-webhooks", crashWebhooks, "with -crash-summary, post to webhook outputs as soon as a crash starts, without waiting for the summary")
+	fs.BoolVar(&multiline, "multiline", multiline, "log Java stack traces and indented lines along with the line before them, as one message classified by its first line")
+	fs.IntVar(&maxLineSize, "max-line", maxLineSize, "cut lines of Domino output longer than `bytes` short, flagging them with the field truncated")
+	fs.StringVar(&dominoTZ, "domino-tz", dominoTZ, "IANA time `zone` Domino's timestamps are in, such as Europe/Berlin, if not the local one")
+	fs.Var(&timestampFlags, "timestamp-format", "Go time `layout` of Domino timestamps, or us, european or iso, rather than working it out; may be repeated to try several")
+	fs.StringVar(&healthAddr, "health-addr", healthAddr, "with the sidecar command, serve liveness and readiness checks at /healthz and /readyz on `address`")
+	fs.StringVar(&tailStateDir, "tail-state", tailStateDir, "keep checkpoints of how far files being followed have been read in `directory`, to carry on from there when restarted")
+	fs.Var(&inputSpecs, "input", "with the inputs command, read from `input`: "+strings.Join(inputTypeNames(), ", ")+", followed by :where, and optionally followed by ;tag= and ;rules=; may be repeated")
+	fs.Var(&outputSpecs, "output", "deliver to `output`: "+strings.Join(outputTypeNames(), ", ")+", optionally followed by :where and ;options; may be repeated to deliver to several")
+	fs.StringVar(&syslogAddr, "syslog-addr", syslogAddr, "send to remote syslog at `url`, such as udp://collector:514")
+	fs.StringVar(&syslogFormat, "syslog-format", syslogFormat, "syslog message `format`: 3164, 5424, leef for QRadar, or cef")
+	fs.StringVar(&syslogCA, "syslog-ca", syslogCA, "trust certificate authorities in PEM `file` for TLS syslog")
+	fs.StringVar(&syslogCert, "syslog-cert", syslogCert, "present client certificate in PEM `file` to TLS syslog")
+	fs.StringVar(&syslogKey, "syslog-key", syslogKey, "private key in PEM `file` for the client certificate")
+	fs.StringVar(&syslogServerName, "syslog-server-name", syslogServerName, "`name` to verify the TLS syslog server's certificate against, if not its host name")
+	fs.IntVar(&syslogBuffer, "syslog-buffer", syslogBuffer, "queue up to `count` messages while a TCP, TLS or RELP server is unavailable")
+	fs.IntVar(&retryAttempts, "retry-attempts", retryAttempts, "give up sending messages to a network output after `count` attempts, or 0 to keep trying")
+	fs.DurationVar(&retryBackoff, "retry-backoff", retryBackoff, "wait `duration` before retrying a network output, doubling each time")
+	fs.DurationVar(&retryMaxBackoff, "retry-max-backoff", retryMaxBackoff, "wait at most `duration` between retries")
+	fs.Float64Var(&retryJitter, "retry-jitter", retryJitter, "vary the wait between retries at random by up to `fraction` of it")
+	fs.IntVar(&maxBatch, "batch-size", maxBatch, "send at most `count` messages at once to outputs which send batches, such as Loki")
+	fs.IntVar(&maxBatchBytes, "batch-bytes", maxBatchBytes, "send roughly at most `size` bytes of messages at once, or 0 for no limit")
+	fs.DurationVar(&batchInterval, "batch-latency", batchInterval, "wait at most `duration` for a batch of messages to fill before sending it")
+	fs.StringVar(&speedFlag, "speed", speedFlag, "with replay and replay-dead-letters, send old messages at `speed`: max, realtime, or a multiple of real time such as 10x")
+	fs.StringVar(&fromFlag, "from", fromFlag, "with replay and replay-dead-letters, only send messages logged at or after `time`, such as 2026-10-16 09:30")
+	fs.StringVar(&toFlag, "to", toFlag, "with replay and replay-dead-letters, only send messages logged at or before `time`")
+	fs.StringVar(&deadLetterFile, "dead-letter", deadLetterFile, "append messages which outputs give up on to `file`, as JSON lines")
+	fs.StringVar(&dominoServer, "domino", dominoServer, "`path` of the Domino server script to run")
+	fs.Var(&partitionSpecs, "partition", "with the partitions command, run the Domino partition `name`, followed by ;data= its data directory, and optionally ;user=, ;domino=, ;tag= and ;rules=; may be repeated")
+}
+
+// applyFlags checks the settings from the flags, and sets up everything
+// which depends on them, including loading the rules.
+func applyFlags() error {
+	if err := checkSettings(); err != nil {
+		return err
+	}
+	newrules, err := loadConfiguredRules()
+	if err != nil {
+		return fmt.Errorf("error loading rules: %s", err)
+	}
+	setRules(newrules)
+	return nil
+}
+
+// checkSettings checks the settings from the flags, other than the rules
+// file, and sets up the variables which depend on them.
+func checkSettings() error {
+	timestampFormats = expandTimestampFormats(timestampFlags)
+
+	var err error
+	defaultPriority, err = parsePriority(priorityFlag)
+	if err != nil {
+		return err
+	}
+	stderrPriority, err = parsePriority(stderrFlag)
+	if err != nil {
+		return err
+	}
+	facility, err = parseFacility(facilityFlag)
+	if err != nil {
+		return err
+	}
+	if err := setEncoding(encodingName); err != nil {
+		return err
+	}
+	if err := setDominoTZ(dominoTZ); err != nil {
+		return err
+	}
+	if crashWebhooks && !crashSummary {
+		return fmt.Errorf("-crash-webhooks needs -crash-summary")
+	}
+	if maxLineSize < minLineSize {
+		return fmt.Errorf("-max-line must be at least %d bytes", minLineSize)
+	}
+	if fieldsFormat != "sd" && fieldsFormat != "json" {
+		return fmt.Errorf("unknown fields format %q", fieldsFormat)
+	}
+	if err := checkExtract(); err != nil {
+		return err
+	}
+	messageTemplate = nil
+	if templateFlag != "" {
+		if messageTemplate, err = template.New("message").Parse(templateFlag); err != nil {
+			return fmt.Errorf("bad message template: %s", err)
+		}
+	}
+	if err := checkInputs(); err != nil {
+		return err
+	}
+	if err := checkPartitions(); err != nil {
+		return err
+	}
+	if err := checkOutputs(); err != nil {
+		return err
+	}
+	if err := checkRetrySettings(); err != nil {
+		return err
+	}
+	if err := checkBatchSettings(); err != nil {
+		return err
+	}
+	if err := checkReplaySettings(); err != nil {
+		return err
+	}
+	if _, ok := syslogFormatters[syslogFormat]; !ok {
+		return fmt.Errorf("unknown syslog format %q", syslogFormat)
+	}
+	if syslogAddr != "" {
+		network, hostport, err := parseSyslogAddr(syslogAddr)
+		if err != nil {
+			return err
+		}
+		if network == "tls" {
+			if _, err := syslogTLSConfig(hostport); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// command is a subcommand of the program.
+type command struct {
+	args  string // summary of the arguments, for usage messages
+	help  string
+	setup bool // whether applyFlags is needed before run
+	// If raw is set, the command's arguments are passed to it as they are,
+	// without looking for flags.
+	raw bool
+	run func(args []string) int
+}
+
+// The subcommands, by name.
+var commands = map[string]command{
+	"server": {
+		args:  "[domino-args...]",
+		help:  "run the Domino server, logging its output (the default)",
+		setup: true,
+		raw:   true,
+		run:   runServer,
+	},
+	"run": {
+		args:  "[flags] [--] command [args...]",
+		help:  "run any command, logging its output",
+		setup: true,
+		run:   runAny,
+	},
+	"check-config": {
+		args: "[flags]",
+		help: "check the rules file for errors",
+		run: func(args []string) int {
+			return checkConfig()
+		},
+	},
+	"test-rule": {
+		args:  "[flags] [file...]",
+		help:  "show how the rules classify sample lines of output",
+		setup: true,
+		run:   testRules,
+	},
+	"inputs": {
+		args:  "[flags]",
+		help:  "read every input given with -input at once",
+		setup: true,
+		run:   runInputs,
+	},
+	"partitions": {
+		args:  "[flags]",
+		help:  "run the Domino server of every partition given with -partition",
+		setup: true,
+		run:   runPartitions,
+	},
+	"pipe": {
+		args:  "[flags]",
+		help:  "log lines read from standard input",
+		setup: true,
+		run:   pipeInput,
+	},
+	"tail": {
+		args:  "[flags] [file]",
+		help:  "follow Domino's console log, logging lines as they're added",
+		setup: true,
+		run:   tailConsoleLog,
+	},
+	"sidecar": {
+		args:  "[flags] [file]",
+		help:  "follow Domino's console log from a Kubernetes sidecar, serving health checks",
+		setup: true,
+		run:   runSidecar,
+	},
+	"replay": {
+		args:  "[flags] file...",
+		help:  "log archived console logs, with the times of Domino's timestamps",
+		setup: true,
+		run:   replayLogs,
+	},
+	"replay-dead-letters": {
+		args:  "[flags] [file...]",
+		help:  "deliver the messages in dead-letter files again",
+		setup: true,
+		run:   replayDeadLetters,
+	},
+	"bench-rules": {
+		args:  "[flags] [file...]",
+		help:  "measure how quickly the rules classify sample output",
+		setup: true,
+		run:   benchRules,
+	},
+	"version": {
+		help: "print the version number",
+		run: func(args []string) int {
+			fmt.Printf("domino2syslog %s\n", version)
+			return 0
+		},
+	},
+}
+
+// usage prints a summary of the commands and flags.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: domino2syslog [flags] [command] [args...]\n\ncommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := commands[name]
+		fmt.Fprintf(out, "  %s %s\n    \t%s\n", name, cmd.args, cmd.help)
+	}
+	fmt.Fprintf(out, "\nflags, which can also be given after the command:\n")
+	flag.PrintDefaults()
+}
+
+// dispatch parses the command line and runs the command it asks for,
+// returning the exit status. With no command, an argument which isn't one,
+// or --, the Domino server is run with the arguments, as if we were the
+// Domino server script.
+func dispatch(args []string) int {
+	flag.Usage = usage
+	addFlags(flag.CommandLine)
+	if err := setFlagsFromEnv(flag.CommandLine); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	flag.StringVar(&configFile, "config", "", "read settings from YAML `file`")
+	if v, ok := os.LookupEnv(envName("config")); ok {
+		configFile = v
+	}
+	flags, args, passThrough := splitGlobalFlags(flag.CommandLine, args)
+	flag.CommandLine.Parse(flags)
+	if configFile != "" {
+		if err := loadConfig(flag.CommandLine, configFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	name := "server"
+	if len(args) > 0 && !passThrough {
+		if _, ok := commands[args[0]]; ok {
+			name = args[0]
+			args = args[1:]
+		}
+	}
+	cmd := commands[name]
+	if !cmd.raw {
+		fs := flag.NewFlagSet(name, flag.ExitOnError)
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "usage: domino2syslog %s %s\n", name, cmd.args)
+			fs.PrintDefaults()
+		}
+		addFlags(fs)
+		fs.Parse(args)
+		args = fs.Args()
+	}
+	if cmd.setup {
+		if err := applyFlags(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	return cmd.run(args)
+}
+
+// splitGlobalFlags splits the command line into our flags, which come
+// first, and the command and its arguments. Our flags end at the first
+// argument which isn't one of them, so that flags meant for the Domino
+// server, such as -jc, are passed on to it, or at --, after which everything
+// is passed to the server unchanged, as passThrough reports.
+func splitGlobalFlags(fs *flag.FlagSet, args []string) (flags, rest []string, passThrough bool) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return args[:i], args[i+1:], true
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			return args[:i], args[i:], false
+		}
+		name := strings.TrimLeft(arg, "-")
+		name, _, hasValue := strings.Cut(name, "=")
+		if name == "h" || name == "help" {
+			continue
+		}
+		f := fs.Lookup(name)
+		if f == nil {
+			return args[:i], args[i:], false
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !hasValue && !(ok && b.IsBoolFlag()) {
+			// The value is the next argument
+			i++
+		}
+	}
+	return args, nil, false
+}
+
+// runServer runs the Domino server from its usual place, with any arguments
+// we were given.
+func runServer(args []string) int {
+	return runLogged(append(serverCommand(dominoServer), args...))
+}
+
+// runAny runs an arbitrary command line.
+func runAny(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "run: no command given")
+		return 2
+	}
+	return runLogged(args)
+}
+
+// runLogged opens syslog, then runs a command, logging its output. It
+// returns the exit status for the program.
+func runLogged(cmdline []string) int {
+	return logInput(func(out Output) error {
+		return runCommand(cmdline, nil, out, commandStop())
+	})
+}
+
+// logInput opens the outputs, then calls input to log lines of Domino
+// output to them until there are no more, and reports the statistics. It
+// returns the exit status for the program.
+func logInput(input func(out Output) error) int {
+	out, err := openOutput()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer func() {
+		if cerr := out.Close(); cerr != nil {
+			fmt.Fprintln(os.Stderr, cerr)
+		}
+	}()
+
+	go handleSignals(out)
+
+	err = input(out)
+	flushGroups()
+	flushCrashes()
+
+	reportHits(out)
+	reportRetries(out)
+	if n := atomic.LoadUint64(&droppedLines); n > 0 {
+		notify(out, LOG_NOTICE, fmt.Sprintf("dropped %d lines matching drop rules", n))
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,54 @@
+//go:build !windows
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServerFlagsPassedThrough(t *testing.T) {
+	dir := t.TempDir()
+	server := filepath.Join(dir, "server")
+	if err := os.WriteFile(server, []byte("echo \"args: $*\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	logged := filepath.Join(dir, "logged.jsonl")
+	status := dispatch([]string{"-domino", server, "-output", "jsonl:" + logged, "-jc", "-tag", "x"})
+	if status != 0 {
+		t.Fatalf("exit status %d", status)
+	}
+	data, err := os.ReadFile(logged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"message":"args: -jc -tag x"`) {
+		t.Errorf("server wasn't given -jc -tag x; logged:\n%s", data)
+	}
+}
+
+func TestSplitGlobalFlags(t *testing.T) {
+	tests := []struct {
+		args        []string
+		flags, rest []string
+		passThrough bool
+	}{
+		{[]string{"-tag", "x", "tail", "f"}, []string{"-tag", "x"}, []string{"tail", "f"}, false},
+		{[]string{"-tag=x", "-multiline", "-jc"}, []string{"-tag=x", "-multiline"}, []string{"-jc"}, false},
+		{[]string{"-jc", "-tag", "x"}, []string{}, []string{"-jc", "-tag", "x"}, false},
+		{[]string{"-tag", "x", "--", "tail"}, []string{"-tag", "x"}, []string{"tail"}, true},
+		{[]string{"--tag", "x"}, []string{"--tag", "x"}, []string{}, false},
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	addFlags(fs)
+	for _, tt := range tests {
+		flags, rest, passThrough := splitGlobalFlags(fs, tt.args)
+		if strings.Join(flags, " ") != strings.Join(tt.flags, " ") ||
+			strings.Join(rest, " ") != strings.Join(tt.rest, " ") || passThrough != tt.passThrough {
+			t.Errorf("splitGlobalFlags(%q) = %q, %q, %v, want %q, %q, %v", tt.args, flags, rest, passThrough, tt.flags, tt.rest, tt.passThrough)
+		}
+	}
+}
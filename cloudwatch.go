@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Limits on what CloudWatch Logs accepts in one PutLogEvents request: the
+// total size of the messages, each counted as 26 bytes more than its
+// length, and the size of one message.
+const (
+	cloudWatchMaxBatch   = 1048576
+	cloudWatchOverhead   = 26
+	cloudWatchMaxMessage = 262144 - cloudWatchOverhead
+)
+
+func init() {
+	outputTypes["cloudwatch"] = func(arg string) (Output, error) {
+		return openCloudWatch(arg)
+	}
+}
+
+// cloudWatch sends events to a CloudWatch Logs log stream, as JSON so that
+// CloudWatch Logs Insights can query their fields.
+type cloudWatch struct {
+	group, stream string
+	region        string
+	endpoint      string
+	creds         awsCredentialCache
+	token         string // sequence token for the next request, if any
+}
+
+// openCloudWatch opens a CloudWatch Logs output, given the log group, such
+// as /domino/console?stream=mail1&region=eu-west-1. The log stream defaults
+// to the host name, and is created if need be; the region defaults to the
+// one in the environment, or the EC2 instance's. Credentials come from the
+// environment, or the instance's IAM role.
+func openCloudWatch(arg string) (Output, error) {
+	group, query, _ := strings.Cut(arg, "?")
+	if group == "" {
+		return nil, fmt.Errorf("CloudWatch output needs a log group, such as cloudwatch:/domino/console")
+	}
+	params := queryParams(query)
+	cw := &cloudWatch{
+		group:  group,
+		stream: params.Get("stream"),
+		region: params.Get("region"),
+	}
+	if cw.stream == "" {
+		cw.stream = hostname
+	}
+	if cw.region == "" {
+		var err error
+		if cw.region, err = awsRegion(); err != nil {
+			return nil, err
+		}
+	}
+	cw.endpoint = "https://logs." + cw.region + ".amazonaws.com/"
+	if endpoint := params.Get("endpoint"); endpoint != "" {
+		cw.endpoint = endpoint
+	}
+	return newBatchOutput("CloudWatch log group "+group, cw.send), nil
+}
+
+// awsError is an error returned by an AWS JSON API.
+type awsError struct {
+	status                int
+	Type                  string `json:"__type"`
+	Message               string `json:"message"`
+	ExpectedSequenceToken string `json:"expectedSequenceToken"`
+}
+
+func (e *awsError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Type, e.Message)
+}
+
+// cloudWatchEvent is a log event as sent to PutLogEvents.
+type cloudWatchEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+// send sends a batch of events, in as many requests as it takes to stay
+// within CloudWatch's limits. If a request fails, the events which haven't
+// been sent yet are returned to be tried again.
+func (cw *cloudWatch) send(evs []*Event) error {
+	// CloudWatch insists on events being in order
+	sorted := make([]*Event, len(evs))
+	copy(sorted, evs)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+	var batch []cloudWatchEvent
+	size, start := 0, 0
+	for i, ev := range sorted {
+		jev := newJSONEvent(ev)
+		jev.Host = ev.host()
+		msg, err := json.Marshal(jev)
+		if err != nil {
+			return permanentError{err}
+		}
+		if len(msg) > cloudWatchMaxMessage {
+			msg = msg[:cloudWatchMaxMessage]
+		}
+		if size+len(msg)+cloudWatchOverhead > cloudWatchMaxBatch {
+			if err := cw.put(batch); err != nil {
+				return retryFrom(sorted[start:], err)
+			}
+			batch, size, start = nil, 0, i
+		}
+		batch = append(batch, cloudWatchEvent{ev.Time.UnixNano() / 1e6, string(msg)})
+		size += len(msg) + cloudWatchOverhead
+	}
+	if err := cw.put(batch); err != nil {
+		return retryFrom(sorted[start:], err)
+	}
+	return nil
+}
+
+// retryFrom returns the error for a failed request, such that the events
+// which haven't been sent are tried again if it's worth it.
+func retryFrom(evs []*Event, err error) error {
+	var perr permanentError
+	if errors.As(err, &perr) {
+		return err
+	}
+	return partialError{evs, err}
+}
+
+// put sends a request with log events, creating the log stream if it
+// doesn't exist, and keeping track of sequence tokens for regions which
+// still need them.
+func (cw *cloudWatch) put(batch []cloudWatchEvent) error {
+	for attempt := 0; attempt < 3; attempt++ {
+		req := map[string]interface{}{
+			"logGroupName":  cw.group,
+			"logStreamName": cw.stream,
+			"logEvents":     batch,
+		}
+		if cw.token != "" {
+			req["sequenceToken"] = cw.token
+		}
+		var resp struct {
+			NextSequenceToken string `json:"nextSequenceToken"`
+		}
+		err := cw.call("PutLogEvents", req, &resp)
+		var aerr *awsError
+		if !errors.As(err, &aerr) {
+			if err == nil {
+				cw.token = resp.NextSequenceToken
+			}
+			return err
+		}
+		switch {
+		case strings.HasSuffix(aerr.Type, "InvalidSequenceTokenException"):
+			cw.token = aerr.ExpectedSequenceToken
+		case strings.HasSuffix(aerr.Type, "DataAlreadyAcceptedException"):
+			cw.token = aerr.ExpectedSequenceToken
+			return nil
+		case strings.HasSuffix(aerr.Type, "ResourceNotFoundException"):
+			if err := cw.createStream(); err != nil {
+				return err
+			}
+			cw.token = ""
+		case strings.HasSuffix(aerr.Type, "ThrottlingException"), strings.HasSuffix(aerr.Type, "ServiceUnavailableException"),
+			aerr.status >= 500:
+			return err
+		default:
+			return permanentError{err}
+		}
+	}
+	return fmt.Errorf("couldn't send to CloudWatch log stream %s", cw.stream)
+}
+
+// createStream creates our log stream. The log group has to exist already.
+func (cw *cloudWatch) createStream() error {
+	err := cw.call("CreateLogStream", map[string]string{
+		"logGroupName":  cw.group,
+		"logStreamName": cw.stream,
+	}, nil)
+	var aerr *awsError
+	if errors.As(err, &aerr) {
+		if strings.HasSuffix(aerr.Type, "ResourceAlreadyExistsException") {
+			return nil
+		}
+		if strings.HasSuffix(aerr.Type, "ResourceNotFoundException") {
+			return permanentError{fmt.Errorf("CloudWatch log group %s doesn't exist", cw.group)}
+		}
+	}
+	return err
+}
+
+// call makes a request to the CloudWatch Logs API.
+func (cw *cloudWatch) call(action string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return permanentError{err}
+	}
+	creds, err := cw.creds.get()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", cw.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "Logs_20140328."+action)
+	signAWS(req, body, creds, cw.region, "logs", time.Now())
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		aerr := &awsError{status: resp.StatusCode}
+		if json.Unmarshal(respBody, aerr) != nil || aerr.Type == "" {
+			aerr.Type = resp.Status
+			aerr.Message = string(respBody)
+		}
+		return aerr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
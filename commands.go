@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// pipeInput logs lines read from standard input until it's closed, so that
+// domino2syslog can go at the end of a pipeline. It returns the exit status
+// for the program.
+func pipeInput(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "pipe: reads standard input, and takes no arguments")
+		return 2
+	}
+	return logInput(func(out Output) error {
+		scanner := newLineScanner(decodeStream(os.Stdin))
+		for scanner.Scan() {
+			process(scanner.Bytes(), nil, scanner.output(out))
+		}
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintln(os.Stderr, "error reading standard input:", err)
+			return err
+		}
+		return nil
+	})
+}
+
+// checkConfig validates the rules file without starting anything, reporting
+// every problem found. It returns the exit status for the program, so that it
+// can be used in deployment scripts.
+func checkConfig() int {
+	// The configuration file has already been read to get this far, so
+	// just the other settings need checking.
+	if err := checkSettings(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if configFile != "" {
+		fmt.Printf("%s: OK\n", configFile)
+	}
+	status := 0
+	if rulesFile != "" {
+		status |= checkRules(rulesFile)
+	}
+	if profileName != "" {
+		status |= checkRules(profilePrefix + profileName)
+	}
+	if rulesFile == "" && profileName == "" {
+		fmt.Printf("no rules file specified, using the %s profile\n", defaultProfile)
+	}
+	return status
+}
+
+// checkRules checks a rules file, reporting every problem found, and
+// returns the exit status for the program.
+func checkRules(filename string) int {
+	newrules, errs := readRules(filename)
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "%s: %d error(s)\n", filename, len(errs))
+		return 1
+	}
+	fmt.Printf("%s: %d rules OK\n", filename, len(newrules.rules))
+	return 0
+}
+
+// testRules reads sample lines of Domino output from the named files, or
+// standard input if there are none, and prints how the current rules would
+// log each of them. It returns the exit status for the program.
+func testRules(files []string) int {
+	if len(files) == 0 {
+		testLines(decodeStream(os.Stdin))
+		return 0
+	}
+	status := 0
+	for _, filename := range files {
+		f, err := openLog(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		testLines(f)
+		f.Close()
+	}
+	return status
+}
+
+// testLines prints how each line read from r would be logged.
+func testLines(r io.Reader) {
+	scanner := newLineScanner(r)
+	for scanner.Scan() {
+		_, _, _, msg, ok := parseLine(scanner.Bytes())
+		if !ok {
+			continue
+		}
+		fmt.Println(msg)
+		task := extractTask(msg)
+		rule, newmsg := classify(msg, task, nil)
+		if newmsg != msg {
+			fmt.Printf("  rewritten: %s\n", newmsg)
+		}
+		if rule == nil {
+			fmt.Printf("  no rule matched: %s.%s\n", facilityName(facility), priorityName(limitPriority(task, defaultPriority)))
+			continue
+		}
+		fmt.Printf("  matched %s %q\n", rule.src, rule.re.String())
+		if rule.action == actionDrop {
+			fmt.Printf("  dropped\n")
+			continue
+		}
+		fac := facility
+		if rule.fac != noFacility {
+			fac = rule.fac
+		}
+		fmt.Printf("  logged as %s.%s\n", facilityName(fac), priorityName(limitPriority(task, rule.lvl)))
+		if fields := rule.fields(newmsg); len(fields) > 0 {
+			fmt.Printf("  fields: %s\n", formatSD(fields))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, "error reading input:", err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Path of the configuration file, if any.
+var configFile string
+
+// loadConfig reads a YAML configuration file and sets flags from it. The
+// keys are flag names, and the values are what would be given on the
+// command line, or a list of them for flags which can be repeated:
+//
+//	tag: domino-prod1
+//	facility: local3
+//	rules: /etc/domino2syslog/rules.yaml
+//	timestamp-format:
+//	  - "02.01.2006 15:04:05"
+//	  - "2006-01-02 15:04:05"
+//
+// Flags which have already been set, by environment variables or on the
+// command line, are left alone so that they override the file.
+func loadConfig(fs *flag.FlagSet, filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+	if len(doc.Content) == 0 {
+		// Empty file
+		return nil
+	}
+	top := doc.Content[0]
+	if top.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s:%d: configuration should be a mapping of settings", filename, top.Line)
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for i := 0; i+1 < len(top.Content); i += 2 {
+		key, value := top.Content[i], top.Content[i+1]
+		if fs.Lookup(key.Value) == nil || key.Value == "config" {
+			return fmt.Errorf("%s:%d: unknown setting %q", filename, key.Line, key.Value)
+		}
+		if set[key.Value] {
+			continue
+		}
+		values := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			values = value.Content
+		}
+		for _, v := range values {
+			if v.Kind != yaml.ScalarNode {
+				return fmt.Errorf("%s:%d: bad value for %s", filename, v.Line, key.Value)
+			}
+			if err := fs.Set(key.Value, v.Value); err != nil {
+				return fmt.Errorf("%s:%d: %s: %s", filename, v.Line, key.Value, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Whether to collect the messages Domino writes when it crashes, as it runs
+// NSD and fault recovery restarts it, into a single alert summarizing the
+// crash, as given with -crash-summary; and whether to post to webhooks as
+// soon as a crash starts, as given with -crash-webhooks, rather than waiting
+// for the summary.
+var crashSummary, crashWebhooks bool
+
+// How long after the last message of a crash it's taken to be over, and
+// summarized.
+const crashQuiet = 2 * time.Minute
+
+var (
+	// Messages which start a crash
+	crashStartRegex = regexp.MustCompile(`Fatal Error signal|^PANIC:|Running NSD`)
+	// Messages which are part of one, once it's started
+	crashRegex = regexp.MustCompile(`(?i)Fatal Error signal|^PANIC:|\bNSD\b|fault recovery|crash`)
+
+	// Details of the crash, for the summary
+	crashSignalRegex = regexp.MustCompile(`Fatal Error signal\s*=?\s*(0x[0-9A-Fa-f]+|\d+)`)
+	crashPIDRegex    = regexp.MustCompile(`PID/TID\s*=\s*(\d+)/(\d+)`)
+	crashPanicRegex  = regexp.MustCompile(`^PANIC:\s*(.*)`)
+	crashNSDRegex    = regexp.MustCompile(`(?i)(\S*nsd\S*\.log)`)
+)
+
+// crash is a crash of a Domino server whose messages are being collected.
+type crash struct {
+	first  *message
+	lines  []string
+	start  time.Time // when the first message was logged, if known
+	last   time.Time // when the last message was logged, if known
+	out    Output
+	stderr bool
+	timer  *time.Timer
+}
+
+// Crashes being collected, by the source their messages come from; with
+// listen, each forwarder connected has a source of its own, so that crashes
+// of servers on different hosts are summarized separately.
+var (
+	crashesLock sync.Mutex
+	crashes     = make(map[*source]*crash)
+)
+
+// watchCrash collects a message if it's part of a crash of the server the
+// source is reading from, reporting whether it has. The crash is summarized
+// once its messages stop, or when old messages are being read, once one is
+// logged long enough after them.
+func watchCrash(m *message, src *source, out Output, stderr bool) bool {
+	at := m.read
+	if src.backfill() {
+		// Zero if the line has no timestamp
+		at = m.ts
+	}
+	crashesLock.Lock()
+	c := crashes[src]
+	var ended *crash
+	if c != nil && !at.IsZero() && !c.last.IsZero() && at.Sub(c.last) > crashQuiet {
+		c.timer.Stop()
+		delete(crashes, src)
+		ended, c = c, nil
+	}
+	taken := true
+	switch {
+	case c == nil && crashStartRegex.MatchString(m.text):
+		c = &crash{first: m, out: out, stderr: stderr}
+		c.timer = time.AfterFunc(crashQuiet, func() { endCrash(src, c) })
+		crashes[src] = c
+		if crashWebhooks {
+			go postToWebhooks(c.event(src, "Domino server is crashing: "+m.text))
+		}
+	case c != nil && crashRegex.MatchString(m.text):
+		c.timer.Reset(crashQuiet)
+	default:
+		taken = false
+	}
+	if taken {
+		c.lines = append(c.lines, m.text)
+		if !at.IsZero() {
+			if c.start.IsZero() {
+				c.start = at
+			}
+			c.last = at
+		}
+	}
+	crashesLock.Unlock()
+	if ended != nil {
+		deliver(ended.out, ended.summary(src))
+	}
+	return taken
+}
+
+// endCrash logs the summary of a crash which no more messages have come for,
+// unless it's been logged already.
+func endCrash(src *source, c *crash) {
+	crashesLock.Lock()
+	if crashes[src] != c {
+		crashesLock.Unlock()
+		return
+	}
+	delete(crashes, src)
+	crashesLock.Unlock()
+	deliver(c.out, c.summary(src))
+}
+
+// flushCrashes logs the summaries of any crashes still being collected, once
+// the input has ended.
+func flushCrashes() {
+	crashesLock.Lock()
+	pending := crashes
+	crashes = make(map[*source]*crash)
+	crashesLock.Unlock()
+	for src, c := range pending {
+		c.timer.Stop()
+		deliver(c.out, c.summary(src))
+	}
+}
+
+// summary returns an alert summarizing the crash, with the signal, process
+// and thread, reason for panicking, and NSD output file as fields, when
+// they're known, followed by the messages it was summarized from.
+func (c *crash) summary(src *source) *Event {
+	fields := make(map[string]string)
+	for _, line := range c.lines {
+		if m := crashSignalRegex.FindStringSubmatch(line); m != nil && fields["signal"] == "" {
+			fields["signal"] = m[1]
+		}
+		if m := crashPIDRegex.FindStringSubmatch(line); m != nil && fields["pid"] == "" {
+			fields["pid"], fields["tid"] = m[1], m[2]
+		}
+		if m := crashPanicRegex.FindStringSubmatch(line); m != nil && fields["panic"] == "" {
+			fields["panic"] = m[1]
+		}
+		if m := crashNSDRegex.FindStringSubmatch(line); m != nil {
+			fields["nsd"] = m[1]
+		}
+	}
+	text := "Domino server crashed: " + c.lines[0]
+	if fields["nsd"] != "" {
+		text += fmt.Sprintf(" (NSD output in %s)", fields["nsd"])
+	}
+	ev := c.event(src, text+"\n"+strings.Join(c.lines, "\n"))
+	for k, v := range fields {
+		ev.Fields[k] = v
+	}
+	return ev
+}
+
+// event returns an alert about the crash, as if it came from its first
+// message.
+func (c *crash) event(src *source, text string) *Event {
+	ev := newEvent(LOG_ALERT, text)
+	if !c.start.IsZero() {
+		ev.Time = c.start
+	}
+	ev.Tag = src.logTag()
+	ev.Thread = c.first.threadid
+	ev.Timestamp = c.first.timestamp
+	ev.Fields = map[string]string{"crash": "true"}
+	if c.stderr {
+		ev.Fields["stream"] = "stderr"
+	}
+	return ev
+}
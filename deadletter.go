@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// File which events are appended to when an output gives up on them, if
+// any, so that they can be replayed later.
+var deadLetterFile string
+
+// deadLetterRecord is an event in the dead-letter file: the event as the
+// JSON Lines output writes it, along with the output which gave up on it
+// and why.
+type deadLetterRecord struct {
+	*jsonEvent
+	Output string `json:"output"`
+	Error  string `json:"error"`
+}
+
+// The dead-letter file, opened when it's first needed.
+var deadLetters struct {
+	sync.Mutex
+	f *os.File
+}
+
+// deadLetter appends events which an output has given up on to the
+// dead-letter file, if there is one.
+func deadLetter(name string, evs []*Event, cause error) {
+	if deadLetterFile == "" {
+		return
+	}
+	deadLetters.Lock()
+	defer deadLetters.Unlock()
+	if deadLetters.f == nil {
+		f, err := os.OpenFile(deadLetterFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "can't open dead-letter file, losing %d messages: %s\n", len(evs), err)
+			return
+		}
+		deadLetters.f = f
+	}
+	w := bufio.NewWriter(deadLetters.f)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, ev := range evs {
+		jev := newJSONEvent(ev)
+		jev.Host = ev.host()
+		enc.Encode(deadLetterRecord{jev, name, cause.Error()})
+	}
+	if err := w.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing to dead-letter file %s: %s\n", deadLetterFile, err)
+	}
+}
+
+// eventFromRecord turns a record from the dead-letter file back into an
+// event.
+func eventFromRecord(rec *deadLetterRecord) (*Event, error) {
+	t, err := time.Parse(time.RFC3339Nano, rec.Time)
+	if err != nil {
+		return nil, err
+	}
+	pri, err := parsePriority(rec.Severity)
+	if err != nil {
+		return nil, err
+	}
+	fac, err := parseFacility(rec.Facility)
+	if err != nil {
+		return nil, err
+	}
+	return &Event{
+		Time:      t,
+		Priority:  pri,
+		Facility:  fac,
+		Tag:       rec.Tag,
+		Task:      rec.Task,
+		Thread:    rec.Thread,
+		Timestamp: rec.Timestamp,
+		Message:   rec.Message,
+		Fields:    rec.Fields,
+		Rule:      rec.Rule,
+		Host:      rec.Host,
+	}, nil
+}
+
+// replayDeadLetters delivers the events in dead-letter files to the outputs
+// chosen by the flags. Each file is read in full before anything is sent,
+// so that events which fail again can be dead-lettered to the same file.
+// Only events logged between -from and -to are sent, at -speed. It returns
+// the exit status for the program.
+func replayDeadLetters(files []string) int {
+	if len(files) == 0 {
+		if deadLetterFile == "" {
+			fmt.Fprintln(os.Stderr, "replay-dead-letters: no dead-letter file given")
+			return 2
+		}
+		files = []string{deadLetterFile}
+	}
+	var evs []*Event
+	status := 0
+	for _, filename := range files {
+		f, err := openLog(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		// Messages can be up to -max-line long before they're escaped
+		scanner.Buffer(nil, 4*maxLineSize)
+		for line := 1; scanner.Scan(); line++ {
+			rec := deadLetterRecord{jsonEvent: &jsonEvent{}}
+			err := json.Unmarshal(scanner.Bytes(), &rec)
+			var ev *Event
+			if err == nil {
+				ev, err = eventFromRecord(&rec)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s:%d: %s\n", filename, line, err)
+				status = 1
+				continue
+			}
+			if inReplayWindow(ev.Time) {
+				evs = append(evs, ev)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", filename, err)
+			status = 1
+		}
+		f.Close()
+	}
+
+	// Wait for room to queue each event, and for them all to be sent
+	queueWait = true
+	replaying = true
+	out, err := openOutput()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var p pacer
+	for _, ev := range evs {
+		p.wait(ev.Time)
+		deliver(out, ev)
+	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "replayed %d messages\n", len(evs))
+	return status
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+func init() {
+	inputTypes["docker"] = func(container string) (input, error) {
+		if container == "" {
+			return nil, fmt.Errorf("docker input needs the name of the container to follow")
+		}
+		client, base, err := dockerClient(os.Getenv("DOCKER_HOST"))
+		if err != nil {
+			return nil, err
+		}
+		d := &dockerLogs{container: container, client: client, base: base}
+		return d.run, nil
+	}
+}
+
+// Where the Docker daemon listens, if DOCKER_HOST doesn't say.
+const defaultDockerHost = "unix:///var/run/docker.sock"
+
+// How long to wait before attaching to a container again, after it's
+// stopped or couldn't be attached to.
+const dockerRetryInterval = 5 * time.Second
+
+// dockerLogs follows the output of a Docker container, such as one running
+// the official Domino image, through the Docker API, without changing how
+// the container is run.
+type dockerLogs struct {
+	container string
+	client    *http.Client
+	base      string // URL of the API
+}
+
+// dockerClient makes an HTTP client for the Docker API at host, given as for
+// DOCKER_HOST, returning it with the URL to make requests to.
+func dockerClient(host string) (*http.Client, string, error) {
+	if host == "" {
+		host = defaultDockerHost
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, "", fmt.Errorf("bad Docker host %q: %s", host, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		path := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		}
+		return &http.Client{Transport: transport}, "http://docker", nil
+	case "tcp", "http":
+		return &http.Client{}, "http://" + u.Host, nil
+	}
+	return nil, "", fmt.Errorf("unsupported Docker host %q; should be unix:// or tcp://", host)
+}
+
+// run logs the container's output as it's written, until stop is closed.
+// Output written before it starts is skipped. When the container stops, or
+// can't be found, it's attached to again once it's running.
+func (d *dockerLogs) run(src *source, out Output, stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	since := time.Now()
+	var lastErr string
+	for {
+		err := d.follow(ctx, src, out, since)
+		if ctx.Err() != nil {
+			return nil
+		}
+		// Only complain about each problem once, rather than every retry
+		if err != nil && err.Error() != lastErr {
+			fmt.Fprintf(os.Stderr, "error following container %s: %s\n", d.container, err)
+			lastErr = err.Error()
+		} else if err == nil {
+			lastErr = ""
+		}
+		since = time.Now()
+		select {
+		case <-time.After(dockerRetryInterval):
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// follow logs the container's output since a time, until it stops.
+func (d *dockerLogs) follow(ctx context.Context, src *source, out Output, since time.Time) error {
+	var info struct {
+		Config struct {
+			Tty bool
+		}
+	}
+	resp, err := d.get(ctx, "/containers/"+url.PathEscape(d.container)+"/json")
+	if err != nil {
+		return err
+	}
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("can't read container details: %s", err)
+	}
+
+	q := url.Values{
+		"follow": {"1"},
+		"stdout": {"1"},
+		"stderr": {"1"},
+		"since":  {fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond())},
+	}
+	resp, err = d.get(ctx, "/containers/"+url.PathEscape(d.container)+"/logs?"+q.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if info.Config.Tty {
+		// Both streams come as they were written to the terminal
+		scanner := newLineScanner(decodeStream(resp.Body))
+		for scanner.Scan() {
+			process(scanner.Bytes(), src, scanner.output(out))
+		}
+		return d.ended(ctx, scanner.Err())
+	}
+	return d.ended(ctx, demuxDockerLogs(resp.Body, src, out))
+}
+
+// ended works out what an error reading the container's output means. It's
+// nil if the output just ended, or we've been stopped.
+func (d *dockerLogs) ended(ctx context.Context, err error) error {
+	if ctx.Err() != nil || err == nil || err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// get makes a request to the Docker API, returning the response if it
+// succeeded.
+func (d *dockerLogs) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var msg struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&msg)
+		resp.Body.Close()
+		if msg.Message == "" {
+			msg.Message = resp.Status
+		}
+		return nil, fmt.Errorf("Docker API: %s", msg.Message)
+	}
+	return resp, nil
+}
+
+// demuxDockerLogs logs the output of a container without a terminal, which
+// comes in frames, each with a header saying whether it's from standard
+// output or standard error, and how long it is.
+func demuxDockerLogs(r io.Reader, src *source, out Output) error {
+	streams := [2]dockerStream{{}, {stderr: true}}
+	defer func() {
+		for i := range streams {
+			streams[i].flush(src, out)
+		}
+	}()
+	br := bufio.NewReader(r)
+	header := make([]byte, 8)
+	var frame []byte
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			return err
+		}
+		n := binary.BigEndian.Uint32(header[4:])
+		if int64(n) > int64(maxLineSize) {
+			return fmt.Errorf("log frame of %d bytes is too long", n)
+		}
+		if cap(frame) < int(n) {
+			frame = make([]byte, n)
+		}
+		frame = frame[:n]
+		if _, err := io.ReadFull(br, frame); err != nil {
+			return err
+		}
+		// 1 is standard output, and 2 standard error
+		switch header[0] {
+		case 1:
+			streams[0].write(frame, src, out)
+		case 2:
+			streams[1].write(frame, src, out)
+		}
+	}
+}
+
+// dockerStream collects lines of one of a container's output streams from
+// the frames they come in.
+type dockerStream struct {
+	stderr     bool
+	partial    []byte // the start of a line which hasn't been finished yet
+	discarding bool   // whether the rest of a line which was too long is being skipped
+}
+
+// write logs the lines finished by some output. Lines longer than -max-line
+// are cut short, and the rest skipped.
+func (ds *dockerStream) write(data []byte, src *source, out Output) {
+	for {
+		i := bytes.IndexByte(data, '\n')
+		switch {
+		case i < 0 && ds.discarding:
+			return
+		case i < 0:
+			ds.partial = append(ds.partial, data...)
+			if len(ds.partial) > maxLineSize {
+				ds.flush(src, out)
+				ds.discarding = true
+			}
+			return
+		case ds.discarding:
+			ds.discarding = false
+		default:
+			ds.partial = append(ds.partial, data[:i+1]...)
+			ds.flush(src, out)
+		}
+		data = data[i+1:]
+	}
+}
+
+// flush logs the line written so far, if there is one, cutting it short if
+// it's too long.
+func (ds *dockerStream) flush(src *source, out Output) {
+	if len(ds.partial) == 0 {
+		return
+	}
+	for _, line := range splitCR(trimNewline(ds.partial)) {
+		lineOut := out
+		if len(line) > maxLineSize {
+			line, lineOut = cutLine(line), truncatedOutput{out}
+		}
+		processStream(line, src, lineOut, ds.stderr)
+	}
+	ds.partial = ds.partial[:0]
+}
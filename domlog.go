@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	inputTypes["domlog"] = func(pattern string) (input, error) {
+		if pattern == "" {
+			return nil, fmt.Errorf("domlog input needs a pattern for the access logs to follow")
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q for domlog input: %s", pattern, err)
+		}
+		return func(src *source, out Output, stop <-chan struct{}) error {
+			followMatching(pattern, stop, func(t *tailer) Output {
+				t.src = src
+				t.handle = processAccessLog
+				return out
+			})
+			return nil
+		}, nil
+	}
+}
+
+// Lines of Domino's HTTP access logs, in the common log format, with the
+// referrer and user agent of the extended format optionally following. The
+// user name is quoted if it has spaces, as Domino's names often do.
+var accessLogRegex = regexp.MustCompile(`^(\S+) (\S+) ("[^"]*"|\S+) \[([^\]]+)\] "([^"]*)" (\d{3}) (\d+|-)(?: "([^"]*)" "([^"]*)")?`)
+
+// Layout of the times in access logs.
+const accessLogTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// processAccessLog turns a line of Domino's HTTP access log into an event,
+// with the details of the request as fields, logged as an error if the
+// server failed, a notice if the client did, and info otherwise. Lines
+// which can't be parsed are logged as they are.
+func processAccessLog(line []byte, src *source, out Output) {
+	text := toUTF8(line)
+	m := accessLogRegex.FindStringSubmatchIndex(text)
+	if m == nil {
+		if strings.TrimSpace(text) == "" {
+			return
+		}
+		ev := newEvent(defaultPriority, text)
+		ev.Tag = src.logTag()
+		deliver(out, ev)
+		return
+	}
+	group := func(i int) string {
+		if m[2*i] < 0 {
+			return ""
+		}
+		return text[m[2*i]:m[2*i+1]]
+	}
+	status := group(6)
+	pri := LOG_INFO
+	switch status[0] {
+	case '5':
+		pri = LOG_ERR
+	case '4':
+		pri = LOG_NOTICE
+	}
+	// Everything but the time, which the event has
+	ev := newEvent(pri, text[:m[8]-2]+text[m[9]+1:])
+	ev.Tag = src.logTag()
+	ev.Task = "HTTP"
+	if t, err := time.Parse(accessLogTimeLayout, group(4)); err == nil {
+		ev.Time = t
+	}
+	ev.Fields = map[string]string{"client": group(1), "status": status}
+	if user := strings.Trim(group(3), `"`); user != "-" && user != "" {
+		ev.Fields["user"] = user
+	}
+	if request := strings.Fields(group(5)); len(request) >= 2 {
+		ev.Fields["method"] = request[0]
+		ev.Fields["uri"] = request[1]
+		if len(request) > 2 {
+			ev.Fields["protocol"] = request[2]
+		}
+	}
+	if n, err := strconv.Atoi(group(7)); err == nil {
+		ev.Fields["bytes"] = strconv.Itoa(n)
+	}
+	for i, name := range map[int]string{8: "referrer", 9: "agent"} {
+		if v := group(i); v != "" && v != "-" {
+			ev.Fields[name] = v
+		}
+	}
+	deliver(out, ev)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Default pattern for the names of Elasticsearch indices, with strftime
+// style date fields filled in from each event's time in UTC.
+const defaultESIndex = "domino-console-%Y.%m.%d"
+
+func init() {
+	outputTypes["elasticsearch"] = func(arg string) (Output, error) {
+		return openElasticsearch(arg)
+	}
+}
+
+// elasticsearch sends events to Elasticsearch with the bulk API.
+type elasticsearch struct {
+	name   string // for messages
+	url    string // of the _bulk endpoint
+	index  string
+	apiKey string
+	user   *url.Userinfo
+}
+
+// openElasticsearch opens an Elasticsearch output, given the URL of the
+// cluster, such as https://user:password@es:9200/?index=domino-%Y.%m. The
+// index pattern and an API key can be given as query parameters.
+func openElasticsearch(addr string) (Output, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("Elasticsearch address %q should be a URL, such as https://es:9200", addr)
+	}
+	params := queryParams(u.RawQuery)
+	es := &elasticsearch{
+		index:  params.Get("index"),
+		apiKey: params.Get("api_key"),
+		user:   u.User,
+	}
+	if es.index == "" {
+		es.index = defaultESIndex
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/_bulk"
+	es.url = u.String()
+	es.name = "Elasticsearch at " + u.Host
+	return newBatchOutput(es.name, es.send), nil
+}
+
+// esDocument is an event as stored in Elasticsearch.
+type esDocument struct {
+	Timestamp       string            `json:"@timestamp"`
+	Host            string            `json:"host"`
+	Severity        string            `json:"severity"`
+	Facility        string            `json:"facility"`
+	Tag             string            `json:"tag"`
+	Task            string            `json:"task,omitempty"`
+	Thread          string            `json:"thread,omitempty"`
+	DominoTimestamp string            `json:"domino_timestamp,omitempty"`
+	Message         string            `json:"message"`
+	Rule            string            `json:"rule,omitempty"`
+	Fields          map[string]string `json:"fields,omitempty"`
+}
+
+// esResponse is the part of a bulk API response we need.
+type esResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// send sends a batch of events in a bulk request. Events which are
+// rejected because Elasticsearch is too busy are returned to be sent again;
+// any rejected for other reasons are reported and dead-lettered.
+func (es *elasticsearch) send(evs []*Event) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	enc.SetEscapeHTML(false)
+	for _, ev := range evs {
+		action := map[string]map[string]string{"create": {"_index": expandIndex(es.index, ev.Time.UTC())}}
+		enc.Encode(action)
+		enc.Encode(esDocument{
+			Timestamp:       ev.Time.Format(time.RFC3339Nano),
+			Host:            ev.host(),
+			Severity:        priorityName(ev.Priority),
+			Facility:        facilityName(ev.Facility),
+			Tag:             ev.Tag,
+			Task:            ev.Task,
+			Thread:          ev.Thread,
+			DominoTimestamp: ev.Timestamp,
+			Message:         ev.Message,
+			Rule:            ev.Rule,
+			Fields:          ev.Fields,
+		})
+	}
+	req, err := http.NewRequest("POST", es.url, &body)
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if es.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+es.apiKey)
+	} else if es.user != nil {
+		password, _ := es.user.Password()
+		req.SetBasicAuth(es.user.Username(), password)
+	}
+	respBody, err := doHTTP(req)
+	if err != nil {
+		return err
+	}
+	var resp esResponse
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return permanentError{fmt.Errorf("can't understand bulk response: %s", err)}
+	}
+	if !resp.Errors {
+		return nil
+	}
+	var retry []*Event
+	var reason string
+	for i, item := range resp.Items {
+		for _, result := range item {
+			if result.Status < 300 || i >= len(evs) {
+				continue
+			}
+			if result.Status == http.StatusTooManyRequests || result.Status >= 500 {
+				retry = append(retry, evs[i])
+				continue
+			}
+			reason = result.Error.Type + ": " + result.Error.Reason
+			deadLetter(es.name, evs[i:i+1], fmt.Errorf("rejected with %s", reason))
+		}
+	}
+	if reason != "" {
+		fmt.Fprintf(os.Stderr, "Elasticsearch rejected messages: %s\n", reason)
+	}
+	if len(retry) > 0 {
+		return partialError{retry, fmt.Errorf("Elasticsearch was too busy for %d messages", len(retry))}
+	}
+	return nil
+}
+
+// expandIndex fills in the date fields in an index pattern: %Y, %m, %d and
+// %H for the year, month, day and hour, and %% for a percent sign.
+func expandIndex(pattern string, t time.Time) string {
+	if !strings.Contains(pattern, "%") {
+		return pattern
+	}
+	var sb strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		if c != '%' || i == len(pattern)-1 {
+			sb.WriteByte(c)
+			continue
+		}
+		i++
+		switch pattern[i] {
+		case 'Y':
+			fmt.Fprintf(&sb, "%04d", t.Year())
+		case 'm':
+			fmt.Fprintf(&sb, "%02d", t.Month())
+		case 'd':
+			fmt.Fprintf(&sb, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&sb, "%02d", t.Hour())
+		case '%':
+			sb.WriteByte('%')
+		default:
+			sb.WriteByte('%')
+			sb.WriteByte(pattern[i])
+		}
+	}
+	return sb.String()
+}
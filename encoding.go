@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// Character set of Domino's output, given with -encoding. With "auto", each
+// line which is valid UTF-8 is taken to be UTF-8, as newer versions of
+// Domino write, and any other is taken to be Windows-1252, as older ones
+// write.
+var encodingName = "auto"
+
+// The character set given with -encoding, or nil for auto.
+var textEncoding encoding.Encoding
+
+// Whether the character set is UTF-16LE, which has to be decoded before it
+// can be split into lines.
+var wideEncoding bool
+
+// UTF-16LE, as Domino writes on Windows.
+var utf16LE = unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+
+// Names of the character sets Domino is likely to use, which are looked up
+// before the names IANA knows them by.
+var encodingNames = map[string]encoding.Encoding{
+	"utf-8":        unicode.UTF8,
+	"utf8":         unicode.UTF8,
+	"latin1":       charmap.ISO8859_1,
+	"iso-8859-1":   charmap.ISO8859_1,
+	"cp1252":       charmap.Windows1252,
+	"windows-1252": charmap.Windows1252,
+	"utf-16le":     utf16LE,
+}
+
+// setEncoding sets the character set Domino's output is decoded from. Apart
+// from UTF-16LE, it has to be one in which a newline is a newline byte, so
+// that lines can be split before they're decoded.
+func setEncoding(name string) error {
+	textEncoding, wideEncoding = nil, false
+	name = strings.ToLower(name)
+	if name == "auto" {
+		return nil
+	}
+	enc, ok := encodingNames[name]
+	if !ok {
+		var err error
+		if enc, err = ianaindex.IANA.Encoding(name); err != nil || enc == nil {
+			return fmt.Errorf("unknown encoding %q", name)
+		}
+		if nl, err := enc.NewEncoder().Bytes([]byte("\n")); err != nil || string(nl) != "\n" {
+			return fmt.Errorf("can't read encoding %q; of the encodings which don't write newlines as a single byte, only utf-16le is supported", name)
+		}
+	}
+	textEncoding, wideEncoding = enc, enc == utf16LE
+	return nil
+}
+
+// toUTF8 converts a line of Domino output to UTF-8 from the character set
+// given with -encoding. Lines marked with a byte order mark are UTF-8
+// already.
+func toUTF8(line []byte) string {
+	if text, ok := splitBOM(line); ok {
+		return string(text)
+	}
+	enc := textEncoding
+	switch {
+	case wideEncoding:
+		// Lines were decoded as they were read
+		return string(line)
+	case enc == nil && utf8.Valid(line):
+		return string(line)
+	case enc == nil:
+		enc = charmap.Windows1252
+	}
+	text, err := enc.NewDecoder().Bytes(line)
+	if err != nil {
+		return string(line)
+	}
+	return string(text)
+}
+
+// decodeStream decodes a stream of Domino output, if it's in a character set
+// which has to be decoded before it can be split into lines.
+func decodeStream(r io.Reader) io.Reader {
+	if !wideEncoding {
+		return r
+	}
+	return &utf16Reader{r: bufio.NewReader(r)}
+}
+
+// Domino on Windows writes its console log in UTF-16LE, starting with a
+// byte order mark. Lines read from such files are decoded to UTF-8 and
+// marked with UTF-8's byte order mark, so that they're known to be UTF-8
+// already.
+var (
+	utf16BOM = []byte{0xff, 0xfe}
+	utf8BOM  = []byte{0xef, 0xbb, 0xbf}
+)
+
+// splitBOM removes the UTF-8 byte order mark from the start of a line,
+// reporting whether there was one.
+func splitBOM(line []byte) ([]byte, bool) {
+	if bytes.HasPrefix(line, utf8BOM) {
+		return line[len(utf8BOM):], true
+	}
+	return line, false
+}
+
+// fromUTF16 decodes a line of UTF-16LE text, without its line ending, into
+// UTF-8 marked with a byte order mark. Any carriage returns left in it are
+// followed by a byte order mark too, so that the lines they end are marked
+// when they're split there.
+func fromUTF16(line []byte) []byte {
+	text, _ := utf16LE.NewDecoder().Bytes(bytes.TrimPrefix(line, utf16BOM))
+	text = bytes.TrimRight(text, "\r\n")
+	text = bytes.ReplaceAll(text, []byte{'\r'}, append([]byte{'\r'}, utf8BOM...))
+	return append(append([]byte{}, utf8BOM...), text...)
+}
+
+// utf16Reader decodes a UTF-16LE file into lines of UTF-8, each marked with
+// a byte order mark.
+type utf16Reader struct {
+	r   *bufio.Reader
+	buf []byte // decoded, but not read yet
+}
+
+func (ur *utf16Reader) Read(p []byte) (int, error) {
+	for len(ur.buf) == 0 {
+		line, err := readUTF16Line(ur.r)
+		if len(line) > 0 {
+			ur.buf = append(fromUTF16(line), '\n')
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, ur.buf)
+	ur.buf = ur.buf[n:]
+	return n, nil
+}
+
+// readUTF16Line reads a line of UTF-16LE text, including the newline, if
+// there is one. A newline byte only ends the line if it's a whole character.
+// A line of more than maxLineSize characters is cut after one more than
+// that, without its newline, and the rest skipped; decoded, it's still too
+// long, so the lineScanner reading it cuts it to size and flags it.
+func readUTF16Line(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		lo, err := r.ReadByte()
+		if err != nil {
+			return line, err
+		}
+		hi, err := r.ReadByte()
+		if err != nil {
+			return line, err
+		}
+		if len(line) == 2*(maxLineSize+1) {
+			if lo != '\n' || hi != 0 {
+				skipUTF16Line(r)
+			}
+			return line, nil
+		}
+		line = append(line, lo, hi)
+		if lo == '\n' && hi == 0 {
+			return line, nil
+		}
+	}
+}
+
+// skipUTF16Line skips the rest of a line of UTF-16LE text, up to and
+// including the newline.
+func skipUTF16Line(r *bufio.Reader) {
+	for {
+		lo, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		hi, err := r.ReadByte()
+		if err != nil || lo == '\n' && hi == 0 {
+			return
+		}
+	}
+}
+
+// isUTF16 reports whether a file starts with UTF-16LE's byte order mark.
+func isUTF16(r io.ReaderAt) bool {
+	buf := make([]byte, len(utf16BOM))
+	_, err := r.ReadAt(buf, 0)
+	return err == nil && bytes.Equal(buf, utf16BOM)
+}
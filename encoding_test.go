@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// toUTF16 encodes text as UTF-16LE.
+func toUTF16(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u), byte(u>>8))
+	}
+	return b
+}
+
+func TestUTF16Reader(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		want string
+	}{
+		{"empty", nil, ""},
+		{"BOM only", utf16BOM, bom + "\n"},
+		{"one line", append(utf16BOM, toUTF16("Server started\r\n")...), bom + "Server started\n"},
+		{"no final newline", toUTF16("a\nb"), bom + "a\n" + bom + "b\n"},
+		{"newline byte in a character", toUTF16("ਊ\n"), bom + "ਊ\n"},
+		{"carriage return", toUTF16("a\rb\n"), bom + "a\r" + bom + "b\n"},
+		{"surrogate pair", toUTF16("\U0001F600\n"), bom + "\U0001F600\n"},
+		{"exact bytes", []byte{0xff, 0xfe, 'O', 0, 'K', 0, 0xe9, 0, '\n', 0}, "\xef\xbb\xbfOK\xc3\xa9\n"},
+	}
+	for _, tt := range tests {
+		got, err := io.ReadAll(&utf16Reader{r: bufio.NewReader(bytes.NewReader(tt.in))})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUTF16ReaderTooLong(t *testing.T) {
+	defer func(size int) { maxLineSize = size }(maxLineSize)
+	maxLineSize = minLineSize
+
+	// Only so much of a line without a newline is read at once
+	long := toUTF16(strings.Repeat("x", 10*minLineSize))
+	line, err := readUTF16Line(bufio.NewReader(bytes.NewReader(long)))
+	if err != nil || len(line) != 2*(minLineSize+1) {
+		t.Fatalf("read %d bytes, error %v; want %d", len(line), err, 2*(minLineSize+1))
+	}
+
+	// It's cut and flagged like any other line which is too long
+	in := append(long, toUTF16("\nnext\n"+strings.Repeat("y", minLineSize)+"\r\n")...)
+	ls := newLineScanner(&utf16Reader{r: bufio.NewReader(bytes.NewReader(in))})
+	want := []struct {
+		text      string
+		truncated bool
+	}{
+		{bom + strings.Repeat("x", minLineSize-len(bom)), true},
+		{bom + "next", false},
+		{bom + strings.Repeat("y", minLineSize-len(bom)), true},
+	}
+	for _, w := range want {
+		if !ls.Scan() {
+			t.Fatalf("no line %q: %v", w.text, ls.Err())
+		}
+		if ls.Text() != w.text || ls.truncated != w.truncated {
+			t.Errorf("got %q, truncated %v; want %q, truncated %v", ls.Text(), ls.truncated, w.text, w.truncated)
+		}
+	}
+	if ls.Scan() {
+		t.Errorf("extra line %q", ls.Text())
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Prefix for environment variables which set flags. The rest of the name is
+// the flag name in upper case with dashes turned into underscores, so for
+// example DOMINO2SYSLOG_RULES sets -rules.
+const envPrefix = "DOMINO2SYSLOG_"
+
+// envName returns the name of the environment variable for a flag.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// setFlagsFromEnv sets flags from any corresponding environment variables.
+// It must be called before the command line is parsed, so that flags given
+// there take precedence.
+func setFlagsFromEnv(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok || err != nil {
+			return
+		}
+		if serr := fs.Set(f.Name, value); serr != nil {
+			err = fmt.Errorf("%s: %s", name, serr)
+		}
+	})
+	return err
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Go template for the text of messages, if not the message with Domino's
+// timestamp, thread ID and fields appended. It's given the event as the
+// JSON Lines output writes it, so it can use fields such as {{.Task}} and
+// {{.Host}}.
+var messageTemplate *template.Template
+
+// Event is a line of Domino output once it's been parsed and classified,
+// ready to be delivered to an output.
+type Event struct {
+	Time      time.Time // when the line was read
+	Priority  Priority  // severity, without the facility
+	Facility  Priority
+	Tag       string
+	Task      string // Domino task which logged the message, if known
+	Thread    string // Domino thread ID, if given
+	Timestamp string // Domino's own timestamp, if it should be kept
+	Message   string
+	Fields    map[string]string // extracted by the rule which matched
+	Rule      string            // where the rule which matched came from
+	Host      string            // if not this host, for lines relayed from another
+	// How to add the fields to the text, sd or json, if not as -fields
+	// says; set for outputs with their own fields option
+	FieldsFormat string
+	// Template for the text, if not messageTemplate; set for outputs with
+	// their own template option
+	Template *template.Template `json:"-"`
+}
+
+// newEvent returns an event for a message of our own, rather than one from
+// Domino.
+func newEvent(pri Priority, msg string) *Event {
+	return &Event{
+		Time:     time.Now(),
+		Priority: pri,
+		Facility: facility,
+		Tag:      logTag,
+		Message:  msg,
+	}
+}
+
+// host returns the name of the host the event came from.
+func (ev *Event) host() string {
+	if ev.Host != "" {
+		return ev.Host
+	}
+	return hostname
+}
+
+// jsonFields reports whether the event's fields should be added to its text
+// as JSON, rather than structured data.
+func (ev *Event) jsonFields() bool {
+	if ev.FieldsFormat != "" {
+		return ev.FieldsFormat == "json"
+	}
+	return fieldsFormat == "json"
+}
+
+// template returns the template for the event's text, if there is one.
+func (ev *Event) template() *template.Template {
+	if ev.Template != nil {
+		return ev.Template
+	}
+	return messageTemplate
+}
+
+// applyTemplate formats the event's text with a template. If that fails,
+// the error is reported, and the text is formatted as usual instead, so
+// that the message isn't lost.
+func (ev *Event) applyTemplate(tmpl *template.Template) string {
+	jev := newJSONEvent(ev)
+	jev.Host = ev.host()
+	var b strings.Builder
+	if err := tmpl.Execute(&b, jev); err != nil {
+		fmt.Fprintf(os.Stderr, "error applying message template: %s\n", err)
+		return ev.defaultText()
+	}
+	return b.String()
+}
+
+// text returns the event as a single line of text for syslog: formatted
+// with the message template, if there is one, or else the message with
+// Domino's timestamp, thread ID and fields appended, or with -fields json,
+// a CEE JSON object.
+func (ev *Event) text() string {
+	if tmpl := ev.template(); tmpl != nil {
+		return ev.applyTemplate(tmpl)
+	}
+	return ev.defaultText()
+}
+
+// defaultText returns the event's text when there's no template.
+func (ev *Event) defaultText() string {
+	if ev.jsonFields() {
+		return formatCEE(ev.Message, ev.Timestamp, ev.Thread, ev.Fields)
+	}
+	msg := ev.Message
+	if ev.Timestamp != "" {
+		msg = fmt.Sprintf("%s (@ %s)", msg, ev.Timestamp)
+	}
+	if ev.Thread != "" {
+		msg = fmt.Sprintf("%s [%s]", msg, ev.Thread)
+	}
+	if len(ev.Fields) > 0 {
+		msg = fmt.Sprintf("%s %s", msg, formatSD(ev.Fields))
+	}
+	return msg
+}
@@ -0,0 +1,83 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// Where event sources for the Application log are registered.
+const eventSourcesKey = `SYSTEM\CurrentControlSet\Services\EventLog\Application`
+
+func init() {
+	outputTypes["eventlog"] = func(arg string) (Output, error) {
+		return openEventLog(arg)
+	}
+}
+
+// eventLog writes events to the Windows Application event log.
+type eventLog struct {
+	mu  sync.Mutex
+	log *eventlog.Log
+}
+
+// openEventLog opens the event log output, given the name of the event
+// source, which defaults to the syslog tag. The source is registered if it
+// isn't already, which takes administrator rights; if that fails, events are
+// still logged, but the Event Viewer complains it can't find their
+// descriptions.
+func openEventLog(source string) (Output, error) {
+	if source == "" {
+		source = logTag
+	}
+	if err := registerEventSource(source); err != nil {
+		fmt.Fprintf(os.Stderr, "can't register event source %s: %s\n", source, err)
+	}
+	log, err := eventlog.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	return &eventLog{log: log}, nil
+}
+
+// registerEventSource registers an event source for the Application log, if
+// it isn't already, with EventCreate.exe's messages, which are just the text
+// logged.
+func registerEventSource(source string) error {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, eventSourcesKey+`\`+source, registry.QUERY_VALUE)
+	if err == nil {
+		key.Close()
+		return nil
+	}
+	return eventlog.InstallAsEventCreate(source, eventlog.Error|eventlog.Warning|eventlog.Info)
+}
+
+// Write logs an event. The event log only has three levels, so err and
+// above are errors, warning is a warning, and the rest are information; the
+// event ID is the syslog severity plus one, from 1 for emerg to 8 for debug,
+// so that they can still be told apart.
+func (el *eventLog) Write(ev *Event) error {
+	id := uint32(ev.Priority) + 1
+	msg := ev.text()
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	switch {
+	case ev.Priority <= LOG_ERR:
+		return el.log.Error(id, msg)
+	case ev.Priority == LOG_WARNING:
+		return el.log.Warning(id, msg)
+	default:
+		return el.log.Info(id, msg)
+	}
+}
+
+func (el *eventLog) Close() error {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	return el.log.Close()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+func init() {
+	outputTypes["exec"] = func(arg string) (Output, error) {
+		return openExec(arg)
+	}
+}
+
+// execOutput delivers events to another program, which is sent them on its
+// standard input as JSON objects like those of the JSON Lines output, one
+// per line. That way sinks for niche destinations can be written in any
+// language. If the program exits, it's started again.
+type execOutput struct {
+	cmdline []string
+	out     *batchOutput
+
+	// Only touched by send, and by Close once sending's done
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// openExec starts a program to deliver events to, given its command line,
+// such as /usr/local/bin/ship-logs --to=archive. The arguments are split
+// on spaces, without any quoting.
+func openExec(arg string) (Output, error) {
+	cmdline := strings.Fields(arg)
+	if len(cmdline) == 0 {
+		return nil, fmt.Errorf("exec output needs a command, such as exec:/usr/local/bin/ship-logs")
+	}
+	eo := &execOutput{cmdline: cmdline}
+	// Start it straight away, so that problems show up at startup
+	if err := eo.start(); err != nil {
+		return nil, err
+	}
+	eo.out = newBatchOutput(cmdline[0], eo.send)
+	return eo, nil
+}
+
+// start starts the program. Its own output goes to our standard error.
+func (eo *execOutput) start() error {
+	cmd := exec.Command(eo.cmdline[0], eo.cmdline[1:]...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	eo.cmd, eo.stdin = cmd, stdin
+	return nil
+}
+
+// stop closes the program's standard input, and waits for it to exit,
+// killing it if it takes longer than drainTimeout.
+func (eo *execOutput) stop() error {
+	if eo.cmd == nil {
+		return nil
+	}
+	eo.stdin.Close()
+	exited := make(chan error, 1)
+	go func() { exited <- eo.cmd.Wait() }()
+	var err error
+	select {
+	case err = <-exited:
+	case <-time.After(drainTimeout):
+		eo.cmd.Process.Kill()
+		err = <-exited
+	}
+	eo.cmd, eo.stdin = nil, nil
+	return err
+}
+
+// send writes a batch of events to the program, starting it again if it
+// isn't running.
+func (eo *execOutput) send(evs []*Event) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	for _, ev := range evs {
+		if err := enc.Encode(newJSONEvent(ev)); err != nil {
+			return permanentError{err}
+		}
+	}
+	if eo.cmd == nil {
+		if err := eo.start(); err != nil {
+			return err
+		}
+	}
+	if _, err := eo.stdin.Write(buf.Bytes()); err != nil {
+		// It's most likely exited, so find out why
+		if werr := eo.stop(); werr != nil {
+			err = fmt.Errorf("%s exited: %s", eo.cmdline[0], werr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (eo *execOutput) Write(ev *Event) error {
+	return eo.out.Write(ev)
+}
+
+func (eo *execOutput) spoolOverflow() {
+	eo.out.spoolOverflow()
+}
+
+func (eo *execOutput) Close() error {
+	err := eo.out.Close()
+	if werr := eo.stop(); err == nil && werr != nil {
+		err = fmt.Errorf("%s exited: %s", eo.cmdline[0], werr)
+	}
+	return err
+}
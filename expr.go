@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// exprEnv is what a rule's expression can see: the message, the task which
+// logged it, any fields extracted by the rule's pattern, and the time.
+type exprEnv struct {
+	Msg     string            `expr:"msg"`
+	Task    string            `expr:"task"`
+	Fields  map[string]string `expr:"fields"`
+	Hour    int               `expr:"hour"`
+	Minute  int               `expr:"minute"`
+	Weekday int               `expr:"weekday"` // 0 is Sunday
+}
+
+// compileExpr compiles a rule's expression. It must produce a string: a
+// priority name, "drop" to drop the line, or "" if the rule doesn't apply
+// after all.
+func compileExpr(src string) (*vm.Program, error) {
+	if src == "" {
+		return nil, fmt.Errorf("expr rule has no expression")
+	}
+	program, err := expr.Compile(src, expr.Env(exprEnv{}), expr.AsKind(reflect.String))
+	if err != nil {
+		return nil, fmt.Errorf("bad expression %q: %s", src, err)
+	}
+	return program, nil
+}
+
+// evaluate runs an expression rule's program against a message it matched,
+// returning a copy of the rule with the action and priority decided on. If
+// the expression says the rule doesn't apply, or fails, ok is false.
+func (rule *Rule) evaluate(msg, task string) (result *Rule, ok bool) {
+	now := time.Now()
+	env := exprEnv{
+		Msg:     msg,
+		Task:    task,
+		Fields:  rule.fields(msg),
+		Hour:    now.Hour(),
+		Minute:  now.Minute(),
+		Weekday: int(now.Weekday()),
+	}
+	out, err := expr.Run(rule.program, env)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error evaluating rule %s: %s\n", rule.src, err)
+		return nil, false
+	}
+	decision, _ := out.(string)
+	r := *rule
+	switch decision {
+	case "":
+		return nil, false
+	case "drop":
+		r.action = actionDrop
+	default:
+		lvl, err := parsePriority(decision)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error evaluating rule %s: %s\n", rule.src, err)
+			return nil, false
+		}
+		r.action = actionLog
+		r.lvl = lvl
+	}
+	return &r, true
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Fields to work out from the text of every message, given with -extract as
+// a comma-separated list, such as db,user. None are by default, since with
+// -fields sd they'd be added to the text of plain syslog messages too.
+var extractFlag string
+
+// Ways of working out fields from the text of messages, by the name given
+// with -extract.
+var extractors = map[string]func(ev *Event){
+	"db":     parseDatabase,
+	"user":   parseUser,
+	"src_ip": parseAddress,
+	"mail":   parseMail,
+}
+
+// The extractors given with -extract, in the order given.
+var extracting []func(ev *Event)
+
+// checkExtract checks the fields given with -extract, and sets up the
+// extractors for them.
+func checkExtract() error {
+	extracting = nil
+	if extractFlag == "" {
+		return nil
+	}
+	for _, name := range strings.Split(extractFlag, ",") {
+		extract, ok := extractors[strings.TrimSpace(name)]
+		if !ok {
+			return fmt.Errorf("unknown field %q to extract; should be %s", name, strings.Join(extractorNames(), ", "))
+		}
+		extracting = append(extracting, extract)
+	}
+	return nil
+}
+
+// extractorNames returns the names of the fields -extract can give, sorted.
+func extractorNames() []string {
+	names := make([]string, 0, len(extractors))
+	for name := range extractors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Paths of Domino databases and templates in messages, such as
+// "mail/jbloggs.nsf", "C:\Domino\Data\names.nsf", or with the server they're
+// on, "Mail1/Acme!!apps/crm.ntf".
+var dbPathRegex = regexp.MustCompile(`(?i)((?:[a-z]:)?[\w.~$@!\\/-]*\.n[st]f)\b`)
+
+// Notes names in messages: canonical names, such as
+// "CN=Joe Bloggs/OU=Sales/O=Acme", anywhere, and abbreviated names, such as
+// "Joe Bloggs/Sales/Acme", after "by", "for" or "user", as in
+// "Authentication failure using internet password for Joe Bloggs/Acme", or
+// before being refused access, as in "Joe Bloggs/Acme is not authorized to
+// access apps/crm.nsf".
+var (
+	canonicalNameRegex   = regexp.MustCompile(`\b(?i:CN)=[^/=\n,;:()<>"\[\]]+(?:/(?i:OU)=[^/=\n,;:()<>"\[\]]+){0,4}/(?i:O)=[^/=\s,;:()<>"\[\]]+(?:/(?i:C)=[A-Za-z]{2}\b)?`)
+	abbreviatedNameRegex = regexp.MustCompile(`(?i:\b(?:by|for|user)\s+)(` + abbreviatedName + `)|(?:^|: )(` + abbreviatedName + `) (?:is not authorized|is not allowed|was denied|has been denied)`)
+)
+
+// Things in messages which might be IP addresses, such as "10.1.2.3:1352" or
+// "[2001:db8::1]", which are checked properly before they're taken to be.
+var (
+	ipv4Regex = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	ipv6Regex = regexp.MustCompile(`[0-9A-Fa-f]*:[0-9A-Fa-f:]*:[0-9A-Fa-f:.]*`)
+)
+
+// An abbreviated Notes name: a common name of up to four words, followed by
+// the organization and any organizational units.
+const abbreviatedName = `[A-Z][\w'.-]*(?: [A-Z][\w'.-]*){0,3}(?:/[\w&.-]+){1,5}`
+
+// parseDatabase adds the path of the first database mentioned in a message,
+// if any, to the event's fields as db, unless the rule which matched it
+// extracted one. The path is given with forward slashes, and without the
+// server it's on.
+func parseDatabase(ev *Event) {
+	m := dbPathRegex.FindStringSubmatch(ev.Message)
+	if m == nil {
+		return
+	}
+	path := m[1]
+	if i := strings.LastIndex(path, "!!"); i >= 0 {
+		path = path[i+2:]
+	}
+	addFields(ev, map[string]string{"db": strings.ReplaceAll(path, `\`, "/")})
+}
+
+// parseUser adds the first Notes name mentioned in a message, if any, to the
+// event's fields as user, unless the rule which matched it extracted one.
+// Canonical names are preferred, since abbreviated ones are harder to tell
+// from other things with slashes in them.
+func parseUser(ev *Event) {
+	name := canonicalNameRegex.FindString(ev.Message)
+	if name == "" {
+		if m := abbreviatedNameRegex.FindStringSubmatch(ev.Message); m != nil {
+			name = strings.TrimRight(m[1]+m[2], ".")
+			if dbPathRegex.MatchString(name) {
+				name = ""
+			}
+		}
+	}
+	if name != "" {
+		addFields(ev, map[string]string{"user": name})
+	}
+}
+
+// parseAddress adds the first IP address mentioned in a message, if any, to
+// the event's fields as src_ip, unless the rule which matched it extracted
+// an address, as ip, addr, address or src_ip.
+func parseAddress(ev *Event) {
+	for _, name := range []string{"ip", "addr", "address"} {
+		if _, ok := ev.Fields[name]; ok {
+			return
+		}
+	}
+	var addr string
+	at := len(ev.Message)
+	for _, re := range []*regexp.Regexp{ipv4Regex, ipv6Regex} {
+		for _, loc := range re.FindAllStringIndex(ev.Message, -1) {
+			if loc[0] >= at {
+				break
+			}
+			s := strings.TrimRight(ev.Message[loc[0]:loc[1]], ":.")
+			if net.ParseIP(s) != nil {
+				addr, at = s, loc[0]
+				break
+			}
+		}
+	}
+	if addr != "" {
+		addFields(ev, map[string]string{"src_ip": addr})
+	}
+}
+
+// addFields adds fields found in a message to the event's fields, leaving
+// alone any fields the rule which matched it extracted.
+func addFields(ev *Event, found map[string]string) {
+	if len(found) == 0 {
+		return
+	}
+	if ev.Fields == nil {
+		ev.Fields = make(map[string]string, len(found))
+	}
+	for name, value := range found {
+		if _, ok := ev.Fields[name]; !ok {
+			ev.Fields[name] = value
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// How structured fields are added to syslog messages: "sd" appends them as
+// an RFC 5424 structured data element, "json" turns the whole message into a
+// CEE-style JSON object which rsyslog's mmjsonparse understands.
+var fieldsFormat = "sd"
+
+// SD-ID for our structured data element. 32473 is the enterprise number
+// reserved for documentation by RFC 5612, since Domino logs don't have one
+// of their own.
+const sdID = "domino@32473"
+
+// fields extracts the named capture groups of a rule's pattern from the
+// message it matched. It returns nil if the pattern has no named groups, or
+// none of them matched anything.
+func (rule *Rule) fields(msg string) map[string]string {
+	names := rule.re.SubexpNames()
+	m := rule.re.FindStringSubmatch(msg)
+	if m == nil {
+		return nil
+	}
+	var fields map[string]string
+	for i, name := range names {
+		if name == "" || m[i] == "" {
+			continue
+		}
+		if fields == nil {
+			fields = make(map[string]string)
+		}
+		fields[name] = m[i]
+	}
+	return fields
+}
+
+// sdEscaper escapes the characters RFC 5424 doesn't allow in a PARAM-VALUE.
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// formatSD formats fields as an RFC 5424 structured data element.
+func formatSD(fields map[string]string) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString("[" + sdID)
+	for _, k := range keys {
+		fmt.Fprintf(&sb, " %s=\"%s\"", k, sdEscaper.Replace(fields[k]))
+	}
+	sb.WriteString("]")
+	return sb.String()
+}
+
+// formatCEE formats a message and its fields as a JSON object with the
+// "@cee:" cookie, so that syslog daemons can parse out the fields.
+func formatCEE(msg, timestamp, threadid string, fields map[string]string) string {
+	obj := make(map[string]string, len(fields)+3)
+	for k, v := range fields {
+		obj[k] = v
+	}
+	obj["msg"] = msg
+	if timestamp != "" {
+		obj["timestamp"] = timestamp
+	}
+	if threadid != "" {
+		obj["thread"] = threadid
+	}
+	js, err := json.Marshal(obj)
+	if err != nil {
+		// Can't happen with a map of strings
+		return msg
+	}
+	return "@cee: " + string(js)
+}
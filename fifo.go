@@ -0,0 +1,64 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func init() {
+	inputTypes["fifo"] = func(arg string) (input, error) {
+		if arg == "" {
+			return nil, fmt.Errorf("fifo input needs a path")
+		}
+		return func(src *source, out Output, stop <-chan struct{}) error {
+			err := readFIFO(arg, src, out, stop)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			return err
+		}, nil
+	}
+}
+
+// readFIFO logs lines written to a named pipe, creating it if need be, so
+// that Domino's output can be redirected to it by its usual start script,
+// until stop is closed. The pipe is opened for writing as well as reading,
+// so that it stays open when whatever's writing to it closes it, and lines
+// carry on being read when the next writer opens it.
+func readFIFO(path string, src *source, out Output, stop <-chan struct{}) error {
+	if err := syscall.Mkfifo(path, 0600); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("can't create named pipe %s: %s", path, err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeNamedPipe == 0 {
+		return fmt.Errorf("%s isn't a named pipe", path)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	stopped := make(chan struct{})
+	go func() {
+		<-stop
+		close(stopped)
+		// Makes the read below return
+		f.Close()
+	}()
+	scanner := newLineScanner(decodeStream(f))
+	for scanner.Scan() {
+		process(scanner.Bytes(), src, scanner.output(out))
+	}
+	select {
+	case <-stopped:
+		return nil
+	default:
+	}
+	f.Close()
+	return fmt.Errorf("error reading %s: %s", path, scanner.Err())
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"time"
+)
+
+func init() {
+	outputTypes["fluentd"] = func(arg string) (Output, error) {
+		return openFluentd(arg)
+	}
+}
+
+// openFluentd opens an output to Fluentd or Fluent Bit using the forward
+// protocol, given a URL such as tcp://fluentd:24224 or tls://fluentd:24224.
+// Query parameters give the Fluentd tag, which defaults to the syslog tag;
+// whether to wait for Fluentd to acknowledge each batch, with ack=true; and
+// for TLS, the ca, cert and key files, as for TLS syslog.
+func openFluentd(addr string) (Output, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "tcp" && u.Scheme != "tls") || u.Host == "" {
+		return nil, fmt.Errorf("Fluentd address %q should be a URL, such as tcp://fluentd:24224", addr)
+	}
+	hostport := u.Host
+	if u.Port() == "" {
+		hostport = net.JoinHostPort(u.Hostname(), "24224")
+	}
+	params := queryParams(u.RawQuery)
+	ft := &forwardTransport{tag: params.Get("tag"), ack: params.Get("ack") == "true"}
+	if ft.tag == "" {
+		ft.tag = logTag
+	}
+	var conf *tls.Config
+	if u.Scheme == "tls" {
+		conf, err = tlsSettings{ca: params.Get("ca"), cert: params.Get("cert"), key: params.Get("key")}.config(hostport)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &senderOutput{newNetStream("Fluentd at "+hostport, hostport, conf, formatForward, ft)}, nil
+}
+
+// formatForward formats an event as a Fluentd forward protocol entry: an
+// array of the time and a record of the event's details, with any fields
+// extracted by the rules.
+func formatForward(ev *Event) string {
+	record := make(map[string]string, len(ev.Fields)+10)
+	for k, v := range ev.Fields {
+		record[k] = v
+	}
+	record["message"] = ev.Message
+	record["severity"] = priorityName(ev.Priority)
+	record["facility"] = facilityName(ev.Facility)
+	record["tag"] = ev.Tag
+	record["host"] = ev.host()
+	for k, v := range map[string]string{"task": ev.Task, "thread": ev.Thread, "domino_timestamp": ev.Timestamp, "rule": ev.Rule} {
+		if v != "" {
+			record[k] = v
+		}
+	}
+	keys := make([]string, 0, len(record))
+	for k := range record {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b := appendMsgpackArray(nil, 2)
+	b = appendEventTime(b, ev.Time.Unix(), int64(ev.Time.Nanosecond()))
+	b = appendMsgpackMap(b, len(keys))
+	for _, k := range keys {
+		b = appendMsgpackString(b, k)
+		b = appendMsgpackString(b, record[k])
+	}
+	return string(b)
+}
+
+// forwardTransport sends batches of entries in the forward protocol's
+// Forward mode, optionally waiting for Fluentd to acknowledge each one.
+type forwardTransport struct {
+	tag string
+	ack bool
+	r   *bufio.Reader
+}
+
+func (ft *forwardTransport) open(conn net.Conn) error {
+	ft.r = bufio.NewReader(conn)
+	return nil
+}
+
+func (ft *forwardTransport) write(conn net.Conn, msgs [][]byte) error {
+	b := appendMsgpackArray(nil, 3)
+	b = appendMsgpackString(b, ft.tag)
+	b = appendMsgpackArray(b, len(msgs))
+	for _, msg := range msgs {
+		b = append(b, msg...)
+	}
+	var chunk string
+	if ft.ack {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return err
+		}
+		chunk = base64.StdEncoding.EncodeToString(id)
+		b = appendMsgpackMap(b, 2)
+		b = appendMsgpackString(b, "chunk")
+		b = appendMsgpackString(b, chunk)
+	} else {
+		b = appendMsgpackMap(b, 1)
+	}
+	b = appendMsgpackString(b, "size")
+	b = appendMsgpackInt(b, len(msgs))
+	if _, err := conn.Write(b); err != nil {
+		return err
+	}
+	if !ft.ack {
+		return nil
+	}
+	conn.SetReadDeadline(time.Now().Add(writeTimeout))
+	resp, err := readMsgpackStringMap(ft.r)
+	if err != nil {
+		return err
+	}
+	if resp["ack"] != chunk {
+		return fmt.Errorf("Fluentd acknowledged chunk %q, expected %q", resp["ack"], chunk)
+	}
+	return nil
+}
+
+func (ft *forwardTransport) close(conn net.Conn) {}
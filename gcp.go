@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Where GCE instances find their metadata, including access tokens for
+// their service account.
+const gceMetadataURL = "http://metadata.google.internal/computeMetadata/v1"
+
+// Cloud Logging API endpoint for writing log entries.
+const cloudLoggingURL = "https://logging.googleapis.com/v2/entries:write"
+
+// Cloud Logging severities for each syslog priority.
+var gcpSeverities = map[string]string{
+	"emerg":   "EMERGENCY",
+	"alert":   "ALERT",
+	"crit":    "CRITICAL",
+	"err":     "ERROR",
+	"warning": "WARNING",
+	"notice":  "NOTICE",
+	"info":    "INFO",
+	"debug":   "DEBUG",
+}
+
+func init() {
+	outputTypes["gcp"] = func(arg string) (Output, error) {
+		return openCloudLogging(arg)
+	}
+}
+
+// cloudLogging sends events to Google Cloud Logging, labelled with the GCE
+// instance they came from.
+type cloudLogging struct {
+	url      string // of the entries:write method
+	logName  string
+	resource map[string]interface{}
+
+	mu          sync.Mutex
+	accessToken string
+	expires     time.Time
+}
+
+// openCloudLogging opens a Cloud Logging output, given the log's ID, such as
+// domino-console. The project, instance and zone are found from the GCE
+// metadata server, unless the project is given with ?project=, in which
+// case the logs are sent with a generic resource for the host. A private
+// endpoint for the API can be given with endpoint=.
+func openCloudLogging(arg string) (Output, error) {
+	logID, query, _ := strings.Cut(arg, "?")
+	if logID == "" {
+		logID = "domino-console"
+	}
+	params := queryParams(query)
+	project := params.Get("project")
+	var resource map[string]interface{}
+	if project == "" {
+		var err error
+		if project, err = gceMetadata("/project/project-id"); err != nil {
+			return nil, fmt.Errorf("no project given, and can't get it from GCE: %s", err)
+		}
+		instanceID, err := gceMetadata("/instance/id")
+		if err != nil {
+			return nil, err
+		}
+		zone, err := gceMetadata("/instance/zone")
+		if err != nil {
+			return nil, err
+		}
+		resource = map[string]interface{}{
+			"type": "gce_instance",
+			"labels": map[string]string{
+				"project_id":  project,
+				"instance_id": instanceID,
+				// The zone comes as projects/NUMBER/zones/ZONE
+				"zone": zone[strings.LastIndex(zone, "/")+1:],
+			},
+		}
+	} else {
+		resource = map[string]interface{}{
+			"type": "generic_node",
+			"labels": map[string]string{
+				"project_id": project,
+				"location":   params.Get("location"),
+				"namespace":  "domino",
+				"node_id":    hostname,
+			},
+		}
+	}
+	cl := &cloudLogging{
+		url:      cloudLoggingURL,
+		logName:  "projects/" + project + "/logs/" + logID,
+		resource: resource,
+	}
+	if endpoint := params.Get("endpoint"); endpoint != "" {
+		cl.url = strings.TrimSuffix(endpoint, "/") + "/v2/entries:write"
+	}
+	return newBatchOutput("Cloud Logging log "+logID, cl.send), nil
+}
+
+// gceMetadata fetches an item of GCE instance metadata.
+func gceMetadata(path string) (string, error) {
+	req, err := http.NewRequest("GET", gceMetadataURL+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GCE metadata %s: %s", path, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+// token returns an access token for the API, from the environment variable
+// GOOGLE_OAUTH_ACCESS_TOKEN if it's set, or else the instance's service
+// account, renewing it before it expires.
+func (cl *cloudLogging) token() (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.accessToken != "" && time.Until(cl.expires) > time.Minute {
+		return cl.accessToken, nil
+	}
+	js, err := gceMetadata("/instance/service-accounts/default/token")
+	if err != nil {
+		return "", err
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal([]byte(js), &tok); err != nil {
+		return "", fmt.Errorf("can't understand GCE access token: %s", err)
+	}
+	cl.accessToken = tok.AccessToken
+	cl.expires = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+	return cl.accessToken, nil
+}
+
+// send writes a batch of events as log entries. Each entry's payload is a
+// JSON object like those of the JSON Lines output, so that its fields can be
+// queried, and it's labelled with the tag and Domino task.
+func (cl *cloudLogging) send(evs []*Event) error {
+	entries := make([]map[string]interface{}, len(evs))
+	for i, ev := range evs {
+		labels := map[string]string{"tag": ev.Tag}
+		if ev.Task != "" {
+			labels["task"] = ev.Task
+		}
+		entries[i] = map[string]interface{}{
+			"timestamp":   ev.Time.Format(time.RFC3339Nano),
+			"severity":    gcpSeverities[priorityName(ev.Priority)],
+			"jsonPayload": newJSONEvent(ev),
+			"labels":      labels,
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"logName":  cl.logName,
+		"resource": cl.resource,
+		"entries":  entries,
+	})
+	if err != nil {
+		return permanentError{err}
+	}
+	token, err := cl.token()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", cl.url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	_, err = doHTTP(req)
+	return err
+}
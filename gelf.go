@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"sync"
+)
+
+// Largest GELF UDP datagram we send; longer messages are split into
+// chunks no bigger than this, header included.
+const gelfChunkSize = 8192
+
+// Most chunks a GELF message can be split into.
+const gelfMaxChunks = 128
+
+// Magic bytes which start each chunk of a GELF message.
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+// Characters allowed in the names of GELF additional fields.
+var gelfFieldNameRegex = regexp.MustCompile(`[^\w.-]`)
+
+func init() {
+	outputTypes["gelf"] = func(arg string) (Output, error) {
+		return openGELF(arg)
+	}
+}
+
+// openGELF opens a GELF output to Graylog, given its address as a URL such
+// as udp://graylog:12201 or tcp://graylog:12201. Over UDP, messages are
+// compressed with gzip unless the URL says otherwise with ?compress=zlib or
+// ?compress=none. Over TCP, GELF doesn't allow compression, and messages
+// are sent with reconnection and buffering as for syslog.
+func openGELF(addr string) (Output, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("GELF address %q should be a URL, such as udp://graylog:12201", addr)
+	}
+	hostport := u.Host
+	if u.Port() == "" {
+		hostport = net.JoinHostPort(u.Hostname(), "12201")
+	}
+	switch u.Scheme {
+	case "udp":
+		compress := queryParams(u.RawQuery).Get("compress")
+		if compress == "" {
+			compress = "gzip"
+		}
+		if compress != "gzip" && compress != "zlib" && compress != "none" {
+			return nil, fmt.Errorf("unknown GELF compression %q", compress)
+		}
+		conn, err := net.Dial("udp", hostport)
+		if err != nil {
+			return nil, err
+		}
+		return &gelfUDP{conn: conn, compress: compress}, nil
+	case "tcp":
+		// GELF over TCP ends each message with a null byte
+		ns := newNetStream("GELF at "+hostport, hostport, nil, formatGELF, plainTransport{terminator: 0})
+		return &senderOutput{ns}, nil
+	}
+	return nil, fmt.Errorf("GELF address %q should start udp:// or tcp://", addr)
+}
+
+// formatGELF formats an event as a GELF 1.1 message. Everything about the
+// event which GELF doesn't have a field for is sent as additional fields,
+// along with the fields extracted by the rules.
+func formatGELF(ev *Event) string {
+	msg := map[string]interface{}{
+		"version":       "1.1",
+		"host":          ev.host(),
+		"short_message": ev.Message,
+		"timestamp":     float64(ev.Time.UnixNano()/1e6) / 1e3,
+		"level":         int(ev.Priority),
+		"_facility":     facilityName(ev.Facility),
+		"_tag":          ev.Tag,
+	}
+	for k, v := range ev.Fields {
+		msg[gelfFieldName(k)] = v
+	}
+	add := func(name, value string) {
+		if value != "" {
+			msg[name] = value
+		}
+	}
+	add("_task", ev.Task)
+	add("_thread", ev.Thread)
+	add("_domino_timestamp", ev.Timestamp)
+	add("_rule", ev.Rule)
+	js, err := json.Marshal(msg)
+	if err != nil {
+		// Can't happen with strings and numbers
+		return ""
+	}
+	return string(js)
+}
+
+// gelfFieldName turns the name of a field extracted by a rule into the name
+// of a GELF additional field. "_id" is reserved, so it's renamed.
+func gelfFieldName(name string) string {
+	name = "_" + gelfFieldNameRegex.ReplaceAllString(name, "_")
+	if name == "_id" {
+		return "_id_"
+	}
+	return name
+}
+
+// gelfUDP sends GELF messages over UDP.
+type gelfUDP struct {
+	compress string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (g *gelfUDP) Write(ev *Event) error {
+	msg, err := g.compressed([]byte(formatGELF(ev)))
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(msg) <= gelfChunkSize {
+		_, err := g.conn.Write(msg)
+		return err
+	}
+	return g.writeChunks(msg)
+}
+
+// compressed returns a message compressed as requested.
+func (g *gelfUDP) compressed(msg []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch g.compress {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zlib":
+		w = zlib.NewWriter(&buf)
+	default:
+		return msg, nil
+	}
+	if _, err := w.Write(msg); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeChunks sends a message too big for one datagram as a series of
+// chunks, each with a header giving the message ID, and the chunk's
+// position and the number of chunks, so Graylog can put them together.
+func (g *gelfUDP) writeChunks(msg []byte) error {
+	const headerSize = 12
+	size := gelfChunkSize - headerSize
+	count := (len(msg) + size - 1) / size
+	if count > gelfMaxChunks {
+		return fmt.Errorf("GELF message of %d bytes is too big to send", len(msg))
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	chunk := make([]byte, 0, gelfChunkSize)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(msg) {
+			end = len(msg)
+		}
+		chunk = append(chunk[:0], gelfChunkMagic...)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, msg[i*size:end]...)
+		if _, err := g.conn.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *gelfUDP) Close() error {
+	return g.conn.Close()
+}
@@ -0,0 +1,26 @@
+module github.com/lpar/domino2syslog
+
+go 1.25.0
+
+require (
+	github.com/expr-lang/expr v1.17.8
+	github.com/lib/pq v1.12.3
+	github.com/segmentio/kafka-go v0.4.47
+	golang.org/x/sys v0.47.0
+	golang.org/x/text v0.13.0
+	gopkg.in/yaml.v3 v3.0.1
+	modernc.org/sqlite v1.59.0
+)
+
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/klauspost/compress v1.15.9 // indirect
+	github.com/mattn/go-isatty v0.0.24 // indirect
+	github.com/ncruces/go-strftime v1.0.0 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	modernc.org/libc v1.75.7 // indirect
+	modernc.org/mathutil v1.7.1 // indirect
+	modernc.org/memory v1.12.1 // indirect
+)
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Inputs given with -input, each a type, optionally followed by a colon and
+// where to read from, then by options after semicolons, such as
+// tail:/local/notesdata/IBM_TECHNICAL_SUPPORT/console.log;tag=domino-prod1.
+var inputSpecs stringList
+
+// source is where lines of Domino output come from, when it has settings of
+// its own. A nil source uses the global settings.
+type source struct {
+	tag       string // syslog tag, if not -tag
+	rulesFile string // rules tried before the configured ones, if any
+	rules     []Rule // loaded from rulesFile; protected by rulesLock
+	// Whether the lines are old, so that events should have the time of
+	// Domino's timestamps rather than the time they're read
+	old bool
+	// The source this is one connection to, if any, which everything else
+	// is taken from
+	parent *source
+}
+
+// Sources of the inputs being read, so that their rules can be reloaded and
+// reported on.
+var sources []*source
+
+// connection returns a source for one of several connections to the
+// source's input, such as forwarders connecting to listen, which is the same
+// except that lines from each are collected separately with -multiline and
+// -crash-summary.
+func (src *source) connection() *source {
+	return &source{parent: src.input()}
+}
+
+// input returns the source of the input itself, rather than of one of its
+// connections.
+func (src *source) input() *source {
+	if src != nil && src.parent != nil {
+		return src.parent
+	}
+	return src
+}
+
+// logTag returns the syslog tag for events from the source.
+func (src *source) logTag() string {
+	src = src.input()
+	if src == nil || src.tag == "" {
+		return logTag
+	}
+	return src.tag
+}
+
+// backfill reports whether events from the source should have the time of
+// Domino's timestamps.
+func (src *source) backfill() bool {
+	src = src.input()
+	return src != nil && src.old
+}
+
+// sourceRules returns the source's own rules. The caller must hold
+// rulesLock.
+func (src *source) sourceRules() []Rule {
+	src = src.input()
+	if src == nil {
+		return nil
+	}
+	return src.rules
+}
+
+// input reads lines of Domino output from somewhere and logs them, until
+// there are no more or stop is closed. It reports any error itself.
+type input func(src *source, out Output, stop <-chan struct{}) error
+
+// Input types, by name. Each is given where to read from.
+var inputTypes = map[string]func(arg string) (input, error){
+	"tail": func(arg string) (input, error) {
+		path := arg
+		if path == "" {
+			path = defaultConsoleLog
+		}
+		return func(src *source, out Output, stop <-chan struct{}) error {
+			err := tailFile(path, src, out, stop)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			return err
+		}, nil
+	},
+	"run": func(arg string) (input, error) {
+		// As for the exec output, arguments are split on spaces
+		cmdline := strings.Fields(arg)
+		if len(cmdline) == 0 {
+			return nil, fmt.Errorf("run input needs a command line")
+		}
+		return func(src *source, out Output, stop <-chan struct{}) error {
+			return runCommand(cmdline, src, out, stop)
+		}, nil
+	},
+}
+
+// inputTypeNames returns the names of the input types, sorted.
+func inputTypeNames() []string {
+	names := make([]string, 0, len(inputTypes))
+	for name := range inputTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseInputSpec parses an input given with -input into the input and its
+// source.
+func parseInputSpec(spec string) (input, *source, error) {
+	parts := strings.Split(spec, ";")
+	kind, arg, _ := strings.Cut(parts[0], ":")
+	newInput, ok := inputTypes[kind]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown input %q; should be one of %s", kind, strings.Join(inputTypeNames(), ", "))
+	}
+	in, err := newInput(arg)
+	if err != nil {
+		return nil, nil, err
+	}
+	src := &source{}
+	for _, opt := range parts[1:] {
+		key, value, _ := strings.Cut(opt, "=")
+		switch key {
+		case "tag":
+			src.tag = value
+		case "rules":
+			src.rulesFile = value
+		default:
+			return nil, nil, fmt.Errorf("unknown option %q for input %s; should be tag or rules", opt, kind)
+		}
+	}
+	return in, src, nil
+}
+
+// checkInputs checks the inputs given with -input can be parsed.
+func checkInputs() error {
+	for _, spec := range inputSpecs {
+		if _, _, err := parseInputSpec(spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSourceRules loads the rules of each of the sources, in order.
+func loadSourceRules() ([][]Rule, error) {
+	srcrules := make([][]Rule, len(sources))
+	for i, src := range sources {
+		if src.rulesFile == "" {
+			continue
+		}
+		rs, err := loadRules(src.rulesFile)
+		if err != nil {
+			return nil, err
+		}
+		srcrules[i] = rs.rules
+	}
+	return srcrules, nil
+}
+
+// setSourceRules replaces the rules of each of the sources.
+func setSourceRules(srcrules [][]Rule) {
+	rulesLock.Lock()
+	for i, src := range sources {
+		src.rules = srcrules[i]
+	}
+	rulesLock.Unlock()
+}
+
+// stopOnSignal returns a channel which is closed when we're interrupted or
+// terminated.
+func stopOnSignal() <-chan struct{} {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	stop := make(chan struct{})
+	go func() {
+		<-sigs
+		close(stop)
+	}()
+	return stop
+}
+
+// runInputs reads every input given with -input at once, until they've all
+// finished, or we're interrupted or terminated, which stops files being
+// followed and terminates commands. It returns the exit status for the
+// program.
+func runInputs(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "inputs: inputs are given with -input, not as arguments")
+		return 2
+	}
+	if len(inputSpecs) == 0 {
+		fmt.Fprintln(os.Stderr, "inputs: no inputs given with -input")
+		return 2
+	}
+	inputs := make([]input, len(inputSpecs))
+	sources = make([]*source, len(inputSpecs))
+	for i, spec := range inputSpecs {
+		var err error
+		if inputs[i], sources[i], err = parseInputSpec(spec); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	srcrules, err := loadSourceRules()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading rules: %s\n", err)
+		return 2
+	}
+	setSourceRules(srcrules)
+
+	stop := stopOnSignal()
+	return logInput(func(out Output) error {
+		var wg sync.WaitGroup
+		errs := make([]error, len(inputs))
+		for i, in := range inputs {
+			wg.Add(1)
+			go func(i int, in input) {
+				defer wg.Done()
+				errs[i] = in(sources[i], out, stop)
+			}(i, in)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
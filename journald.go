@@ -0,0 +1,128 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Where journald listens for messages using its native protocol.
+const journalSocket = "/run/systemd/journal/socket"
+
+func init() {
+	outputTypes["journald"] = func(arg string) (Output, error) {
+		path := journalSocket
+		if arg != "" {
+			path = arg
+		}
+		return openJournald(path)
+	}
+}
+
+// journald delivers events straight to the systemd journal, with Domino's
+// thread ID, task and timestamp, and the fields extracted by rules, as
+// journal fields, so that they can be searched on with journalctl.
+type journald struct {
+	conn *net.UnixConn
+	addr *net.UnixAddr
+}
+
+// openJournald opens the journal's socket.
+func openJournald(path string) (*journald, error) {
+	addr := &net.UnixAddr{Name: path, Net: "unixgram"}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("can't find journald: %s", err)
+	}
+	return &journald{conn: conn, addr: addr}, nil
+}
+
+func (j *journald) Write(ev *Event) error {
+	var buf bytes.Buffer
+	addJournalField(&buf, "MESSAGE", ev.Message)
+	addJournalField(&buf, "PRIORITY", strconv.Itoa(int(ev.Priority)))
+	addJournalField(&buf, "SYSLOG_FACILITY", strconv.Itoa(int(ev.Facility>>3)))
+	addJournalField(&buf, "SYSLOG_IDENTIFIER", ev.Tag)
+	addJournalField(&buf, "DOMINO_TASK", ev.Task)
+	addJournalField(&buf, "DOMINO_THREAD", ev.Thread)
+	addJournalField(&buf, "DOMINO_TIMESTAMP", ev.Timestamp)
+	addJournalField(&buf, "DOMINO_RULE", ev.Rule)
+	for k, v := range ev.Fields {
+		addJournalField(&buf, "DOMINO_"+journalFieldName(k), v)
+	}
+	_, _, err := j.conn.WriteMsgUnix(buf.Bytes(), nil, j.addr)
+	if errors.Is(err, syscall.EMSGSIZE) || errors.Is(err, syscall.ENOBUFS) {
+		return j.writeLarge(buf.Bytes())
+	}
+	return err
+}
+
+// writeLarge sends a message too big for a datagram, by writing it to an
+// unlinked temporary file and passing journald the file descriptor, as
+// sd_journal_send does.
+func (j *journald) writeLarge(msg []byte) error {
+	f, err := os.CreateTemp("/dev/shm", "domino2syslog-")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return err
+	}
+	if _, err := f.Write(msg); err != nil {
+		return err
+	}
+	_, _, err = j.conn.WriteMsgUnix(nil, syscall.UnixRights(int(f.Fd())), j.addr)
+	return err
+}
+
+func (j *journald) Close() error {
+	return j.conn.Close()
+}
+
+// addJournalField adds a field to a message in journald's native format,
+// unless the value is empty. Values with newlines in them have to be sent
+// with their length instead.
+func addJournalField(buf *bytes.Buffer, name, value string) {
+	if value == "" {
+		return
+	}
+	buf.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// journalFieldName turns the name of a field extracted by a rule into a
+// valid journal field name, which can only have upper case letters, digits
+// and underscores.
+func journalFieldName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,217 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	inputTypes["journald"] = func(arg string) (input, error) {
+		unit, query, _ := strings.Cut(arg, "?")
+		if unit == "" {
+			return nil, fmt.Errorf("journald input needs the name of the systemd unit running Domino")
+		}
+		jr := &journalReader{unit: unit, since: queryParams(query).Get("since")}
+		return jr.run, nil
+	}
+}
+
+// journalReader reads the output of Domino from the systemd journal, where
+// it ends up when Domino is run as a systemd service, all with the same
+// priority, so that it can be classified properly.
+type journalReader struct {
+	unit  string
+	since string // when to start, in any form journalctl understands
+	// File to save the cursor of the last entry read in, if any, so as to
+	// carry on from there next time
+	checkpoint string
+	warned     bool // whether we've complained it can't be saved
+}
+
+// journalEntry is an entry as written by journalctl in JSON. Messages which
+// aren't valid UTF-8 are arrays of bytes rather than strings.
+type journalEntry struct {
+	Cursor   string          `json:"__CURSOR"`
+	Time     string          `json:"__REALTIME_TIMESTAMP"` // microseconds
+	Hostname string          `json:"_HOSTNAME"`
+	Message  json.RawMessage `json:"MESSAGE"`
+}
+
+// run logs the unit's entries as they're added to the journal, until stop is
+// closed. With -tail-state, it carries on from the last entry read before;
+// otherwise, it starts with entries added from now on, or since the time
+// given.
+func (jr *journalReader) run(src *source, out Output, stop <-chan struct{}) error {
+	args := []string{"--unit", jr.unit, "--output", "json", "--follow"}
+	if tailStateDir != "" {
+		jr.checkpoint = filepath.Join(tailStateDir, "journald_"+checkpointNames.Replace(jr.unit)+".cursor")
+	}
+	cursor, err := jr.loadCursor()
+	switch {
+	case err != nil:
+		fmt.Fprintln(os.Stderr, err)
+		return err
+	case cursor != "":
+		args = append(args, "--after-cursor", cursor)
+	case jr.since != "":
+		args = append(args, "--since", jr.since)
+	default:
+		args = append(args, "--lines", "0")
+	}
+	cmd := exec.Command("journalctl", args...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		err = fmt.Errorf("can't run journalctl: %s", err)
+		fmt.Fprintln(os.Stderr, err)
+		return err
+	}
+	stopped := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-stop:
+			close(stopped)
+			cmd.Process.Kill()
+		case <-exited:
+		}
+	}()
+	err = jr.read(stdout, src, out)
+	waitErr := cmd.Wait()
+	close(exited)
+	select {
+	case <-stopped:
+		return nil
+	default:
+	}
+	if err == nil {
+		err = waitErr
+	}
+	if err != nil {
+		err = fmt.Errorf("error reading the journal for %s: %s", jr.unit, err)
+	} else {
+		err = fmt.Errorf("journalctl stopped following %s", jr.unit)
+	}
+	fmt.Fprintln(os.Stderr, err)
+	return err
+}
+
+// read logs the entries written by journalctl, saving the cursor of the last
+// one each time it's caught up.
+func (jr *journalReader) read(r io.Reader, src *source, out Output) error {
+	br := bufio.NewReader(r)
+	var cursor string
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var entry journalEntry
+			if jerr := json.Unmarshal(line, &entry); jerr != nil {
+				fmt.Fprintf(os.Stderr, "can't read journal entry: %s\n", jerr)
+			} else {
+				jr.process(&entry, src, out)
+				cursor = entry.Cursor
+			}
+		}
+		if cursor != "" && br.Buffered() == 0 {
+			jr.saveCursor(cursor)
+			cursor = ""
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// process logs a journal entry as a line of Domino output, with the time it
+// was written to the journal, and the host it came from if that isn't this
+// one.
+func (jr *journalReader) process(entry *journalEntry, src *source, out Output) {
+	var line []byte
+	var text string
+	if err := json.Unmarshal(entry.Message, &text); err == nil {
+		line = []byte(text)
+	} else {
+		var ints []int
+		if json.Unmarshal(entry.Message, &ints) != nil {
+			return
+		}
+		for _, b := range ints {
+			line = append(line, byte(b))
+		}
+	}
+	jo := journalOutput{Output: out}
+	if usec, err := strconv.ParseInt(entry.Time, 10, 64); err == nil {
+		jo.time = time.UnixMicro(usec)
+	}
+	if entry.Hostname != hostname {
+		jo.host = entry.Hostname
+	}
+	process(line, src, jo)
+}
+
+// loadCursor reads the cursor saved last time, if there is one.
+func (jr *journalReader) loadCursor() (string, error) {
+	if jr.checkpoint == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(jr.checkpoint)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(data)), err
+}
+
+// saveCursor saves the cursor of the last entry read, if there's somewhere
+// to.
+func (jr *journalReader) saveCursor(cursor string) {
+	if jr.checkpoint == "" {
+		return
+	}
+	tmp := jr.checkpoint + ".tmp"
+	err := os.WriteFile(tmp, []byte(cursor+"\n"), 0600)
+	if err == nil {
+		err = os.Rename(tmp, jr.checkpoint)
+	}
+	if err != nil {
+		if !jr.warned {
+			fmt.Fprintf(os.Stderr, "can't save journal cursor for %s: %s\n", jr.unit, err)
+			jr.warned = true
+		}
+		return
+	}
+	jr.warned = false
+}
+
+// journalOutput gives events the time and host of the journal entry they
+// came from.
+type journalOutput struct {
+	Output
+	time time.Time
+	host string
+}
+
+func (jo journalOutput) Write(ev *Event) error {
+	if !jo.time.IsZero() {
+		ev.Time = jo.time
+	}
+	if jo.host != "" {
+		ev.Host = jo.host
+	}
+	return jo.Output.Write(ev)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+func init() {
+	outputTypes["jsonl"] = func(arg string) (Output, error) {
+		return openJSONLines(arg)
+	}
+}
+
+// jsonLines writes events as JSON objects, one per line, to standard output
+// or a file.
+type jsonLines struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// jsonEvent is an event as written by the JSON Lines output, and other
+// outputs which send JSON.
+type jsonEvent struct {
+	Time      string            `json:"time"`
+	Host      string            `json:"host,omitempty"`
+	Severity  string            `json:"severity"`
+	Facility  string            `json:"facility"`
+	Tag       string            `json:"tag"`
+	Task      string            `json:"task,omitempty"`
+	Thread    string            `json:"thread,omitempty"`
+	Timestamp string            `json:"domino_timestamp,omitempty"`
+	Message   string            `json:"message"`
+	Rule      string            `json:"rule,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+// newJSONEvent converts an event for JSON output.
+func newJSONEvent(ev *Event) *jsonEvent {
+	return &jsonEvent{
+		Time:      ev.Time.Format(time.RFC3339Nano),
+		Host:      ev.Host,
+		Severity:  priorityName(ev.Priority),
+		Facility:  facilityName(ev.Facility),
+		Tag:       ev.Tag,
+		Task:      ev.Task,
+		Thread:    ev.Thread,
+		Timestamp: ev.Timestamp,
+		Message:   ev.Message,
+		Rule:      ev.Rule,
+		Fields:    ev.Fields,
+	}
+}
+
+// openJSONLines opens the JSON Lines output, appending to the named file,
+// or writing to standard output if filename is empty. In that case, lines
+// of console output are echoed to standard error instead, so that standard
+// output has nothing but JSON.
+func openJSONLines(filename string) (*jsonLines, error) {
+	f, err := openLinesFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	return &jsonLines{f: f, enc: enc}, nil
+}
+
+// openLinesFile opens a file for an output which writes a line for each
+// event, for appending, or returns standard output if filename is empty.
+// In that case, lines of console output are echoed to standard error
+// instead, so that standard output has nothing but events.
+func openLinesFile(filename string) (*os.File, error) {
+	if filename == "" {
+		console = os.Stderr
+		return os.Stdout, nil
+	}
+	return os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+}
+
+func (jl *jsonLines) Write(ev *Event) error {
+	jl.mu.Lock()
+	defer jl.mu.Unlock()
+	return jl.enc.Encode(newJSONEvent(ev))
+}
+
+func (jl *jsonLines) Close() error {
+	if jl.f == os.Stdout {
+		return nil
+	}
+	return jl.f.Close()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func init() {
+	outputTypes["kafka"] = func(arg string) (Output, error) {
+		return openKafka(arg)
+	}
+}
+
+// Ways of choosing the key for Kafka messages, which decides which
+// partition they go to.
+var kafkaKeys = map[string]func(ev *Event) string{
+	"host/task": func(ev *Event) string { return ev.host() + "/" + ev.Task },
+	"host":      func(ev *Event) string { return ev.host() },
+	"task":      func(ev *Event) string { return ev.Task },
+	"none":      nil,
+}
+
+// kafkaOutput publishes events to a Kafka topic as JSON.
+type kafkaOutput struct {
+	writer *kafka.Writer
+	key    func(ev *Event) string
+}
+
+// openKafka opens a Kafka output, given the brokers and topic, such as
+// broker1:9092,broker2:9092/domino. Messages are keyed by host and task
+// unless the key query parameter says otherwise.
+func openKafka(addr string) (Output, error) {
+	rest, query, _ := strings.Cut(addr, "?")
+	hosts, topic, _ := strings.Cut(rest, "/")
+	if hosts == "" || topic == "" {
+		return nil, fmt.Errorf("Kafka address %q should be brokers and a topic, such as broker:9092/domino", addr)
+	}
+	keyName := queryParams(query).Get("key")
+	if keyName == "" {
+		keyName = "host/task"
+	}
+	key, ok := kafkaKeys[keyName]
+	if !ok {
+		return nil, fmt.Errorf("unknown Kafka key %q", keyName)
+	}
+	var brokers []string
+	for _, broker := range strings.Split(hosts, ",") {
+		if _, _, err := net.SplitHostPort(broker); err != nil {
+			broker = net.JoinHostPort(broker, "9092")
+		}
+		brokers = append(brokers, broker)
+	}
+	ko := &kafkaOutput{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			BatchSize:    maxBatch,
+			// We do our own batching
+			BatchTimeout: 10 * time.Millisecond,
+			WriteTimeout: writeTimeout,
+			MaxAttempts:  1,
+		},
+		key: key,
+	}
+	return &kafkaBatch{ko, newBatchOutput("Kafka topic "+topic, ko.send)}, nil
+}
+
+// send publishes a batch of events.
+func (ko *kafkaOutput) send(evs []*Event) error {
+	msgs := make([]kafka.Message, len(evs))
+	for i, ev := range evs {
+		jev := newJSONEvent(ev)
+		jev.Host = ev.host()
+		value, err := json.Marshal(jev)
+		if err != nil {
+			return permanentError{err}
+		}
+		msgs[i].Value = value
+		if ko.key != nil {
+			msgs[i].Key = []byte(ko.key(ev))
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	err := ko.writer.WriteMessages(ctx, msgs...)
+	var werrs kafka.WriteErrors
+	if errors.As(err, &werrs) {
+		// Only send again the messages which failed
+		var retry []*Event
+		for i, werr := range werrs {
+			if werr != nil && i < len(evs) {
+				retry = append(retry, evs[i])
+			}
+		}
+		return partialError{retry, err}
+	}
+	return err
+}
+
+// kafkaBatch is a batch output which closes the Kafka writer when it's
+// done with it.
+type kafkaBatch struct {
+	ko *kafkaOutput
+	*batchOutput
+}
+
+func (kb *kafkaBatch) Close() error {
+	err := kb.batchOutput.Close()
+	if werr := kb.ko.writer.Close(); err == nil {
+		err = werr
+	}
+	return err
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatLEEF(t *testing.T) {
+	defer func(v string) { version = v }(version)
+	version = "1.2.3"
+	at := time.Date(2026, 10, 16, 9, 0, 0, 123000000, time.UTC)
+	tests := []struct {
+		name string
+		ev   Event
+		want string
+	}{
+		{
+			name: "fields",
+			ev: Event{Time: at, Host: "mail1", Facility: LOG_AUTH, Priority: LOG_WARNING, Task: "HTTP Server",
+				Message: "HTTP Server: ATTEMPT TO ACCESS SERVER by CN=Joe Bloggs/O=Example was denied",
+				Fields:  map[string]string{"user": "CN=Joe Bloggs/O=Example"}, Rule: "profile:verbose-security:4"},
+			want: "<36>Oct 16 09:00:00 mail1 LEEF:2.0|HCL|Domino|1.2.3|HTTP Server|x09|devTime=2026-10-16T09:00:00.123Z\t" +
+				"devTimeFormat=yyyy-MM-dd'T'HH:mm:ss.SSSXXX\tsev=5\tcat=warning\tidentHostName=mail1\ttask=HTTP Server\t" +
+				"usrName=CN=Joe Bloggs/O=Example\trule=profile:verbose-security:4\t" +
+				"msg=HTTP Server: ATTEMPT TO ACCESS SERVER by CN=Joe Bloggs/O=Example was denied",
+		},
+		{
+			name: "no task",
+			ev: Event{Time: at.AddDate(0, 0, -10), Host: "mail1", Facility: LOG_DAEMON, Priority: LOG_EMERG, Message: "PANIC: out of memory",
+				Thread: "[0A1C:0002-0B3C]", Timestamp: "10/16/2026 09:00:00 AM"},
+			want: "<24>Oct  6 09:00:00 mail1 LEEF:2.0|HCL|Domino|1.2.3|console|x09|devTime=2026-10-06T09:00:00.123Z\t" +
+				"devTimeFormat=yyyy-MM-dd'T'HH:mm:ss.SSSXXX\tsev=10\tcat=emerg\tidentHostName=mail1\tthread=[0A1C:0002-0B3C]\t" +
+				"dominoTimestamp=10/16/2026 09:00:00 AM\tmsg=PANIC: out of memory",
+		},
+		{
+			name: "mapped fields and separators",
+			ev: Event{Time: at, Host: "mail1", Facility: LOG_USER, Priority: LOG_DEBUG, Task: "a|b",
+				Message: "one\ttwo\r\nthree", Fields: map[string]string{"db": "mail/jb.nsf", "src_ip": "10.1.2.3", "zone": "dmz"}},
+			want: "<15>Oct 16 09:00:00 mail1 LEEF:2.0|HCL|Domino|1.2.3|a\\|b|x09|devTime=2026-10-16T09:00:00.123Z\t" +
+				"devTimeFormat=yyyy-MM-dd'T'HH:mm:ss.SSSXXX\tsev=1\tcat=debug\tidentHostName=mail1\ttask=a|b\t" +
+				"resource=mail/jb.nsf\tsrc=10.1.2.3\tzone=dmz\tmsg=one two  three",
+		},
+	}
+	for _, tt := range tests {
+		if got := formatLEEF(&tt.ev); got != tt.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"unicode/utf8"
+)
+
+// Longest line of Domino output read, in bytes, as given with -max-line.
+// Longer lines, such as some stack traces and echoes of LDAP searches, are
+// cut short and flagged with the field truncated, rather than stopping the
+// input.
+var maxLineSize = 1 << 20
+
+// Shortest -max-line allowed, so that lines aren't cut before the message.
+const minLineSize = 256
+
+// cutLine cuts a line which is too long down to maxLineSize bytes, without
+// splitting a UTF-8 character if it can help it.
+func cutLine(line []byte) []byte {
+	if len(line) <= maxLineSize {
+		return line
+	}
+	n := maxLineSize
+	for i := 0; i < utf8.UTFMax-1 && n > 0 && !utf8.RuneStart(line[n]); i++ {
+		n--
+	}
+	return line[:n]
+}
+
+// lineScanner splits Domino's output into lines, as bufio.Scanner does by
+// default, except that lines which are too long are cut short and the rest
+// skipped, rather than stopping with an error.
+type lineScanner struct {
+	*bufio.Scanner
+	truncated  bool // whether the last line was cut short
+	discarding bool // whether the rest of a line which was too long is being skipped
+}
+
+func newLineScanner(r io.Reader) *lineScanner {
+	ls := &lineScanner{Scanner: bufio.NewScanner(r)}
+	// Room for a line as long as allowed, with a carriage return and newline
+	ls.Buffer(nil, maxLineSize+2)
+	ls.Split(ls.split)
+	return ls
+}
+
+// split splits lines at newlines, carriage returns, or both together, since
+// files shared with Windows tools can have any mixture of them.
+func (ls *lineScanner) split(data []byte, atEOF bool) (int, []byte, error) {
+	i := bytes.IndexAny(data, "\r\n")
+	if i >= 0 && i == len(data)-1 && data[i] == '\r' && !atEOF {
+		// Wait to see whether a newline comes next
+		if ls.discarding {
+			return i, nil, nil
+		}
+		if i <= maxLineSize {
+			return 0, nil, nil
+		}
+	}
+	end := i + 1
+	if i >= 0 && data[i] == '\r' {
+		end = crEnd(data, i)
+	}
+	if ls.discarding {
+		if i < 0 {
+			return len(data), nil, nil
+		}
+		ls.discarding = false
+		// Carry on with the next line, since after skipping, the scanner
+		// waits to read more before splitting what it has
+		advance, token, err := ls.split(data[end:], atEOF)
+		return end + advance, token, err
+	}
+	if (i < 0 || i > maxLineSize) && len(data) > maxLineSize {
+		line := cutLine(data)
+		ls.truncated, ls.discarding = true, true
+		return len(line), line, nil
+	}
+	ls.truncated = false
+	switch {
+	case i >= 0:
+		return end, data[:i], nil
+	case atEOF && len(data) > 0:
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// output returns the output for the last line read, which flags it if it
+// was cut short.
+func (ls *lineScanner) output(out Output) Output {
+	if ls.truncated {
+		return truncatedOutput{out}
+	}
+	return out
+}
+
+// truncatedOutput flags events from lines which were too long, and were cut
+// short.
+type truncatedOutput struct {
+	Output
+}
+
+func (to truncatedOutput) Write(ev *Event) error {
+	if ev.Fields == nil {
+		ev.Fields = make(map[string]string, 1)
+	}
+	ev.Fields["truncated"] = "true"
+	return to.Output.Write(ev)
+}
+
+// crEnd returns where the line ending at a carriage return at i in a line
+// ends, taking in any newline after it.
+func crEnd(line []byte, i int) int {
+	if i+1 < len(line) && line[i+1] == '\n' {
+		return i + 2
+	}
+	return i + 1
+}
+
+// splitCR splits a line at any carriage returns in it, which end lines just
+// as newlines do, ignoring one at the end.
+func splitCR(line []byte) [][]byte {
+	return bytes.Split(bytes.TrimSuffix(line, []byte{'\r'}), []byte{'\r'})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+	"unicode/utf8"
+)
+
+// chunkReader returns each of its chunks from a separate read.
+type chunkReader struct {
+	chunks []string
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	if len(cr.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, cr.chunks[0])
+	cr.chunks[0] = cr.chunks[0][n:]
+	if cr.chunks[0] == "" {
+		cr.chunks = cr.chunks[1:]
+	}
+	return n, nil
+}
+
+// scanLines returns the lines a lineScanner reads, with a * after those
+// which were cut short.
+func scanLines(t *testing.T, r io.Reader) []string {
+	t.Helper()
+	var lines []string
+	ls := newLineScanner(r)
+	for ls.Scan() {
+		line := ls.Text()
+		if ls.truncated {
+			line += "*"
+		}
+		lines = append(lines, line)
+	}
+	if err := ls.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func TestLineScannerEndings(t *testing.T) {
+	tests := []struct {
+		name string
+		r    io.Reader
+		want []string
+	}{
+		{"newlines", strings.NewReader("one\ntwo\n"), []string{"one", "two"}},
+		{"mixed", strings.NewReader("one\ntwo\r\nthree\rfour\n\nsix"), []string{"one", "two", "three", "four", "", "six"}},
+		{"carriage return last", strings.NewReader("one\r"), []string{"one"}},
+		{"CRLF across reads", &chunkReader{[]string{"one\r", "\ntwo\r", "\r\n"}}, []string{"one", "two", ""}},
+		{"a byte at a time", iotest.OneByteReader(strings.NewReader("one\r\ntwo\rthree\n")), []string{"one", "two", "three"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := scanLines(t, tt.r)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("got lines %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineScannerTooLong(t *testing.T) {
+	defer func(size int) { maxLineSize = size }(maxLineSize)
+	maxLineSize = minLineSize
+
+	long := strings.Repeat("x", 3*minLineSize)
+	for _, r := range []io.Reader{
+		strings.NewReader("one\n" + long + "\r\ntwo\n"),
+		iotest.OneByteReader(strings.NewReader("one\n" + long + "\r\ntwo\n")),
+	} {
+		got := scanLines(t, r)
+		want := []string{"one", long[:minLineSize] + "*", "two"}
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("got lines %q, want %q", got, want)
+		}
+	}
+
+	// Cut without splitting a character
+	wide := strings.Repeat("é", minLineSize)
+	got := scanLines(t, strings.NewReader(wide+"\n"))
+	if len(got) != 1 || !strings.HasSuffix(got[0], "*") {
+		t.Fatalf("got lines %q, want one cut short", got)
+	}
+	if line := strings.TrimSuffix(got[0], "*"); len(line) > minLineSize || !utf8.ValidString(line) {
+		t.Errorf("cut line of %d bytes, valid UTF-8 %v", len(line), utf8.ValidString(line))
+	}
+}
+
+func TestLineScannerAfterTooLong(t *testing.T) {
+	defer func(size int) { maxLineSize = size }(maxLineSize)
+	maxLineSize = minLineSize
+
+	// The line after one which is too long comes without waiting for more,
+	// even if it's read along with the end of the long one
+	pr, pw := io.Pipe()
+	go pw.Write([]byte(strings.Repeat("x", 3*minLineSize) + "\nnext\n"))
+	lines := make(chan string)
+	go func() {
+		ls := newLineScanner(pr)
+		for ls.Scan() {
+			lines <- ls.Text()
+		}
+		close(lines)
+	}()
+	// Let the scanner finish before maxLineSize is put back
+	defer func() {
+		pw.Close()
+		for range lines {
+		}
+	}()
+	for _, want := range []string{strings.Repeat("x", minLineSize), "next"} {
+		select {
+		case got := <-lines:
+			if got != want {
+				t.Errorf("got line %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no line %q", want)
+		}
+	}
+}
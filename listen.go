@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	inputTypes["listen"] = func(arg string) (input, error) {
+		ln, err := newListener(arg)
+		if err != nil {
+			return nil, err
+		}
+		return ln.run, nil
+	}
+}
+
+// listener accepts lines of Domino output from forwarders on other hosts,
+// over TCP or TLS, and logs them as coming from those hosts.
+type listener struct {
+	addr       string
+	conf       *tls.Config   // nil for plain TCP
+	octetCount bool          // lines are framed as RFC 6587 octet counts
+	idle       time.Duration // how long a connection can be idle, or 0 for ever
+}
+
+// How long a forwarder's connection can be idle before it's closed, unless
+// given with idle=.
+const defaultListenIdle = time.Hour
+
+// newListener parses the address to listen on, such as tcp://:5140, or
+// tls://:6514?cert=server.pem&key=server.key to listen with TLS. With TLS,
+// giving ca as well requires forwarders to present a client certificate
+// signed by one of its authorities. With framing=octet, each line is
+// prefixed with its length and a space, as for syslog over TCP; otherwise
+// lines end with newlines. With idle, connections which nothing is sent over
+// for that long, such as 10m, are closed, or with 0, never.
+func newListener(addr string) (*listener, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "tcp" && u.Scheme != "tls" {
+		return nil, fmt.Errorf("listen input %q should be tcp:// or tls:// followed by the address to listen on", addr)
+	}
+	params := queryParams(u.RawQuery)
+	ln := &listener{addr: u.Host, idle: defaultListenIdle}
+	if idle := params.Get("idle"); idle != "" {
+		if ln.idle, err = time.ParseDuration(idle); err != nil || ln.idle < 0 {
+			return nil, fmt.Errorf("bad idle time %q for listen input", idle)
+		}
+	}
+	switch framing := params.Get("framing"); framing {
+	case "", framingLF:
+	case framingOctet:
+		ln.octetCount = true
+	default:
+		return nil, fmt.Errorf("unknown framing %q for listen input; should be %s or %s", framing, framingOctet, framingLF)
+	}
+	if u.Scheme == "tls" {
+		if ln.conf, err = serverTLSConfig(params.Get("cert"), params.Get("key"), params.Get("ca")); err != nil {
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// serverTLSConfig builds the TLS configuration for listening, with the
+// server's certificate and key, and if ca is set, requiring clients to
+// present certificates signed by its authorities.
+func serverTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("listening with TLS needs a certificate and a key file")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientCAs = x509.NewCertPool()
+		if !conf.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// run accepts connections until stop is closed, then closes them.
+func (ln *listener) run(src *source, out Output, stop <-chan struct{}) error {
+	l, err := net.Listen("tcp", ln.addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return err
+	}
+	if ln.conf != nil {
+		l = tls.NewListener(l, ln.conf)
+	}
+	var mu sync.Mutex
+	conns := make(map[net.Conn]bool)
+	stopped := make(chan struct{})
+	go func() {
+		<-stop
+		close(stopped)
+		l.Close()
+		mu.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+	}()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-stopped:
+				return nil
+			default:
+			}
+			fmt.Fprintf(os.Stderr, "error accepting connections on %s: %s\n", ln.addr, err)
+			return err
+		}
+		mu.Lock()
+		select {
+		case <-stopped:
+			// Too late for it to be closed with the others
+			conn.Close()
+		default:
+			conns[conn] = true
+		}
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ln.serve(conn, src, out)
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// serve logs the lines sent over a connection until it's closed, or nothing
+// is sent for the idle time. They're logged as coming from the host named by
+// the client's certificate, if it presented one, or else its address.
+func (ln *listener) serve(conn net.Conn, src *source, out Output) {
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if tc, ok := conn.(*tls.Conn); ok {
+		if ln.idle > 0 {
+			tc.SetDeadline(time.Now().Add(ln.idle))
+		}
+		if err := tc.Handshake(); err != nil {
+			fmt.Fprintf(os.Stderr, "TLS handshake with %s failed: %s\n", host, err)
+			return
+		}
+		if certs := tc.ConnectionState().PeerCertificates; len(certs) > 0 && certs[0].Subject.CommonName != "" {
+			host = certs[0].Subject.CommonName
+		}
+	}
+	out = hostOutput{out, host}
+	// So that -multiline and -crash-summary don't mix up lines from
+	// different forwarders
+	src = src.connection()
+	r := idleReader{conn, ln.idle}
+	var err error
+	if ln.octetCount {
+		err = ln.serveFrames(bufio.NewReader(r), src, out)
+	} else {
+		ls := newLineScanner(decodeStream(r))
+		for ls.Scan() {
+			process(ls.Bytes(), src, ls.output(out))
+		}
+		err = ls.Err()
+	}
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		fmt.Fprintf(os.Stderr, "closing connection from %s, idle for %s\n", host, ln.idle)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "error reading from %s: %s\n", host, err)
+	}
+}
+
+// serveFrames logs lines framed with their lengths until the connection is
+// closed, returning nil if it's closed between frames.
+func (ln *listener) serveFrames(r *bufio.Reader, src *source, out Output) error {
+	for {
+		line, truncated, err := readFrame(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if truncated {
+			process(line, src, truncatedOutput{out})
+		} else {
+			process(line, src, out)
+		}
+	}
+}
+
+// readFrame reads the next line sent by a forwarder, prefixed with its
+// length and a space, and reports whether it was cut short because it's
+// longer than -max-line. The rest of a line which is too long is skipped
+// without being kept.
+func readFrame(r *bufio.Reader) ([]byte, bool, error) {
+	length, err := r.ReadSlice(' ')
+	if err != nil {
+		if err == io.EOF && len(length) == 0 {
+			return nil, false, io.EOF
+		}
+		return nil, false, fmt.Errorf("error reading frame length: %s", err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(length)))
+	if err != nil || n < 0 {
+		return nil, false, fmt.Errorf("bad frame length %q", length)
+	}
+	// Enough to tell whether the line is too long, or in UTF-16, as long as
+	// it can be before it's decoded
+	keep := maxLineSize + 1
+	if wideEncoding {
+		keep = 2 * maxLineSize
+	}
+	keep = min(n, keep)
+	line := make([]byte, keep)
+	if _, err := io.ReadFull(r, line); err != nil {
+		return nil, false, err
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(n-keep)); err != nil {
+		return nil, false, err
+	}
+	switch {
+	case wideEncoding:
+		line = fromUTF16(line)
+	case n == keep:
+		line = trimNewline(line)
+	}
+	truncated := n > keep || len(line) > maxLineSize
+	return cutLine(line), truncated, nil
+}
+
+// idleReader reads from a connection, giving up with os.ErrDeadlineExceeded
+// if nothing comes for the idle time, unless it's zero.
+type idleReader struct {
+	conn net.Conn
+	idle time.Duration
+}
+
+func (ir idleReader) Read(p []byte) (int, error) {
+	if ir.idle > 0 {
+		ir.conn.SetReadDeadline(time.Now().Add(ir.idle))
+	}
+	return ir.conn.Read(p)
+}
+
+// trimNewline removes a newline, with or without a carriage return, from
+// the end of a line.
+func trimNewline(line []byte) []byte {
+	if n := len(line); n > 0 && line[n-1] == '\n' {
+		line = line[:n-1]
+	}
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line
+}
+
+// hostOutput marks events as coming from another host.
+type hostOutput struct {
+	Output
+	host string
+}
+
+func (ho hostOutput) Write(ev *Event) error {
+	ev.Host = ho.host
+	return ho.Output.Write(ev)
+}
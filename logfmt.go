@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	outputTypes["logfmt"] = func(arg string) (Output, error) {
+		f, err := openLinesFile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return &logfmtLines{f: f}, nil
+	}
+}
+
+// logfmtLines writes events in logfmt, as key=value pairs on a line, to
+// standard output or a file.
+type logfmtLines struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// formatLogfmt formats an event as a line of logfmt, without the newline.
+// Fields extracted by the rule which matched follow the usual keys, sorted
+// by name.
+func formatLogfmt(ev *Event) string {
+	var b strings.Builder
+	appendLogfmt(&b, "ts", ev.Time.UTC().Format(time.RFC3339Nano))
+	appendLogfmt(&b, "level", priorityName(ev.Priority))
+	appendLogfmt(&b, "facility", facilityName(ev.Facility))
+	appendLogfmt(&b, "tag", ev.Tag)
+	for _, kv := range [][2]string{
+		{"task", ev.Task},
+		{"thread", ev.Thread},
+		{"domino_ts", ev.Timestamp},
+	} {
+		if kv[1] != "" {
+			appendLogfmt(&b, kv[0], kv[1])
+		}
+	}
+	appendLogfmt(&b, "msg", ev.Message)
+	if ev.Rule != "" {
+		appendLogfmt(&b, "rule", ev.Rule)
+	}
+	keys := make([]string, 0, len(ev.Fields))
+	for k := range ev.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		appendLogfmt(&b, k, ev.Fields[k])
+	}
+	return b.String()
+}
+
+// appendLogfmt appends a key and value, with a space before them if they're
+// not the first. The value is quoted if it's empty or has spaces, quotes,
+// equals signs or control characters in it.
+func appendLogfmt(b *strings.Builder, key, value string) {
+	if b.Len() > 0 {
+		b.WriteByte(' ')
+	}
+	b.WriteString(key)
+	b.WriteByte('=')
+	if value == "" || strings.ContainsFunc(value, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == '\\' || r == 0x7f
+	}) {
+		value = strconv.Quote(value)
+	}
+	b.WriteString(value)
+}
+
+func (lf *logfmtLines) Write(ev *Event) error {
+	line := formatLogfmt(ev) + "\n"
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	_, err := lf.f.WriteString(line)
+	return err
+}
+
+func (lf *logfmtLines) Close() error {
+	if lf.f == os.Stdout {
+		return nil
+	}
+	return lf.f.Close()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	outputTypes["loki"] = func(arg string) (Output, error) {
+		return openLoki(arg)
+	}
+}
+
+// loki pushes events to Grafana Loki, labelled with the host, tag, Domino
+// task and severity.
+type loki struct {
+	url    string // of the push endpoint
+	tenant string
+	user   *url.Userinfo
+}
+
+// openLoki opens a Loki output, given the URL of the Loki server, such as
+// http://loki:3100. For multi-tenant Loki, the tenant ID can be given as the
+// tenant query parameter.
+func openLoki(addr string) (Output, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("Loki address %q should be a URL, such as http://loki:3100", addr)
+	}
+	l := &loki{
+		tenant: queryParams(u.RawQuery).Get("tenant"),
+		user:   u.User,
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/loki/api/v1/push"
+	l.url = u.String()
+	return newBatchOutput("Loki at "+u.Host, l.send), nil
+}
+
+// lokiStream is a set of lines with the same labels, as pushed to Loki.
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// send pushes a batch of events, grouped into streams by their labels.
+func (l *loki) send(evs []*Event) error {
+	var streams []*lokiStream
+	byLabels := make(map[string]*lokiStream)
+	for _, ev := range evs {
+		labels := map[string]string{
+			"host":     ev.host(),
+			"tag":      ev.Tag,
+			"severity": priorityName(ev.Priority),
+		}
+		if ev.Task != "" {
+			labels["task"] = ev.Task
+		}
+		key := labels["host"] + "\x00" + labels["tag"] + "\x00" + labels["task"] + "\x00" + labels["severity"]
+		stream, ok := byLabels[key]
+		if !ok {
+			stream = &lokiStream{Stream: labels}
+			byLabels[key] = stream
+			streams = append(streams, stream)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(ev.Time.UnixNano(), 10), ev.text()})
+	}
+	body, err := json.Marshal(map[string][]*lokiStream{"streams": streams})
+	if err != nil {
+		return permanentError{err}
+	}
+	req, err := http.NewRequest("POST", l.url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if l.tenant != "" {
+		req.Header.Set("X-Scope-OrgID", l.tenant)
+	}
+	if l.user != nil {
+		password, _ := l.user.Password()
+		req.SetBasicAuth(l.user.Username(), password)
+	}
+	_, err = doHTTP(req)
+	return err
+}
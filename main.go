@@ -1,41 +1,62 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
-	"log/syslog"
+	"io"
 	"os"
 	"os/exec"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
-// Domino timestamp format for time.Parse.
-var timestampFormat string
+// Domino timestamp formats for time.Parse, tried in order, if they've been
+// given.
+var timestampFormats []string
 
 // Thread IDs prepended to log lines.
 var threadIDRegex = regexp.MustCompile(`^\[([A-Z\d:-]+)\]\s+`)
 
-// Rest of the line -- optional timestamp and text message.
-var timestampRegex = regexp.MustCompile(`^(\d\d\/\d\d\/\d\d\d\d\s+\d\d:\d\d:\d\d\s+[AP]M)\s+`)
+// Rest of the line -- optional timestamp and text message. This matches any
+// numeric date and time, on a 12 or 24-hour clock; timestampFormats decide
+// what they mean.
+var timestampRegex = regexp.MustCompile(`^(\d{1,4}[/.-]\d{1,2}[/.-]\d{1,4}\s+\d{1,2}:\d\d:\d\d(?:[.,]\d+)?(?:\s+[AP]M)?)\s+`)
 
-// Number of seconds allowed between timestamp and current time before we log both.
-const minAccuracy = 90 * time.Minute // 2 * time.Second
+// Set once we've complained about a timestamp we can't parse, so we don't
+// complain about every line. Inputs parse lines concurrently.
+var warnedTimestamp atomic.Bool
 
-// Facility to use. I assume nobody needs Usenet on their Domino servers these days.
-const facility = syslog.LOG_NEWS
+// Name of the Domino task which output a message, such as "Router" or
+// "HTTP Server", when it prefixes the message.
+var taskRegex = regexp.MustCompile(`^([A-Za-z][\w .-]{0,39}?):\s`)
 
-const logTag = "domino"
+// How far Domino's timestamp on a line can be from the current time before we
+// log both. Syslog stamps each message with the time it was received, which
+// is normally close enough; but if Domino is replaying old output, or its
+// clock is off, the original timestamp is worth keeping.
+var minAccuracy = 90 * time.Minute
 
-// toUTF8 converts a string from ISO-8859-1 / Latin-1 legacy encoding to UTF-8.
-func toUTF8(bytes []byte) string {
-	utf8 := make([]rune, len(bytes))
-	for i, b := range bytes {
-		utf8[i] = rune(b)
-	}
-	return string(utf8)
-}
+// Priority for lines which don't match any rule.
+var defaultPriority = LOG_INFO
+
+// Priority for lines written to standard error which don't match any rule.
+var stderrPriority = LOG_WARNING
+
+// Default facility to use. I assume nobody needs Usenet on their Domino servers these days.
+var facility = LOG_NEWS
+
+// Syslog tag, which becomes the program name in rsyslog.
+var logTag = "domino"
+
+// Where lines of console output are echoed as they're processed.
+var console io.Writer = os.Stdout
+
+// Count of lines not sent to syslog because they matched a drop rule.
+var droppedLines uint64
+
+// Count of lines which matched no rule.
+var unmatchedLines uint64
 
 func extractThreadID(data []byte) (string, []byte) {
 	m := threadIDRegex.FindSubmatch(data)
@@ -48,71 +69,114 @@ func extractThreadID(data []byte) (string, []byte) {
 	return thread, rest
 }
 
-func extractTimestamp(data []byte) (string, []byte) {
+// extractTimestamp returns the time of Domino's timestamp at the start of a
+// line, if there is one, along with the timestamp itself if it should be
+// kept, and the rest of the line.
+func extractTimestamp(data []byte) (string, time.Time, []byte) {
 	m := timestampRegex.FindSubmatch(data)
 	timestamp := ""
+	var ts time.Time
 	rest := data
 	if len(m) > 0 {
 		stime := string(m[1])
-		ts, err := time.ParseInLocation(timestampFormat, stime, time.Local)
+		var err error
+		ts, err = parseTimestamp(stime)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "couldn't parse timestamp %s: %s\n", stime, err)
-		} else {
-			// If it's too far from now, record exactly what Domino emitted
-			tdiff := time.Now().Sub(ts)
-			if tdiff > minAccuracy {
-				timestamp = string(m[1])
+			// Leave it in the message, so it isn't lost
+			if warnedTimestamp.CompareAndSwap(false, true) {
+				fmt.Fprintf(os.Stderr, "couldn't parse timestamp %s: %s\n", stime, err)
 			}
+			return "", time.Time{}, data
+		}
+		// If it's too far from now, record exactly what Domino emitted
+		tdiff := time.Since(ts)
+		if tdiff > minAccuracy || tdiff < -minAccuracy {
+			timestamp = string(m[1])
 		}
 		rest = data[len(m[0]):]
 	}
-	return timestamp, rest
+	return timestamp, ts, rest
 }
 
-// Rule represents a rule which maps a regular expression to a syslog priority
-// level.
-type Rule struct {
-	re  *regexp.Regexp
-	lvl syslog.Priority
-}
-
-func NewRule(re string, lvl syslog.Priority) Rule {
-	return Rule{regexp.MustCompile(re), lvl}
+// parseTimestamp parses a Domino timestamp using the first of the
+// timestampFormats which fits, or working out the format if none are given.
+func parseTimestamp(stime string) (time.Time, error) {
+	if len(timestampFormats) == 0 {
+		return detectTimestamp(stime)
+	}
+	var err error
+	for _, layout := range timestampFormats {
+		var ts time.Time
+		ts, err = time.ParseInLocation(layout, stime, dominoLocation)
+		if err == nil {
+			return ts, nil
+		}
+	}
+	return time.Time{}, err
 }
 
-// Ideally the rules would be in a config file, but I rarely change them.
-var rules = []Rule{
-	NewRule("Access control is set in .* to not allow replication from", syslog.LOG_ERR),
-	NewRule("Access control is set in .* to not replicate", syslog.LOG_WARNING),
-	NewRule("not authorized to", syslog.LOG_WARNING),
-	NewRule("Unable to find path to server.", syslog.LOG_CRIT),
-	NewRule("No route is known from this host to ", syslog.LOG_CRIT),
-	NewRule("The server is not responding", syslog.LOG_CRIT),
-	NewRule("Server not reachable on Cluster Port", syslog.LOG_CRIT),
-	NewRule("Full text operations on database .* which is not full text indexed", syslog.LOG_WARNING),
-	NewRule("ATTEMPT TO ACCESS SERVER by .* was denied", syslog.LOG_ERR),
-	NewRule("Directory Assistance could not", syslog.LOG_ERR),
-	NewRule("Corrupt Data Exception", syslog.LOG_ERR),
-	NewRule("Couldn't find design note", syslog.LOG_ERR),
-	NewRule("\berror\b", syslog.LOG_ERR),
-	NewRule("Warning:", syslog.LOG_WARNING),
+// extractTask returns the name of the Domino task a message came from, or ""
+// if it doesn't say.
+func extractTask(msg string) string {
+	m := taskRegex.FindStringSubmatch(msg)
+	if m == nil {
+		return ""
+	}
+	return m[1]
 }
 
-// prioritize decides which syslog priority level to use, based on simple
-// searches of the message against the rules.
-func prioritize(msg string) syslog.Priority {
-	for _, rule := range rules {
-		if rule.re.MatchString(msg) {
-			return rule.lvl
+// classify decides how to log a message from a Domino task, by finding the
+// first rule which matches it, or with the mostSevere strategy the matching
+// rule with the highest priority. Drop rules always win. Rewrite rules are
+// applied along the way, so it also returns the message as rewritten.
+// Expression rules are evaluated to decide whether they match, and what
+// they do. The source's own rules, if any, are tried first. If no rule
+// matches, the rule returned is nil.
+func classify(msg, task string, src *source) (*Rule, string) {
+	rulesLock.RLock()
+	defer rulesLock.RUnlock()
+	var best *Rule
+	for _, set := range [2][]Rule{src.sourceRules(), rules} {
+		for i := range set {
+			rule := &set[i]
+			if !rule.matches(msg, task) {
+				continue
+			}
+			if rule.action == actionExpr {
+				// The result is a copy of the rule, with the action and
+				// priority the expression chose
+				var ok bool
+				if rule, ok = rule.evaluate(msg, task); !ok {
+					continue
+				}
+			}
+			switch {
+			case rule.action == actionRewrite:
+				atomic.AddUint64(rule.hits, 1)
+				msg = rule.re.ReplaceAllString(msg, rule.repl)
+				continue
+			case strategy == firstMatch || rule.action == actionDrop:
+				atomic.AddUint64(rule.hits, 1)
+				return rule, msg
+			case best == nil || rule.lvl < best.lvl:
+				// Lower numbers are more severe
+				best = rule
+			}
 		}
 	}
-	return syslog.LOG_INFO
+	if best != nil {
+		atomic.AddUint64(best.hits, 1)
+	} else {
+		atomic.AddUint64(&unmatchedLines, 1)
+	}
+	return best, msg
 }
 
-// process accepts a line of standard output from the Domino server,
-// processes it, and writes the results to syslog.
-func process(line []byte, slog *syslog.Writer) {
-	rest := line
+// parseLine splits a line of output from the Domino server into thread ID,
+// timestamp and message text, along with the time of the timestamp, if
+// there is one. If there's no message, ok is false.
+func parseLine(line []byte) (threadid, timestamp string, ts time.Time, msg string, ok bool) {
+	rest, decoded := splitBOM(line)
 	// Sometimes Domino prefixes lines with "> "
 	if len(rest) < 3 {
 		return
@@ -120,54 +184,128 @@ func process(line []byte, slog *syslog.Writer) {
 	if rest[0] == '>' && rest[1] == ' ' {
 		rest = rest[2:]
 	}
-	threadid, rest := extractThreadID(rest)
+	threadid, rest = extractThreadID(rest)
 	// Extract timestamp if found
-	timestamp, rest := extractTimestamp(rest)
+	timestamp, ts, rest = extractTimestamp(rest)
 	// Sometimes Domino just prints empty lines
 	if len(rest) < 1 {
 		return
 	}
-	// And Domino still logs in Latin-1 even on Linux
-	msg := toUTF8(rest)
-	pri := prioritize(msg)
-	if timestamp != "" {
-		msg = fmt.Sprintf("%s (@ %s)", msg, timestamp)
+	if decoded {
+		return threadid, timestamp, ts, string(rest), true
+	}
+	// And Domino still logs in Latin-1 even on Linux, unless it's new
+	return threadid, timestamp, ts, toUTF8(rest), true
+}
+
+// process accepts a line of standard output from the Domino server,
+// processes it, and delivers the results to the output, using the settings
+// of the source it came from, if any.
+func process(line []byte, src *source, out Output) {
+	processStream(line, src, out, false)
+}
+
+// processStream processes a line of output from the Domino server, from its
+// standard error if stderr is set, in which case it's marked with the field
+// stream=stderr, and has its own priority if no rule matches. With
+// -multiline, lines which continue the one before are logged with it, and
+// with -crash-summary, the messages of a crash are collected for its
+// summary.
+func processStream(line []byte, src *source, out Output, stderr bool) {
+	threadid, timestamp, ts, msg, ok := parseLine(line)
+	if !ok {
+		return
 	}
-	if threadid != "" {
-		msg = fmt.Sprintf("%s [%s]", msg, threadid)
+	m := &message{threadid: threadid, timestamp: timestamp, ts: ts, text: msg, read: time.Now()}
+	if crashSummary && watchCrash(m, src, out, stderr) {
+		return
 	}
-	var err error
-	switch pri {
-	case syslog.LOG_EMERG:
-		err = slog.Emerg(msg)
-	case syslog.LOG_ALERT:
-		err = slog.Alert(msg)
-	case syslog.LOG_CRIT:
-		err = slog.Crit(msg)
-	case syslog.LOG_ERR:
-		err = slog.Err(msg)
-	case syslog.LOG_WARNING:
-		err = slog.Warning(msg)
-	case syslog.LOG_NOTICE:
-		err = slog.Notice(msg)
-	default:
-		err = slog.Info(msg)
+	if multiline {
+		groupMessage(m, src, out, stderr)
+		return
 	}
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error writing to syslog: %s", err)
+	logMessage(m, src, out, stderr)
+}
+
+// logMessage classifies a message from the Domino server by its first line,
+// and delivers it to the output, as processStream does.
+func logMessage(m *message, src *source, out Output, stderr bool) {
+	ev := newEvent(defaultPriority, "")
+	if stderr {
+		ev.Priority = stderrPriority
 	}
+	ev.Time = m.read
+	ev.Tag = src.logTag()
+	ev.Thread = m.threadid
+	ev.Timestamp = m.timestamp
+	if src.backfill() && !m.ts.IsZero() {
+		// Domino's timestamp is still kept in the text, as usual, for the
+		// local syslog daemon, which stamps messages with when it gets them
+		ev.Time = m.ts
+	}
+	ev.Task = extractTask(m.text)
+	rule, msg := classify(m.text, ev.Task, src)
+	ev.Message = msg
+	if len(m.more) > 0 {
+		ev.Message += "\n" + strings.Join(m.more, "\n")
+	}
+	if rule != nil {
+		if rule.action == actionDrop {
+			atomic.AddUint64(&droppedLines, 1)
+			return
+		}
+		ev.Priority = limitPriority(ev.Task, rule.lvl)
+		if rule.fac != noFacility {
+			ev.Facility = rule.fac
+		}
+		ev.Fields = rule.fields(msg)
+		ev.Rule = rule.src
+		if rule.limiter != nil {
+			pri, fac, tag := ev.Priority, ev.Facility, ev.Tag
+			pattern := rule.re.String()
+			allowed := rule.limiter.allow(func(n int, period time.Duration) {
+				summary := newEvent(pri, fmt.Sprintf("suppressed %d occurrences of messages matching %q in the last %s", n, pattern, period))
+				summary.Facility = fac
+				summary.Tag = tag
+				deliver(out, summary)
+			})
+			if !allowed {
+				return
+			}
+		}
+	} else {
+		ev.Priority = limitPriority(ev.Task, ev.Priority)
+	}
+	escalateMail(ev)
+	for _, extract := range extracting {
+		extract(ev)
+	}
+	if stderr {
+		if ev.Fields == nil {
+			ev.Fields = make(map[string]string, 1)
+		}
+		ev.Fields["stream"] = "stderr"
+	}
+	deliver(out, ev)
 }
 
 // convertLogs reads line by line from the input scanner, writes processed
 // log entries to the syslog, and when the input EOFs it closes the channel
-// to indicate that the program can quit. Example of direct use:
-//   scanner := bufio.NewScanner(os.Stdin)
-//	 go convertLogs(scanner, logger, finished)
-func convertLogs(scanner *bufio.Scanner, logger *syslog.Writer, done chan bool) {
+// to indicate that the program can quit. Lines from standard error are
+// echoed to our own standard error, rather than the console. Example of
+// direct use:
+//   scanner := newLineScanner(os.Stdin)
+//	 go convertLogs(scanner, nil, logger, false, finished)
+func convertLogs(scanner *lineScanner, src *source, out Output, stderr bool, done chan bool) {
+	echo := console
+	if stderr {
+		echo = os.Stderr
+	}
 	for scanner.Scan() {
-		process(scanner.Bytes(), logger)
-		os.Stdout.Write((scanner.Bytes()))
-		os.Stdout.WriteString("\n")
+		processStream(scanner.Bytes(), src, scanner.output(out), stderr)
+		line, _ := splitBOM(scanner.Bytes())
+		echo.Write(line)
+		io.WriteString(echo, "\n")
 	}
 	if err := scanner.Err(); err != nil {
 		fmt.Fprintln(os.Stderr, "error reading standard input:", err)
@@ -176,8 +314,9 @@ func convertLogs(scanner *bufio.Scanner, logger *syslog.Writer, done chan bool)
 }
 
 // runCommand runs a Unix command, writing output from the command's stdout
-// to the syslog, until the command closes its output stream.
-func runCommand(cmdline []string, logger *syslog.Writer) error {
+// and stderr to the output, until the command closes them. If stop is
+// closed first, the command is terminated.
+func runCommand(cmdline []string, src *source, out Output, stop <-chan struct{}) error {
 	cmdname := cmdline[0]
 	var cmd *exec.Cmd
 	if len(cmdline) > 1 {
@@ -185,66 +324,84 @@ func runCommand(cmdline []string, logger *syslog.Writer) error {
 	} else {
 		cmd = exec.Command(cmdname)
 	}
+	return runProcess(cmd, src, out, stop)
+}
+
+// runProcess runs a command which has been set up, as runCommand does.
+func runProcess(cmd *exec.Cmd, src *source, out Output, stop <-chan struct{}) error {
+	cmdname := cmd.Args[0]
 	cmdout, err := cmd.StdoutPipe()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error getting pipe from %s: %s", cmdname, err)
+		fmt.Fprintf(os.Stderr, "error getting pipe from %s: %s\n", cmdname, err)
+	}
+	cmderr, err := cmd.StderrPipe()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error getting pipe from %s: %s\n", cmdname, err)
 	}
 
-	scanner := bufio.NewScanner(cmdout)
-
-	done := make(chan bool)
-	go convertLogs(scanner, logger, done)
+	done := make(chan bool, 2)
+	go convertLogs(newLineScanner(decodeStream(cmdout)), src, out, false, done)
+	go convertLogs(newLineScanner(decodeStream(cmderr)), src, out, true, done)
 
-	fmt.Printf("Starting %s %v", cmdname, os.Args[1:])
+	newProcessGroup(cmd)
+	fmt.Fprintf(console, "Starting %s %v\n", cmdname, os.Args[1:])
 	err = cmd.Start()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error starting %s: %s", cmdname, err)
+		fmt.Fprintf(os.Stderr, "error starting %s: %s\n", cmdname, err)
 		return err
 	}
 
-	err = cmd.Wait()
+	exited := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-stop:
+			close(stopped)
+			terminate(cmd.Process)
+		case <-exited:
+		}
+	}()
+	// The pipes are closed once the command has exited, so everything
+	// has to be read from them first
+	<-done
 	<-done
+	err = cmd.Wait()
+	close(exited)
+	select {
+	case <-stopped:
+		// We asked it to stop, so that's not an error
+		fmt.Fprintf(os.Stderr, "stopped %s\n", cmdname)
+		return nil
+	default:
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error running %s: %s", cmdname, err)
+		fmt.Fprintf(os.Stderr, "error running %s: %s\n", cmdname, err)
 	} else {
-		fmt.Fprintf(os.Stderr, "successfully ran %s to completion", cmdname)
+		fmt.Fprintf(os.Stderr, "successfully ran %s to completion\n", cmdname)
 	}
 	return err
 }
 
-func main() {
-
-	// I only care about two locales, US and EN_DK (which is US with ISO dates)
-	if strings.EqualFold(os.Getenv("LC_ALL"), "en_dk.utf-8") {
-		timestampFormat = "2006/01/02 03:04:05 PM"
-	} else {
-		timestampFormat = "01/02/2006 03:04:05 PM"
-	}
-
-	logger, err := syslog.New(syslog.LOG_INFO, logTag)
-	if err != nil {
-		panic(err)
+// reportHits logs how many lines each rule has matched, so that dead rules
+// and noisy ones can be spotted.
+func reportHits(out Output) {
+	rulesLock.RLock()
+	defer rulesLock.RUnlock()
+	for i := range rules {
+		rule := &rules[i]
+		n := atomic.LoadUint64(rule.hits)
+		notify(out, LOG_NOTICE, fmt.Sprintf("rule %s %q matched %d lines", rule.src, rule.re.String(), n))
 	}
-	defer func() {
-		cerr := logger.Close()
-		if cerr != nil {
-			fmt.Fprintf(os.Stderr, "error closing syslog: %s", cerr)
+	for _, src := range sources {
+		for i := range src.rules {
+			rule := &src.rules[i]
+			n := atomic.LoadUint64(rule.hits)
+			notify(out, LOG_NOTICE, fmt.Sprintf("rule %s %q matched %d lines", rule.src, rule.re.String(), n))
 		}
-	}()
-
-	if len(os.Args) > 2 && os.Args[1] == "run" {
-		// Explicit command line
-		runCommand(os.Args[2:], logger)
-	} else {
-		// Otherwise, pretend to be Domino and run Domino from its usual place.
-		// Oddly, the Domino 'server' command is a shell script for unspecified
-		// shell.
-		args := []string{"/bin/sh", "/opt/ibm/domino/bin/server"}
-		if len(os.Args) > 1 {
-			// Append any arguments we were given
-			args = append(args, os.Args[1:]...)
-		}
-		runCommand(args, logger)
 	}
+	notify(out, LOG_NOTICE, fmt.Sprintf("%d lines matched no rule", atomic.LoadUint64(&unmatchedLines)))
+}
 
+func main() {
+	os.Exit(dispatch(os.Args[1:]))
 }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Just enough MessagePack for the Fluentd forward protocol: strings, maps
+// and arrays, and Fluentd's EventTime extension.
+
+// appendMsgpackString appends a string in MessagePack format.
+func appendMsgpackString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xda)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, 0xdb)
+		b = binary.BigEndian.AppendUint32(b, uint32(n))
+	}
+	return append(b, s...)
+}
+
+// appendMsgpackArray appends the header of an array of n items.
+func appendMsgpackArray(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xdc)
+		return binary.BigEndian.AppendUint16(b, uint16(n))
+	}
+	b = append(b, 0xdd)
+	return binary.BigEndian.AppendUint32(b, uint32(n))
+}
+
+// appendMsgpackMap appends the header of a map of n pairs.
+func appendMsgpackMap(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xde)
+		return binary.BigEndian.AppendUint16(b, uint16(n))
+	}
+	b = append(b, 0xdf)
+	return binary.BigEndian.AppendUint32(b, uint32(n))
+}
+
+// appendMsgpackInt appends a non-negative integer.
+func appendMsgpackInt(b []byte, n int) []byte {
+	if n < 128 {
+		return append(b, byte(n))
+	}
+	b = append(b, 0xcf)
+	return binary.BigEndian.AppendUint64(b, uint64(n))
+}
+
+// appendEventTime appends a time as Fluentd's EventTime extension type,
+// which unlike a plain integer keeps the nanoseconds.
+func appendEventTime(b []byte, sec, nsec int64) []byte {
+	b = append(b, 0xd7, 0x00)
+	b = binary.BigEndian.AppendUint32(b, uint32(sec))
+	return binary.BigEndian.AppendUint32(b, uint32(nsec))
+}
+
+// readMsgpackStringMap reads a map of strings to strings, such as Fluentd's
+// acknowledgements. Anything else is an error.
+func readMsgpackStringMap(r *bufio.Reader) (map[string]string, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	var n int
+	switch {
+	case c&0xf0 == 0x80:
+		n = int(c & 0x0f)
+	case c == 0xde:
+		var n16 uint16
+		err = binary.Read(r, binary.BigEndian, &n16)
+		n = int(n16)
+	default:
+		return nil, fmt.Errorf("expected MessagePack map, got type %#x", c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		k, err := readMsgpackString(r)
+		if err != nil {
+			return nil, err
+		}
+		v, err := readMsgpackString(r)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+// readMsgpackString reads a string.
+func readMsgpackString(r *bufio.Reader) (string, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	var n int
+	switch {
+	case c&0xe0 == 0xa0:
+		n = int(c & 0x1f)
+	case c == 0xd9:
+		var n8 uint8
+		err = binary.Read(r, binary.BigEndian, &n8)
+		n = int(n8)
+	case c == 0xda:
+		var n16 uint16
+		err = binary.Read(r, binary.BigEndian, &n16)
+		n = int(n16)
+	case c == 0xdb:
+		var n32 uint32
+		err = binary.Read(r, binary.BigEndian, &n32)
+		n = int(n32)
+	default:
+		return "", fmt.Errorf("expected MessagePack string, got type %#x", c)
+	}
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return string(b), err
+}
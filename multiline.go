@@ -0,0 +1,121 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Whether to group the lines of messages which go on for several lines, such
+// as Java stack traces, into single events, as given with -multiline.
+var multiline bool
+
+// How long to wait for more lines of a message before logging it, with
+// -multiline.
+const multilineWait = 500 * time.Millisecond
+
+// Lines which continue the message before them: indented lines, and the
+// lines of Java stack traces, which Domino may have stripped the indent
+// from.
+var continuationRegex = regexp.MustCompile(`^(\s|at \S+\(|Caused by: |Suppressed: |\.\.\. \d+ more)`)
+
+// message is a line of Domino output, split up as parseLine does, along with
+// any lines continuing it.
+type message struct {
+	threadid, timestamp string
+	ts                  time.Time // of the timestamp, if there is one
+	text                string
+	more                []string
+	read                time.Time // when the first line was read
+}
+
+// stream identifies where lines come from, so that only lines from the same
+// stream are grouped. Each forwarder connected to listen has a source of its
+// own.
+type stream struct {
+	src    *source
+	stderr bool
+}
+
+// group is a message whose lines are being collected, until a line comes
+// which doesn't continue it, or none comes for a while.
+type group struct {
+	msg   *message
+	task  string // Domino task the first line is from, if any
+	out   Output
+	size  int
+	timer *time.Timer
+}
+
+var (
+	groupsLock sync.Mutex
+	groups     = make(map[stream]*group)
+)
+
+// groupMessage adds a line of output to the message being collected from its
+// stream, if it continues it. Otherwise, the message is logged, and a new
+// one started.
+func groupMessage(m *message, src *source, out Output, stderr bool) {
+	key := stream{src, stderr}
+	groupsLock.Lock()
+	g := groups[key]
+	if g != nil {
+		if text, ok := g.continuation(m); ok && g.size+len(text) < maxLineSize {
+			g.msg.more = append(g.msg.more, text)
+			g.size += len(text) + 1
+			g.timer.Reset(multilineWait)
+			groupsLock.Unlock()
+			return
+		}
+		g.timer.Stop()
+	}
+	next := &group{msg: m, task: extractTask(m.text), out: out, size: len(m.text)}
+	next.timer = time.AfterFunc(multilineWait, func() { flushGroup(key, next) })
+	groups[key] = next
+	groupsLock.Unlock()
+	if g != nil {
+		logMessage(g.msg, src, g.out, stderr)
+	}
+}
+
+// continuation returns the text of a line which continues the message being
+// collected, without the task's name if the first line had it too, and
+// reports whether it does. Lines with thread IDs only continue lines from the
+// same thread.
+func (g *group) continuation(m *message) (string, bool) {
+	if m.threadid != "" && g.msg.threadid != "" && m.threadid != g.msg.threadid {
+		return "", false
+	}
+	text := m.text
+	if g.task != "" && strings.HasPrefix(text, g.task+":") {
+		text = strings.TrimPrefix(text[len(g.task)+1:], " ")
+	}
+	return text, continuationRegex.MatchString(text)
+}
+
+// flushGroup logs a message which no more lines have come for, unless it's
+// been logged already.
+func flushGroup(key stream, g *group) {
+	groupsLock.Lock()
+	if groups[key] != g {
+		groupsLock.Unlock()
+		return
+	}
+	delete(groups, key)
+	groupsLock.Unlock()
+	logMessage(g.msg, key.src, g.out, key.stderr)
+}
+
+// flushGroups logs all the messages still being collected, once the input
+// has ended.
+func flushGroups() {
+	groupsLock.Lock()
+	pending := groups
+	groups = make(map[stream]*group)
+	groupsLock.Unlock()
+	for key, g := range pending {
+		g.timer.Stop()
+		logMessage(g.msg, key.src, g.out, key.stderr)
+	}
+}
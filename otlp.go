@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// gRPC method for exporting logs to an OpenTelemetry collector.
+const otlpLogsMethod = "/opentelemetry.proto.collector.logs.v1.LogsService/Export"
+
+// OpenTelemetry severity numbers for each syslog priority, as the
+// OpenTelemetry log data model maps them.
+var otlpSeverities = [...]uint64{
+	LOG_EMERG:   21, // FATAL
+	LOG_ALERT:   19, // ERROR3
+	LOG_CRIT:    18, // ERROR2
+	LOG_ERR:     17, // ERROR
+	LOG_WARNING: 13, // WARN
+	LOG_NOTICE:  10, // INFO2
+	LOG_INFO:    9,  // INFO
+	LOG_DEBUG:   5,  // DEBUG
+}
+
+// gRPC status codes which mean an export can be tried again, according to
+// the OTLP specification.
+var otlpRetryable = map[string]bool{
+	"1":  true, // CANCELLED
+	"4":  true, // DEADLINE_EXCEEDED
+	"8":  true, // RESOURCE_EXHAUSTED
+	"10": true, // ABORTED
+	"11": true, // OUT_OF_RANGE
+	"14": true, // UNAVAILABLE
+	"15": true, // DATA_LOSS
+}
+
+func init() {
+	outputTypes["otlp"] = func(arg string) (Output, error) {
+		return openOTLP(arg)
+	}
+}
+
+// otlpExporter exports events as log records to an OpenTelemetry collector
+// over OTLP/gRPC.
+type otlpExporter struct {
+	url      string // of the Export method
+	headers  map[string]string
+	resource []byte // encoded Resource message
+	client   *http.Client
+}
+
+// openOTLP opens an OTLP output, given the URL of the collector's gRPC
+// receiver, such as http://collector:4317, or https:// for TLS. Query
+// parameters give the Domino server's name for the resource attributes;
+// headers to send, such as for authentication, as name=value pairs
+// separated by commas; and for TLS, the ca, cert and key files, as for TLS
+// syslog.
+func openOTLP(addr string) (Output, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("OTLP address %q should be a URL, such as http://collector:4317", addr)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "4317")
+	}
+	params := queryParams(u.RawQuery)
+	ox := &otlpExporter{headers: map[string]string{}}
+	if h := params.Get("headers"); h != "" {
+		for _, pair := range strings.Split(h, ",") {
+			name, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return nil, fmt.Errorf("OTLP header %q should be name=value", pair)
+			}
+			ox.headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	// gRPC needs HTTP/2, which has to be asked for without TLS
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	if u.Scheme == "https" {
+		transport.Protocols.SetHTTP2(true)
+		transport.TLSClientConfig, err = tlsSettings{ca: params.Get("ca"), cert: params.Get("cert"), key: params.Get("key")}.config(u.Host)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	ox.client = &http.Client{Transport: transport, Timeout: httpClient.Timeout}
+	resource := map[string]string{
+		"host.name":    hostname,
+		"service.name": logTag,
+	}
+	if server := params.Get("server"); server != "" {
+		resource["domino.server.name"] = server
+	}
+	ox.resource = appendOTLPAttributes(nil, 1, resource)
+	u.RawQuery = ""
+	u.Path = strings.TrimSuffix(u.Path, "/") + otlpLogsMethod
+	ox.url = u.String()
+	return newBatchOutput("OpenTelemetry collector at "+u.Host, ox.send), nil
+}
+
+// appendOTLPAttributes appends attributes as repeated KeyValue messages with
+// string values, in order of their keys.
+func appendOTLPAttributes(b []byte, field int, attrs map[string]string) []byte {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		value := appendProtoString(nil, 1, attrs[k]) // AnyValue.string_value
+		kv := appendProtoString(nil, 1, k)
+		kv = appendProtoMessage(kv, 2, value)
+		b = appendProtoMessage(b, field, kv)
+	}
+	return b
+}
+
+// otlpLogRecord encodes an event as a LogRecord message. The message is the
+// body, and Domino's details and the fields extracted by the rules are
+// attributes.
+func otlpLogRecord(ev *Event) []byte {
+	attrs := make(map[string]string, len(ev.Fields)+5)
+	for k, v := range ev.Fields {
+		attrs[k] = v
+	}
+	attrs["syslog.facility"] = facilityName(ev.Facility)
+	for k, v := range map[string]string{"domino.task": ev.Task, "domino.thread": ev.Thread, "domino.timestamp": ev.Timestamp, "domino.rule": ev.Rule} {
+		if v != "" {
+			attrs[k] = v
+		}
+	}
+	t := uint64(ev.Time.UnixNano())
+	b := appendProtoFixed64(nil, 1, t) // time_unix_nano
+	b = appendProtoVarint(b, 2, otlpSeverities[ev.Priority&7])
+	b = appendProtoString(b, 3, priorityName(ev.Priority))
+	b = appendProtoMessage(b, 5, appendProtoString(nil, 1, ev.Message))
+	b = appendOTLPAttributes(b, 6, attrs)
+	return appendProtoFixed64(b, 11, t) // observed_time_unix_nano
+}
+
+// send exports a batch of events, as the log records of one resource.
+func (ox *otlpExporter) send(evs []*Event) error {
+	scope := appendProtoString(nil, 1, "domino2syslog")
+	scope = appendProtoString(scope, 2, version)
+	scopeLogs := appendProtoMessage(nil, 1, scope)
+	for _, ev := range evs {
+		scopeLogs = appendProtoMessage(scopeLogs, 2, otlpLogRecord(ev))
+	}
+	resourceLogs := appendProtoMessage(nil, 1, ox.resource)
+	resourceLogs = appendProtoMessage(resourceLogs, 2, scopeLogs)
+	msg := appendProtoMessage(nil, 1, resourceLogs)
+
+	// A gRPC message is prefixed with whether it's compressed and its length
+	body := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(msg)))
+	body = append(body, msg...)
+	req, err := http.NewRequest("POST", ox.url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("User-Agent", "domino2syslog/"+version)
+	for name, value := range ox.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := ox.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// The status comes in the trailers, once the response has been read,
+	// unless there's no response, in which case it's in the headers
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("%s", resp.Status)
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return err
+		}
+		return permanentError{err}
+	}
+	status, message := resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	}
+	if status == "0" {
+		return nil
+	}
+	if m, err := url.PathUnescape(message); err == nil {
+		message = m
+	}
+	err = fmt.Errorf("gRPC status %s: %s", status, message)
+	if otlpRetryable[status] {
+		return err
+	}
+	if _, perr := strconv.Atoi(status); perr != nil {
+		// No status at all, so something in between went wrong
+		return err
+	}
+	return permanentError{err}
+}
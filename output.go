@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Output is somewhere events are delivered.
+type Output interface {
+	Write(ev *Event) error
+	Close() error
+}
+
+// Where to deliver events, each as the output type, optionally followed by a
+// colon and an argument saying where exactly, such as syslog:tcp://collector,
+// and options for the output after semicolons, such as ;severity=warning.
+// With none, events go to defaultOutput.
+var outputSpecs stringList
+
+// outputTypes maps the types of output to functions which open them, given
+// the argument from the output spec.
+var outputTypes = map[string]func(arg string) (Output, error){
+	"syslog": func(arg string) (Output, error) {
+		// The message format can be chosen for each syslog output, such as
+		// syslog:tcp://collector?format=5424, or syslog:?format=5424 for the
+		// local daemon, and so can the framing over TCP or TLS
+		addr, query, _ := strings.Cut(arg, "?")
+		if addr == "" {
+			// -syslog-addr can have them too
+			var defaults string
+			addr, defaults, _ = strings.Cut(syslogAddr, "?")
+			query += "&" + defaults
+		}
+		params := queryParams(query)
+		format := params.Get("format")
+		if format == "" {
+			format = syslogFormat
+		}
+		return openSyslogOutput(addr, format, params.Get("framing"))
+	},
+}
+
+// outputTypeNames returns the names of the output types, sorted.
+func outputTypeNames() []string {
+	names := make([]string, 0, len(outputTypes))
+	for name := range outputTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// outputOptions are the options which can be given for any output: the
+// range of priorities of events to deliver to it, how to add fields to the
+// text of messages, if not as -fields says, the template for the text, if
+// not -message-template, and the file to spool events to if it fails.
+type outputOptions struct {
+	severity    Priority // least severe
+	maxSeverity Priority // most severe
+	fields      string
+	template    *template.Template
+	spool       string
+}
+
+// parseOutputSpec splits an output spec into the type of output, its
+// argument, and the options for it.
+func parseOutputSpec(spec string) (kind, arg string, opts outputOptions, err error) {
+	parts := strings.Split(spec, ";")
+	kind, arg, _ = strings.Cut(parts[0], ":")
+	if _, ok := outputTypes[kind]; !ok {
+		return "", "", opts, fmt.Errorf("unknown output %q; should be one of %s", kind, strings.Join(outputTypeNames(), ", "))
+	}
+	opts.severity, opts.maxSeverity = LOG_DEBUG, LOG_EMERG
+	for _, opt := range parts[1:] {
+		key, value, _ := strings.Cut(opt, "=")
+		switch key {
+		case "severity":
+			if opts.severity, opts.maxSeverity, err = parseSeverityRange(value); err != nil {
+				return "", "", opts, err
+			}
+		case "fields":
+			if value != "sd" && value != "json" {
+				return "", "", opts, fmt.Errorf("unknown fields format %q", value)
+			}
+			opts.fields = value
+		case "template":
+			if opts.template, err = template.New(kind).Parse(value); err != nil {
+				return "", "", opts, fmt.Errorf("bad template for output %s: %s", kind, err)
+			}
+		case "spool":
+			if value == "" {
+				return "", "", opts, fmt.Errorf("spool for output %s needs a file name", kind)
+			}
+			opts.spool = value
+		default:
+			return "", "", opts, fmt.Errorf("unknown option %q for output %s; should be severity, fields, template or spool", opt, kind)
+		}
+	}
+	return kind, arg, opts, nil
+}
+
+// parseSeverityRange parses the severity option of an output: either a
+// priority, meaning that priority or more severe, or a range of them
+// separated by a hyphen, such as debug-info, in either order. It returns
+// the least and most severe priorities in the range.
+func parseSeverityRange(s string) (least, most Priority, err error) {
+	from, to, isRange := strings.Cut(s, "-")
+	if least, err = parsePriority(from); err != nil {
+		return 0, 0, err
+	}
+	if !isRange {
+		return least, LOG_EMERG, nil
+	}
+	if most, err = parsePriority(to); err != nil {
+		return 0, 0, err
+	}
+	// Lower numbers are more severe
+	if most > least {
+		least, most = most, least
+	}
+	return least, most, nil
+}
+
+// checkOutputs checks the output specs name known types of output, with
+// valid options.
+func checkOutputs() error {
+	for _, spec := range outputSpecs {
+		if _, _, _, err := parseOutputSpec(spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openOutput opens the outputs chosen by the flags, which are delivered to
+// together.
+func openOutput() (Output, error) {
+	specs := outputSpecs
+	if len(specs) == 0 {
+		specs = []string{defaultOutput}
+	}
+	var mo multiOutput
+	for _, spec := range specs {
+		kind, arg, opts, err := parseOutputSpec(spec)
+		if err == nil {
+			var out Output
+			if out, err = outputTypes[kind](arg); err == nil {
+				if opts.spool != "" {
+					var so *spoolOutput
+					if so, err = newSpoolOutput(out, opts.spool, opts.template); err != nil {
+						out.Close()
+					}
+					out = so
+				}
+			}
+			if err == nil {
+				mo = append(mo, namedOutput{spec, filterOutput{out, opts}})
+				continue
+			}
+		}
+		mo.Close()
+		return nil, fmt.Errorf("error opening %s: %s", spec, err)
+	}
+	return mo, nil
+}
+
+// filterOutput delivers only the events in a range of severities to an
+// output, with its own fields format and template.
+type filterOutput struct {
+	Output
+	opts outputOptions
+}
+
+func (fo filterOutput) Write(ev *Event) error {
+	// Lower numbers are more severe
+	if ev.Priority > fo.opts.severity || ev.Priority < fo.opts.maxSeverity {
+		return nil
+	}
+	if fo.opts.fields != "" || fo.opts.template != nil {
+		// Other outputs may be sent the same event
+		copied := *ev
+		if fo.opts.fields != "" {
+			copied.FieldsFormat = fo.opts.fields
+		}
+		if fo.opts.template != nil {
+			copied.Template = fo.opts.template
+		}
+		ev = &copied
+	}
+	return fo.Output.Write(ev)
+}
+
+// namedOutput is an output along with its spec, for messages.
+type namedOutput struct {
+	spec string
+	Output
+}
+
+// multiOutput delivers events to several outputs.
+type multiOutput []namedOutput
+
+// Write delivers an event to every output, even if some of them fail.
+func (mo multiOutput) Write(ev *Event) error {
+	var errs []error
+	for _, out := range mo {
+		if err := out.Write(ev); err != nil {
+			errs = append(errs, fmt.Errorf("error writing to %s: %s", out.spec, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (mo multiOutput) Close() error {
+	var errs []error
+	for _, out := range mo {
+		if err := out.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("error closing %s: %s", out.spec, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// queryParams parses the query part of an output's URL. Unlike
+// url.ParseQuery, it leaves alone percent signs which aren't valid escapes,
+// so that patterns such as domino-%Y.%m.%d can be given without escaping.
+func queryParams(rawQuery string) url.Values {
+	params := url.Values{}
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, "=")
+		params.Add(unescapeParam(key), unescapeParam(value))
+	}
+	return params
+}
+
+// unescapeParam unescapes a query parameter, or returns it as it is if it
+// isn't validly escaped.
+func unescapeParam(s string) string {
+	if u, err := url.QueryUnescape(s); err == nil {
+		return u
+	}
+	return s
+}
+
+// deliver stamps an event, if need be, and writes it to an output, reporting
+// any error.
+func deliver(out Output, ev *Event) {
+	stamp(ev)
+	if err := out.Write(ev); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// notify sends a message of our own to an output.
+func notify(out Output, pri Priority, msg string) {
+	deliver(out, newEvent(pri, msg))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Settings for sending old messages again, with the replay and
+// replay-dead-letters commands, as given with -speed, -from and -to.
+var (
+	speedFlag = "max"
+	fromFlag  string
+	toFlag    string
+)
+
+// How many times faster than they were first logged old messages are sent,
+// or 0 to send them as fast as possible.
+var replaySpeed float64
+
+// The times old messages have to be between to be sent, if set.
+var replayFrom, replayTo time.Time
+
+// Whether old messages are being sent again, which outputs that can't keep
+// their original times refuse to do.
+var replaying bool
+
+// checkReplaySettings checks the settings for replaying old messages, and
+// sets the variables which depend on them.
+func checkReplaySettings() error {
+	var err error
+	if replaySpeed, err = parseSpeed(speedFlag); err != nil {
+		return err
+	}
+	replayFrom, replayTo = time.Time{}, time.Time{}
+	if fromFlag != "" {
+		if replayFrom, err = parseReplayTime(fromFlag); err != nil {
+			return err
+		}
+	}
+	if toFlag != "" {
+		if replayTo, err = parseReplayTime(toFlag); err != nil {
+			return err
+		}
+	}
+	if !replayFrom.IsZero() && !replayTo.IsZero() && replayTo.Before(replayFrom) {
+		return fmt.Errorf("-to %s is before -from %s", toFlag, fromFlag)
+	}
+	return nil
+}
+
+// parseSpeed parses a speed given with -speed: max for as fast as possible,
+// realtime, or a number of times faster than real time followed by x, such
+// as 10x, or 0.5x for half speed.
+func parseSpeed(s string) (float64, error) {
+	switch s {
+	case "max", "":
+		return 0, nil
+	case "realtime":
+		return 1, nil
+	}
+	n, err := strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+	if err != nil || !strings.HasSuffix(s, "x") || n <= 0 {
+		return 0, fmt.Errorf("bad speed %q, should be max, realtime, or a multiple of real time such as 10x", s)
+	}
+	return n, nil
+}
+
+// parseReplayTime parses a time given with -from or -to, as for rule
+// expiry times.
+func parseReplayTime(s string) (time.Time, error) {
+	for _, layout := range expiryLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad time %q, should be like 2006-01-02 15:04", s)
+}
+
+// inReplayWindow reports whether an old message logged at a time should be
+// sent, given -from and -to. A message whose time isn't known is only sent
+// if neither is given.
+func inReplayWindow(t time.Time) bool {
+	if t.IsZero() {
+		return replayFrom.IsZero() && replayTo.IsZero()
+	}
+	if !replayFrom.IsZero() && t.Before(replayFrom) {
+		return false
+	}
+	if !replayTo.IsZero() && t.After(replayTo) {
+		return false
+	}
+	return true
+}
+
+// pacer spaces out old messages as they were first logged, at -speed.
+type pacer struct {
+	start time.Time // when the first message was sent
+	first time.Time // when it was first logged
+}
+
+// wait waits until it's time to send a message first logged at t. Messages
+// logged before the first, or whose time isn't known, are sent straight
+// away.
+func (p *pacer) wait(t time.Time) {
+	if replaySpeed == 0 || t.IsZero() {
+		return
+	}
+	if p.first.IsZero() {
+		p.start, p.first = time.Now(), t
+		return
+	}
+	due := p.start.Add(time.Duration(float64(t.Sub(p.first)) / replaySpeed))
+	if d := time.Until(due); d > 0 {
+		time.Sleep(d)
+	}
+}
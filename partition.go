@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Domino partitions run by the partitions command, each a name followed by
+// options after semicolons: data, the data directory to run the server in;
+// user, who to run it as; domino, the server script, if not -domino; and tag
+// and rules, as for inputs. For example,
+// prod1;data=/local/notesdata1;user=notes1.
+var partitionSpecs stringList
+
+// How long to wait before restarting a partition's server which has failed.
+const partitionRestartDelay = 30 * time.Second
+
+// partition is a Domino server sharing the host with others, each with its
+// own data directory, and usually its own user.
+type partition struct {
+	name   string
+	data   string
+	user   string // who to run the server as, if not us
+	domino string // path of the server script
+	src    *source
+}
+
+// parsePartitionSpec parses a partition given with -partition. Unless it's
+// given a tag, its messages are tagged with -tag and the partition's name.
+func parsePartitionSpec(spec string) (*partition, error) {
+	parts := strings.Split(spec, ";")
+	p := &partition{name: parts[0], domino: dominoServer, src: &source{}}
+	if p.name == "" {
+		return nil, fmt.Errorf("partition %q needs a name", spec)
+	}
+	for _, opt := range parts[1:] {
+		key, value, _ := strings.Cut(opt, "=")
+		switch key {
+		case "data":
+			p.data = value
+		case "user":
+			p.user = value
+		case "domino":
+			p.domino = value
+		case "tag":
+			p.src.tag = value
+		case "rules":
+			p.src.rulesFile = value
+		default:
+			return nil, fmt.Errorf("unknown option %q for partition %s; should be data, user, domino, tag or rules", opt, p.name)
+		}
+	}
+	if p.data == "" {
+		return nil, fmt.Errorf("partition %s needs its data directory, given with data=", p.name)
+	}
+	if p.src.tag == "" {
+		p.src.tag = logTag + "-" + p.name
+	}
+	return p, nil
+}
+
+// parsePartitions parses the partitions given with -partition, checking
+// their names are different.
+func parsePartitions() ([]*partition, error) {
+	parts := make([]*partition, len(partitionSpecs))
+	names := make(map[string]bool, len(partitionSpecs))
+	for i, spec := range partitionSpecs {
+		p, err := parsePartitionSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		if names[p.name] {
+			return nil, fmt.Errorf("partition %s is given more than once", p.name)
+		}
+		names[p.name] = true
+		parts[i] = p
+	}
+	return parts, nil
+}
+
+// checkPartitions checks the partitions given with -partition can be parsed.
+func checkPartitions() error {
+	_, err := parsePartitions()
+	return err
+}
+
+// runPartitions runs the Domino server of every partition given with
+// -partition, restarting any which fail, until they've all been shut down,
+// or we're interrupted or terminated, which terminates them. It returns the
+// exit status for the program.
+func runPartitions(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "partitions: partitions are given with -partition, not as arguments")
+		return 2
+	}
+	parts, err := parsePartitions()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if len(parts) == 0 {
+		fmt.Fprintln(os.Stderr, "partitions: no partitions given with -partition")
+		return 2
+	}
+	sources = make([]*source, len(parts))
+	for i, p := range parts {
+		sources[i] = p.src
+	}
+	srcrules, err := loadSourceRules()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading rules: %s\n", err)
+		return 2
+	}
+	setSourceRules(srcrules)
+
+	stop := stopOnSignal()
+	return logInput(func(out Output) error {
+		var wg sync.WaitGroup
+		errs := make([]error, len(parts))
+		for i, p := range parts {
+			wg.Add(1)
+			go func(i int, p *partition) {
+				defer wg.Done()
+				errs[i] = p.run(out, stop)
+			}(i, p)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// run runs the partition's server until it's shut down or stop is closed.
+// If it fails, that's logged, and it's started again after a while.
+func (p *partition) run(out Output, stop <-chan struct{}) error {
+	for {
+		cmdline := serverCommand(p.domino)
+		cmd := exec.Command(cmdline[0], cmdline[1:]...)
+		cmd.Dir = p.data
+		if p.user != "" {
+			if err := runAs(cmd, p.user); err != nil {
+				err = fmt.Errorf("partition %s: %s", p.name, err)
+				fmt.Fprintln(os.Stderr, err)
+				return err
+			}
+		}
+		err := runProcess(cmd, p.src, out, stop)
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		if err == nil {
+			p.notify(out, LOG_NOTICE, fmt.Sprintf("Domino server for partition %s has shut down", p.name))
+			return nil
+		}
+		p.notify(out, LOG_CRIT, fmt.Sprintf("Domino server for partition %s failed: %s; restarting it in %s", p.name, err, partitionRestartDelay))
+		select {
+		case <-time.After(partitionRestartDelay):
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// notify logs a message of our own about the partition, with its tag.
+func (p *partition) notify(out Output, pri Priority, msg string) {
+	ev := newEvent(pri, msg)
+	ev.Tag = p.src.logTag()
+	deliver(out, ev)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"plugin"
+	"strings"
+	"sync"
+)
+
+// pluginOpen is the function a Go plugin output exports as Open. Given the
+// argument from the output spec, it returns functions to write an event,
+// as a JSON object like those of the JSON Lines output, and to close the
+// output. Only standard types are used, since a plugin can't import
+// anything from here.
+type pluginOpen = func(arg string) (write func(event []byte) error, close func() error, err error)
+
+func init() {
+	outputTypes["plugin"] = func(arg string) (Output, error) {
+		return openPlugin(arg)
+	}
+}
+
+// pluginOutput delivers events to an output loaded from a Go plugin.
+type pluginOutput struct {
+	mu    sync.Mutex
+	write func(event []byte) error
+	close func() error
+}
+
+// openPlugin loads an output from a Go plugin, given the path of the shared
+// object built with go build -buildmode=plugin, optionally followed by a
+// question mark and an argument for the plugin's Open function, such as
+// /usr/local/lib/domino2syslog/teams.so?channel=ops. The plugin has to be
+// built with the same version of Go as this program.
+func openPlugin(arg string) (Output, error) {
+	path, pluginArg, _ := strings.Cut(arg, "?")
+	if path == "" {
+		return nil, fmt.Errorf("plugin output needs a plugin file, such as plugin:/usr/local/lib/domino2syslog/teams.so")
+	}
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup("Open")
+	if err != nil {
+		return nil, err
+	}
+	open, ok := sym.(pluginOpen)
+	if !ok {
+		return nil, fmt.Errorf("plugin %s's Open should be a %T, not a %T", path, open, sym)
+	}
+	write, closeFn, err := open(pluginArg)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginOutput{write: write, close: closeFn}, nil
+}
+
+func (po *pluginOutput) Write(ev *Event) error {
+	js, err := json.Marshal(newJSONEvent(ev))
+	if err != nil {
+		return err
+	}
+	po.mu.Lock()
+	defer po.mu.Unlock()
+	return po.write(js)
+}
+
+func (po *pluginOutput) Close() error {
+	po.mu.Lock()
+	defer po.mu.Unlock()
+	return po.close()
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Table events are stored in by default in PostgreSQL.
+const postgresTable = "domino_events"
+
+func init() {
+	outputTypes["postgres"] = func(arg string) (Output, error) {
+		return openPostgres(arg)
+	}
+}
+
+// postgresArchive stores events in a PostgreSQL table, creating it if need
+// be.
+type postgresArchive struct {
+	db           *sql.DB
+	schema, name string // of the table; the schema may be empty
+	out          *batchOutput
+}
+
+// openPostgres opens a PostgreSQL output, given a connection URL as libpq
+// understands it, such as
+// postgres://domino@db.example.com/logs?sslmode=verify-full&table=console.
+// The table defaults to domino_events, and may include the schema. The
+// password and other settings can also come from the usual PGPASSWORD and
+// other environment variables.
+func openPostgres(arg string) (Output, error) {
+	u, err := url.Parse(arg)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+		return nil, fmt.Errorf("PostgreSQL address %q should be a URL, such as postgres://db.example.com/logs", arg)
+	}
+	// The table isn't a connection setting, so the server mustn't see it
+	params := queryParams(u.RawQuery)
+	table := params.Get("table")
+	if table == "" {
+		table = postgresTable
+	}
+	params.Del("table")
+	u.RawQuery = params.Encode()
+	db, err := sql.Open("postgres", u.String())
+	if err != nil {
+		return nil, err
+	}
+	pa := &postgresArchive{db: db, name: table}
+	if schema, name, ok := strings.Cut(table, "."); ok {
+		pa.schema, pa.name = schema, name
+	}
+	if err := pa.createTable(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("can't set up PostgreSQL table %s: %s", table, err)
+	}
+	pa.out = newBatchOutput("PostgreSQL table "+table+" at "+u.Host, pa.send)
+	return pa, nil
+}
+
+// createTable creates the table for events, if it doesn't exist already.
+// Fields are stored as JSON, which can be queried with PostgreSQL's JSON
+// operators.
+func (pa *postgresArchive) createTable() error {
+	table := pq.QuoteIdentifier(pa.name)
+	if pa.schema != "" {
+		table = pq.QuoteIdentifier(pa.schema) + "." + table
+	}
+	_, err := pa.db.Exec(`CREATE TABLE IF NOT EXISTS ` + table + ` (
+		id bigserial PRIMARY KEY,
+		time timestamptz NOT NULL,
+		host text NOT NULL,
+		severity text NOT NULL,
+		facility text NOT NULL,
+		tag text NOT NULL,
+		task text,
+		thread text,
+		domino_timestamp text,
+		message text NOT NULL,
+		rule text,
+		fields jsonb
+	);
+	CREATE INDEX IF NOT EXISTS ` + pq.QuoteIdentifier(pa.name+"_time") + ` ON ` + table + ` (time)`)
+	return err
+}
+
+// send copies a batch of events into the table in one transaction.
+func (pa *postgresArchive) send(evs []*Event) error {
+	tx, err := pa.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	columns := []string{"time", "host", "severity", "facility", "tag", "task", "thread", "domino_timestamp", "message", "rule", "fields"}
+	copyIn := pq.CopyIn(pa.name, columns...)
+	if pa.schema != "" {
+		copyIn = pq.CopyInSchema(pa.schema, pa.name, columns...)
+	}
+	stmt, err := tx.Prepare(copyIn)
+	if err != nil {
+		return postgresError(err)
+	}
+	for _, ev := range evs {
+		var fields interface{}
+		if len(ev.Fields) > 0 {
+			js, err := json.Marshal(ev.Fields)
+			if err != nil {
+				return permanentError{err}
+			}
+			fields = string(js)
+		}
+		_, err := stmt.Exec(ev.Time, ev.host(), priorityName(ev.Priority), facilityName(ev.Facility), ev.Tag,
+			nullString(ev.Task), nullString(ev.Thread), nullString(ev.Timestamp), ev.Message, nullString(ev.Rule), fields)
+		if err != nil {
+			stmt.Close()
+			return postgresError(err)
+		}
+	}
+	// Executing the statement with no arguments ends the copy
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return postgresError(err)
+	}
+	if err := stmt.Close(); err != nil {
+		return postgresError(err)
+	}
+	return postgresError(tx.Commit())
+}
+
+// postgresError returns an error from PostgreSQL as a permanentError if
+// it's because of the data or the table, rather than the server being
+// unavailable.
+func postgresError(err error) error {
+	var pqerr *pq.Error
+	if errors.As(err, &pqerr) {
+		switch pqerr.Code.Class() {
+		case "22", "23", "42":
+			// Data exception, integrity constraint violation, or syntax
+			// error or access rule violation
+			return permanentError{err}
+		}
+	}
+	return err
+}
+
+func (pa *postgresArchive) Write(ev *Event) error {
+	return pa.out.Write(ev)
+}
+
+func (pa *postgresArchive) spoolOverflow() {
+	pa.out.spoolOverflow()
+}
+
+func (pa *postgresArchive) Close() error {
+	err := pa.out.Close()
+	if cerr := pa.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
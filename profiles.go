@@ -0,0 +1,55 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Rule profiles shipped with the program, which can be chosen by name with
+// -profile, or included in rules files as "profile:name".
+//
+//go:embed profiles/*.yaml
+var profileFS embed.FS
+
+// The profile used when no rules file or profile is given.
+const defaultProfile = "standard"
+
+// Prefix marking a rules file name as a bundled profile.
+const profilePrefix = "profile:"
+
+// Name of the profile chosen with -profile, if any.
+var profileName string
+
+// readProfile returns the contents of a bundled profile, given its rules
+// file name of the form "profile:name".
+func readProfile(filename string) ([]byte, error) {
+	name := strings.TrimPrefix(filename, profilePrefix)
+	data, err := profileFS.ReadFile(path.Join("profiles", name+".yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("unknown profile %q, available profiles are %s", name, strings.Join(profileNames(), ", "))
+	}
+	return data, nil
+}
+
+// profileNames lists the bundled profiles.
+func profileNames() []string {
+	entries, _ := profileFS.ReadDir("profiles")
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), ".yaml"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Load the default rules. They're built in, so any error is a bug.
+func init() {
+	rs, err := loadRules(profilePrefix + defaultProfile)
+	if err != nil {
+		panic(err)
+	}
+	setRules(rs)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/binary"
+)
+
+// Just enough Protocol Buffers encoding for OTLP: varints, fixed 64-bit
+// integers, strings, and embedded messages, which are built up in their own
+// buffers and then appended whole.
+
+// Protocol Buffers wire types.
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+)
+
+// appendProtoTag appends the tag of a field, made of its number and wire
+// type.
+func appendProtoTag(b []byte, field int, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+// appendProtoVarint appends an integer field, unless it's zero, which is
+// the default.
+func appendProtoVarint(b []byte, field int, n uint64) []byte {
+	if n == 0 {
+		return b
+	}
+	b = appendProtoTag(b, field, protoVarint)
+	return binary.AppendUvarint(b, n)
+}
+
+// appendProtoFixed64 appends a fixed 64-bit integer field, unless it's
+// zero.
+func appendProtoFixed64(b []byte, field int, n uint64) []byte {
+	if n == 0 {
+		return b
+	}
+	b = appendProtoTag(b, field, protoFixed64)
+	return binary.LittleEndian.AppendUint64(b, n)
+}
+
+// appendProtoString appends a string field, unless it's empty.
+func appendProtoString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = appendProtoTag(b, field, protoBytes)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// appendProtoMessage appends an embedded message field, already encoded.
+// Unlike the other fields, it's appended even if empty, since an empty
+// message can still mean something, such as in a repeated field.
+func appendProtoMessage(b []byte, field int, msg []byte) []byte {
+	b = appendProtoTag(b, field, protoBytes)
+	b = binary.AppendUvarint(b, uint64(len(msg)))
+	return append(b, msg...)
+}
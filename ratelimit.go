@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter limits how many messages a rule logs in a period of time, so
+// that a flapping condition can't flood syslog. The period starts with the
+// first message; when it ends, the number of messages suppressed is
+// reported.
+type rateLimiter struct {
+	limit  int
+	period time.Duration
+
+	mu         sync.Mutex
+	open       bool // whether a period is in progress
+	count      int  // messages seen this period
+	suppressed int  // messages over the limit this period
+}
+
+// parseRateLimit parses a rate limit of the form "count/period", for example
+// "5/10m" for five messages every ten minutes.
+func parseRateLimit(s string) (*rateLimiter, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("bad rate limit %q, should be count/period", s)
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || limit < 1 {
+		return nil, fmt.Errorf("bad rate limit count %q", parts[0])
+	}
+	period, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil || period <= 0 {
+		return nil, fmt.Errorf("bad rate limit period %q", parts[1])
+	}
+	return &rateLimiter{limit: limit, period: period}, nil
+}
+
+// allow reports whether another message can be logged. If this message
+// starts a new period, report will be called when the period ends, if any
+// messages were suppressed during it.
+func (rl *rateLimiter) allow(report func(suppressed int, period time.Duration)) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if !rl.open {
+		rl.open = true
+		rl.count = 0
+		time.AfterFunc(rl.period, func() { rl.end(report) })
+	}
+	rl.count++
+	if rl.count <= rl.limit {
+		return true
+	}
+	rl.suppressed++
+	return false
+}
+
+// end finishes the current period, reporting any suppressed messages.
+func (rl *rateLimiter) end(report func(suppressed int, period time.Duration)) {
+	rl.mu.Lock()
+	n := rl.suppressed
+	rl.suppressed = 0
+	rl.open = false
+	rl.mu.Unlock()
+	if n > 0 {
+		report(n, rl.period)
+	}
+}
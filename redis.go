@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default stream events are added to, and the length it's trimmed to.
+const (
+	defaultRedisStream = "domino:console"
+	defaultRedisMaxLen = 100000
+)
+
+func init() {
+	outputTypes["redis"] = func(arg string) (Output, error) {
+		return openRedis(arg)
+	}
+}
+
+// openRedis opens an output adding events to a Redis stream, given the
+// server's URL, such as redis://:password@redis:6379/0?stream=domino, or
+// rediss:// for TLS. The path gives the database number. Query parameters
+// give the stream, the length to trim it to, roughly, or 0 not to, and for
+// TLS, the ca, cert and key files, as for TLS syslog.
+func openRedis(addr string) (Output, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+		return nil, fmt.Errorf("Redis address %q should be a URL, such as redis://redis:6379", addr)
+	}
+	hostport := u.Host
+	if u.Port() == "" {
+		hostport = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	params := queryParams(u.RawQuery)
+	rt := &redisTransport{}
+	if u.User != nil {
+		rt.user = u.User.Username()
+		rt.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if _, err := strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("Redis database %q should be a number", db)
+		}
+		rt.db = db
+	}
+	stream := params.Get("stream")
+	if stream == "" {
+		stream = defaultRedisStream
+	}
+	maxLen := defaultRedisMaxLen
+	if s := params.Get("maxlen"); s != "" {
+		if maxLen, err = strconv.Atoi(s); err != nil || maxLen < 0 {
+			return nil, fmt.Errorf("Redis stream length %q should be a number", s)
+		}
+	}
+	var conf *tls.Config
+	if u.Scheme == "rediss" {
+		conf, err = tlsSettings{ca: params.Get("ca"), cert: params.Get("cert"), key: params.Get("key")}.config(hostport)
+		if err != nil {
+			return nil, err
+		}
+	}
+	format := func(ev *Event) string {
+		return formatXAdd(stream, maxLen, ev)
+	}
+	return &senderOutput{newNetStream("Redis at "+hostport, hostport, conf, format, rt)}, nil
+}
+
+// formatXAdd formats an event as a Redis XADD command, adding it to the
+// stream with its details as the entry's fields, along with any fields
+// extracted by the rules, and trimming the stream to roughly maxLen
+// entries, unless that's 0.
+func formatXAdd(stream string, maxLen int, ev *Event) string {
+	entry := make(map[string]string, len(ev.Fields)+10)
+	for k, v := range ev.Fields {
+		entry[k] = v
+	}
+	entry["time"] = ev.Time.Format(time.RFC3339Nano)
+	entry["message"] = ev.Message
+	entry["severity"] = priorityName(ev.Priority)
+	entry["facility"] = facilityName(ev.Facility)
+	entry["tag"] = ev.Tag
+	entry["host"] = ev.host()
+	for k, v := range map[string]string{"task": ev.Task, "thread": ev.Thread, "domino_timestamp": ev.Timestamp, "rule": ev.Rule} {
+		if v != "" {
+			entry[k] = v
+		}
+	}
+	keys := make([]string, 0, len(entry))
+	for k := range entry {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	args := []string{"XADD", stream}
+	if maxLen > 0 {
+		args = append(args, "MAXLEN", "~", strconv.Itoa(maxLen))
+	}
+	args = append(args, "*")
+	for _, k := range keys {
+		args = append(args, k, entry[k])
+	}
+	return string(appendRESPCommand(nil, args...))
+}
+
+// appendRESPCommand appends a Redis command, as an array of bulk strings.
+func appendRESPCommand(b []byte, args ...string) []byte {
+	b = fmt.Appendf(b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		b = fmt.Appendf(b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return b
+}
+
+// redisError is an error reply from Redis, as opposed to a problem with the
+// connection.
+type redisError string
+
+func (e redisError) Error() string {
+	return "error from Redis: " + string(e)
+}
+
+// readRESP reads a reply from Redis, returning simple and bulk strings and
+// integers as strings, and errors as redisErrors. Arrays are read and
+// ignored.
+func readRESP(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("empty reply from Redis")
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", redisError(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			// A null bulk string
+			return "", err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", err
+		}
+		for i := 0; i < n; i++ {
+			if _, err := readRESP(r); err != nil {
+				return "", err
+			}
+		}
+		return "", nil
+	}
+	return "", fmt.Errorf("can't understand reply from Redis: %q", line)
+}
+
+// redisTransport sends commands to Redis, pipelining each batch and then
+// reading the replies, so that it knows the batch was added.
+type redisTransport struct {
+	user, password string
+	db             string
+	r              *bufio.Reader
+}
+
+func (rt *redisTransport) open(conn net.Conn) error {
+	rt.r = bufio.NewReader(conn)
+	var cmds [][]string
+	if rt.password != "" {
+		if rt.user != "" {
+			cmds = append(cmds, []string{"AUTH", rt.user, rt.password})
+		} else {
+			cmds = append(cmds, []string{"AUTH", rt.password})
+		}
+	}
+	if rt.db != "" {
+		cmds = append(cmds, []string{"SELECT", rt.db})
+	}
+	cmds = append(cmds, []string{"CLIENT", "SETNAME", "domino2syslog"})
+	for _, cmd := range cmds {
+		if _, err := conn.Write(appendRESPCommand(nil, cmd...)); err != nil {
+			return err
+		}
+		if _, err := readRESP(rt.r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rt *redisTransport) write(conn net.Conn, msgs [][]byte) error {
+	var buf []byte
+	for _, msg := range msgs {
+		buf = append(buf, msg...)
+	}
+	if _, err := conn.Write(buf); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(writeTimeout))
+	// Read every reply, so the connection's ready for the next batch,
+	// reporting the first error
+	var firstErr error
+	for range msgs {
+		_, err := readRESP(rt.r)
+		var rerr redisError
+		if err != nil && !errors.As(err, &rerr) {
+			return err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (rt *redisTransport) close(conn net.Conn) {
+	conn.Write(appendRESPCommand(nil, "QUIT"))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Offer made when opening a RELP session.
+const relpOffer = "relp_version=0\nrelp_software=domino2syslog\ncommands=syslog"
+
+// Highest RELP transaction number, after which they start again at 1.
+const maxRELPTxnr = 999999999
+
+// relpTransport speaks the Reliable Event Logging Protocol, which rsyslog
+// supports with its imrelp module. Unlike plain TCP, the server confirms
+// each message, so we know which ones need sending again after a failure.
+type relpTransport struct {
+	r    *bufio.Reader
+	txnr int
+}
+
+// relpFrame is a RELP command or response.
+type relpFrame struct {
+	txnr    int
+	command string
+	data    []byte
+}
+
+func (rt *relpTransport) open(conn net.Conn) error {
+	rt.r = bufio.NewReader(conn)
+	rt.txnr = 0
+	if err := rt.request(conn, "open", []byte(relpOffer)); err != nil {
+		return err
+	}
+	rsp, err := rt.response()
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(rsp), "\n")[1:] {
+		if name, value, _ := strings.Cut(line, "="); name == "commands" {
+			for _, cmd := range strings.Split(value, ",") {
+				if cmd == "syslog" {
+					return nil
+				}
+			}
+		}
+	}
+	return fmt.Errorf("RELP server doesn't support the syslog command")
+}
+
+func (rt *relpTransport) write(conn net.Conn, msgs [][]byte) error {
+	w := bufio.NewWriter(conn)
+	for _, msg := range msgs {
+		if err := rt.request(w, "syslog", msg); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(writeTimeout))
+	for range msgs {
+		if _, err := rt.response(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rt *relpTransport) close(conn net.Conn) {
+	if rt.request(conn, "close", nil) == nil {
+		rt.response()
+	}
+}
+
+// request sends a command with the next transaction number.
+func (rt *relpTransport) request(w io.Writer, command string, data []byte) error {
+	if rt.txnr++; rt.txnr > maxRELPTxnr {
+		rt.txnr = 1
+	}
+	frame := fmt.Sprintf("%d %s %d", rt.txnr, command, len(data))
+	if len(data) > 0 {
+		frame += " " + string(data)
+	}
+	_, err := io.WriteString(w, frame+"\n")
+	return err
+}
+
+// response reads the response to a command, returning an error unless it
+// was successful. The data returned is what follows the response code.
+func (rt *relpTransport) response() ([]byte, error) {
+	f, err := rt.readFrame()
+	if err != nil {
+		return nil, err
+	}
+	if f.command == "serverclose" {
+		return nil, fmt.Errorf("RELP server closed the session")
+	}
+	if f.command != "rsp" {
+		return nil, fmt.Errorf("expected RELP response, got %q", f.command)
+	}
+	code, _, _ := strings.Cut(string(f.data), " ")
+	code, _, _ = strings.Cut(code, "\n")
+	if code != "200" {
+		return nil, fmt.Errorf("RELP server refused transaction %d: %s", f.txnr, f.data)
+	}
+	return f.data, nil
+}
+
+// readFrame reads a frame from the server: the transaction number,
+// command, length of data, then the data and a newline.
+func (rt *relpTransport) readFrame() (*relpFrame, error) {
+	var f relpFrame
+	txnr, err := rt.r.ReadString(' ')
+	if err != nil {
+		return nil, err
+	}
+	if f.txnr, err = strconv.Atoi(strings.TrimSuffix(txnr, " ")); err != nil {
+		return nil, fmt.Errorf("bad RELP transaction number %q", txnr)
+	}
+	if f.command, err = rt.r.ReadString(' '); err != nil {
+		return nil, err
+	}
+	f.command = strings.TrimSuffix(f.command, " ")
+	var n int
+	for {
+		c, err := rt.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if c == ' ' || c == '\n' {
+			if c == '\n' {
+				if n != 0 {
+					return nil, fmt.Errorf("RELP frame ended before its data")
+				}
+				return &f, nil
+			}
+			break
+		}
+		if c < '0' || c > '9' || n > maxRELPTxnr {
+			return nil, fmt.Errorf("bad RELP data length")
+		}
+		n = n*10 + int(c-'0')
+	}
+	f.data = make([]byte, n)
+	if _, err := io.ReadFull(rt.r, f.data); err != nil {
+		return nil, err
+	}
+	if c, err := rt.r.ReadByte(); err != nil || c != '\n' {
+		return nil, fmt.Errorf("RELP frame has no trailer")
+	}
+	return &f, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// replayLogs logs the lines of archived console logs, with the times of
+// Domino's timestamps rather than the current time, so that past output can
+// be added to the central logs. Only lines logged between -from and -to are
+// sent, at -speed. It returns the exit status for the program.
+func replayLogs(files []string) int {
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "replay: no console logs given")
+		return 2
+	}
+	// Lines are read much faster than they can be sent, so wait for room
+	// to queue each, and for them all to be sent
+	queueWait = true
+	replaying = true
+
+	src := &source{old: true}
+	var p pacer
+	return logInput(func(out Output) error {
+		read := 0
+		// Lines without timestamps were logged with the last one which had
+		// one
+		var logged time.Time
+		for _, filename := range files {
+			f, err := openLog(filename)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return err
+			}
+			scanner := newLineScanner(f)
+			for scanner.Scan() {
+				line := scanner.Bytes()
+				if _, _, ts, _, ok := parseLine(line); ok && !ts.IsZero() {
+					logged = ts
+				}
+				if !inReplayWindow(logged) {
+					continue
+				}
+				p.wait(logged)
+				process(line, src, scanner.output(out))
+				read++
+			}
+			f.Close()
+			if err := scanner.Err(); err != nil {
+				fmt.Fprintf(os.Stderr, "error reading %s: %s\n", filename, err)
+				return err
+			}
+		}
+		fmt.Fprintf(os.Stderr, "replayed %d lines\n", read)
+		return nil
+	})
+}
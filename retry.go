@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Settings for retrying network outputs: how many times to try sending
+// messages before giving up on them, or 0 to keep trying; how long to wait
+// after the first failure, which doubles each time up to the maximum; and
+// how much to vary each wait at random, as a fraction of it, so that many
+// servers don't all retry at once after an outage.
+var (
+	retryAttempts   = 0
+	retryBackoff    = time.Second
+	retryMaxBackoff = time.Minute
+	retryJitter     = 0.2
+)
+
+// checkRetrySettings checks the retry settings make sense.
+func checkRetrySettings() error {
+	switch {
+	case retryAttempts < 0:
+		return fmt.Errorf("retry attempts can't be negative")
+	case retryBackoff <= 0:
+		return fmt.Errorf("retry backoff should be more than zero")
+	case retryMaxBackoff < retryBackoff:
+		return fmt.Errorf("maximum retry backoff should be at least %s", retryBackoff)
+	case retryJitter < 0 || retryJitter > 1:
+		return fmt.Errorf("retry jitter should be a fraction between 0 and 1")
+	}
+	return nil
+}
+
+// retryDelay works out how long to wait before each retry.
+type retryDelay struct {
+	next time.Duration // before jitter; zero before the first retry
+}
+
+// wait returns how long to wait before the next retry, and doubles the
+// delay for the one after.
+func (rd *retryDelay) wait() time.Duration {
+	if rd.next == 0 {
+		rd.next = retryBackoff
+	}
+	d := rd.next
+	if rd.next *= 2; rd.next > retryMaxBackoff {
+		rd.next = retryMaxBackoff
+	}
+	return d + time.Duration((rand.Float64()*2-1)*retryJitter*float64(d))
+}
+
+// reset goes back to the shortest delay, once sending works.
+func (rd *retryDelay) reset() {
+	rd.next = 0
+}
+
+// retryStats counts the retries for an output, and the messages given up
+// on.
+type retryStats struct {
+	retries, failed uint64
+}
+
+// Retry counts for each output, by name.
+var retryCounts sync.Map
+
+func statsFor(name string) *retryStats {
+	stats, _ := retryCounts.LoadOrStore(name, &retryStats{})
+	return stats.(*retryStats)
+}
+
+// countRetry records that sending to an output is being retried.
+func countRetry(name string) {
+	atomic.AddUint64(&statsFor(name).retries, 1)
+}
+
+// countFailed records that an output has given up on n messages.
+func countFailed(name string, n int) {
+	atomic.AddUint64(&statsFor(name).failed, uint64(n))
+}
+
+// reportRetries logs how many times each output has had to retry, and how
+// many messages it has given up on, so that unreliable ones can be spotted.
+func reportRetries(out Output) {
+	var names []string
+	retryCounts.Range(func(name, _ any) bool {
+		names = append(names, name.(string))
+		return true
+	})
+	sort.Strings(names)
+	for _, name := range names {
+		stats := statsFor(name)
+		notify(out, LOG_NOTICE, fmt.Sprintf("retried sending to %s %d times, and gave up on %d messages",
+			name, atomic.LoadUint64(&stats.retries), atomic.LoadUint64(&stats.failed)))
+	}
+}
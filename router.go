@@ -0,0 +1,98 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Details picked out of the Router's and SMTP tasks' messages, such as
+// "Router: Message 0056A5B2 delivered to joe@example.com", or
+// "Router: Transferring mail to domain EXAMPLE.COM (host mx.example.com)".
+var (
+	// Message IDs are either the Router's eight hex digits, or an SMTP
+	// Message-ID
+	mailIDRegex         = regexp.MustCompile(`(?i)\bmessage(?:[ -]id)?:? \(?<?([0-9A-F]{8}|[^\s<>@()]+@[^\s<>()]+?)>?\)?(?:[\s:,;]|$)`)
+	mailRecipientsRegex = regexp.MustCompile(`(?i)\b(\d+) recipients?\b`)
+	mailDomainRegex     = regexp.MustCompile(`(?i)\bto domain ([\w-]+(?:\.[\w-]+)*)`)
+	mailAddressRegex    = regexp.MustCompile(`(?i)\b(?:to|for) <?[^\s<>@]+@([\w-]+(?:\.[\w-]+)+)`)
+	mailFailureRegex    = regexp.MustCompile(`(?i)\b(unable to (?:deliver|transfer|send)|(?:failed|failure) to (?:deliver|transfer|send)|delivery (?:failure|failed)|not delivered|no messages transferred|error transferring)`)
+	deadMailRegex       = regexp.MustCompile(`(?i)\bdead (?:mail|message)`)
+	relayDeniedRegex    = regexp.MustCompile(`(?i)\brelay(?:ing)? (?:attempt )?(?:denied|rejected|refused)|\b(?:denied|rejected|refused) (?:inbound |outbound )?relay`)
+)
+
+// isMailTask reports whether a task is one whose messages parseMail and
+// escalateMail know about.
+func isMailTask(task string) bool {
+	task = strings.ToLower(task)
+	return task == "router" || strings.HasPrefix(task, "smtp")
+}
+
+// mailFailure works out whether a message from the Router or SMTP tasks is
+// about mail which couldn't be delivered, returning dead_mail, relay_denied
+// or undeliverable, and the reason, if given; or "" if it isn't.
+func mailFailure(msg string) (failure, reason string) {
+	switch {
+	case deadMailRegex.MatchString(msg):
+		return "dead_mail", ""
+	case relayDeniedRegex.MatchString(msg):
+		return "relay_denied", ""
+	}
+	loc := mailFailureRegex.FindStringIndex(msg)
+	if loc == nil {
+		return "", ""
+	}
+	// The reason usually follows the last colon
+	if i := strings.LastIndex(msg[loc[1]:], ": "); i >= 0 {
+		reason = strings.TrimSpace(msg[loc[1]+i+2:])
+	}
+	return "undeliverable", reason
+}
+
+// escalateMail logs dead mail from the Router or SMTP tasks as at least an
+// error, and a relay being denied as at least a warning, whatever the rules
+// said, though limits on the task's priorities still apply.
+func escalateMail(ev *Event) {
+	if !isMailTask(ev.Task) {
+		return
+	}
+	pri := ev.Priority
+	switch failure, _ := mailFailure(ev.Message); failure {
+	case "dead_mail":
+		pri = LOG_ERR
+	case "relay_denied":
+		pri = LOG_WARNING
+	}
+	if pri < ev.Priority {
+		ev.Priority = limitPriority(ev.Task, pri)
+	}
+}
+
+// parseMail adds what it can find in a message from the Router or SMTP
+// tasks to the event's fields, as message_id, recipients, domain, and for
+// failures, failure and reason, leaving alone any fields the rule which
+// matched it extracted.
+func parseMail(ev *Event) {
+	if !isMailTask(ev.Task) {
+		return
+	}
+	msg := ev.Message
+	found := make(map[string]string)
+	if m := mailIDRegex.FindStringSubmatch(msg); m != nil {
+		found["message_id"] = m[1]
+	}
+	if m := mailRecipientsRegex.FindStringSubmatch(msg); m != nil {
+		found["recipients"] = m[1]
+	}
+	if m := mailDomainRegex.FindStringSubmatch(msg); m != nil {
+		found["domain"] = strings.ToLower(m[1])
+	} else if m := mailAddressRegex.FindStringSubmatch(msg); m != nil {
+		found["domain"] = strings.ToLower(m[1])
+	}
+	if failure, reason := mailFailure(msg); failure != "" {
+		found["failure"] = failure
+		if reason != "" {
+			found["reason"] = reason
+		}
+	}
+	addFields(ev, found)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMail(t *testing.T) {
+	tests := []struct {
+		task, msg string
+		fields    map[string]string // from the rule which matched
+		want      map[string]string
+	}{
+		{
+			task: "Router", msg: "Router: Message 0056A5B2 delivered to joe@example.com from jb@acme.com OFA5B2 Size: 12K Time: 00:00:01 Hop Count: 1",
+			want: map[string]string{"message_id": "0056A5B2", "domain": "example.com"},
+		},
+		{
+			task: "Router", msg: "Router: Transferring mail to domain EXAMPLE.COM (host mx.example.com [192.0.2.1]) via SMTP",
+			want: map[string]string{"domain": "example.com"},
+		},
+		{
+			task: "Router", msg: "Router: Transferred 3 messages to Mail2/Acme (host mail2.acme.com) via NRPC, 5 recipients",
+			want: map[string]string{"recipients": "5"},
+		},
+		{
+			task: "SMTP Server", msg: "SMTP Server: Message <20261016090000.1234@mail.example.com> received from mx.example.com",
+			want: map[string]string{"message_id": "20261016090000.1234@mail.example.com"},
+		},
+		{
+			task: "Router", msg: "Router: Unable to deliver message 0056A5B2 to jb@acme.com: No route found to domain acme.com",
+			want: map[string]string{"message_id": "0056A5B2", "domain": "acme.com", "failure": "undeliverable", "reason": "No route found to domain acme.com"},
+		},
+		{
+			task: "Router", msg: "Router: Message 0056A5B2 is dead mail; could not be returned to the sender",
+			want: map[string]string{"message_id": "0056A5B2", "failure": "dead_mail"},
+		},
+		{
+			task: "SMTPClient", msg: "SMTPClient: Relay attempt denied for spammer@example.net",
+			want: map[string]string{"domain": "example.net", "failure": "relay_denied"},
+		},
+		{
+			task: "Router", msg: "Router: Message 0056A5B2 delivered to joe@example.com",
+			fields: map[string]string{"domain": "from-rule"},
+			want:   map[string]string{"message_id": "0056A5B2", "domain": "from-rule"},
+		},
+		{
+			task: "HTTP Server", msg: "HTTP Server: Message 0056A5B2 delivered to joe@example.com",
+		},
+		{
+			task: "Router", msg: "Router: Shutdown is in progress",
+		},
+	}
+	for _, tt := range tests {
+		ev := Event{Task: tt.task, Message: tt.msg, Fields: tt.fields}
+		parseMail(&ev)
+		if len(ev.Fields) == 0 && len(tt.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(ev.Fields, tt.want) {
+			t.Errorf("%q: got fields %v, want %v", tt.msg, ev.Fields, tt.want)
+		}
+	}
+}
+
+func TestEscalateMail(t *testing.T) {
+	defer setRules(ruleSet{rules: rules, strategy: strategy, taskLimits: taskLimits})
+	setRules(ruleSet{rules: rules, strategy: strategy, taskLimits: map[string]taskLimit{"smtp server": {most: LOG_NOTICE, least: LOG_DEBUG}}})
+	tests := []struct {
+		task, msg string
+		pri, want Priority
+	}{
+		{"Router", "Router: Message 0056A5B2 is dead mail", LOG_INFO, LOG_ERR},
+		{"Router", "Router: Message 0056A5B2 is dead mail", LOG_CRIT, LOG_CRIT},
+		{"SMTPClient", "SMTPClient: Relay attempt denied for spammer@example.net", LOG_INFO, LOG_WARNING},
+		{"SMTP Server", "SMTP Server: Relay attempt denied for spammer@example.net", LOG_INFO, LOG_NOTICE},
+		{"Router", "Router: Unable to deliver message 0056A5B2 to jb@acme.com", LOG_INFO, LOG_INFO},
+		{"HTTP Server", "HTTP Server: dead mail", LOG_INFO, LOG_INFO},
+	}
+	for _, tt := range tests {
+		ev := Event{Task: tt.task, Message: tt.msg, Priority: tt.pri}
+		escalateMail(&ev)
+		if ev.Priority != tt.want {
+			t.Errorf("%q at %s: got %s, want %s", tt.msg, priorityName(tt.pri), priorityName(ev.Priority), priorityName(tt.want))
+		}
+		if ev.Fields != nil {
+			t.Errorf("%q: escalating added fields %v", tt.msg, ev.Fields)
+		}
+	}
+}
@@ -0,0 +1,596 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/expr-lang/expr/vm"
+	"gopkg.in/yaml.v3"
+)
+
+// Rule represents a rule which maps a regular expression to a syslog priority
+// level.
+type Rule struct {
+	hits    *uint64 // lines matched, shared by copies of the rule
+	re      *regexp.Regexp
+	kind    matchKind
+	text    string // for substring and prefix rules, lower case if fold is set
+	fold    bool
+	lvl     Priority
+	fac     Priority
+	action  ruleAction
+	repl    string
+	unless  *regexp.Regexp
+	task    string // only match lines from this Domino task, if set
+	limiter *rateLimiter
+	expires time.Time // when the rule stops applying, if set
+	program *vm.Program
+	src     string // file and line the rule came from
+}
+
+// matchKind is the type of pattern a rule uses. Substring and prefix rules
+// are matched with plain string operations, which is faster than a regular
+// expression; they still have one, quoted, for rewrites. Globs are
+// translated to regular expressions.
+type matchKind int
+
+const (
+	matchRegex matchKind = iota
+	matchSubstring
+	matchPrefix
+	matchGlob
+)
+
+// Names for the kinds of match, as used in rules files.
+var matchKinds = map[string]matchKind{
+	"":          matchRegex,
+	"regex":     matchRegex,
+	"substring": matchSubstring,
+	"prefix":    matchPrefix,
+	"glob":      matchGlob,
+}
+
+// globToRegexp translates a shell-style glob, where * matches any text and ?
+// any single character, to a regular expression. Like a shell glob, it has
+// to match the whole message.
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// matchesPattern reports whether the rule's main pattern matches a message.
+func (rule *Rule) matchesPattern(msg string) bool {
+	if rule.kind == matchRegex || rule.kind == matchGlob {
+		return rule.re.MatchString(msg)
+	}
+	if rule.fold {
+		msg = strings.ToLower(msg)
+	}
+	if rule.kind == matchPrefix {
+		return strings.HasPrefix(msg, rule.text)
+	}
+	return strings.Contains(msg, rule.text)
+}
+
+// matches reports whether the rule applies to a message from the given
+// Domino task: the rule mustn't have expired, the task must be the one the
+// rule is scoped to, if any, its pattern must match, and its exception
+// pattern, if any, must not.
+func (rule *Rule) matches(msg, task string) bool {
+	if !rule.expires.IsZero() && timeNow().After(rule.expires) {
+		return false
+	}
+	if rule.task != "" && !strings.EqualFold(rule.task, task) {
+		return false
+	}
+	if !rule.matchesPattern(msg) {
+		return false
+	}
+	return rule.unless == nil || !rule.unless.MatchString(msg)
+}
+
+// ruleAction says what to do with a line which matches a rule.
+type ruleAction int
+
+const (
+	actionLog     ruleAction = iota // log it at the rule's priority
+	actionDrop                      // don't send it to syslog at all
+	actionRewrite                   // replace the matched text, then carry on
+	actionExpr                      // evaluate an expression to decide
+)
+
+// noFacility marks a rule which logs to the default facility.
+const noFacility Priority = -1
+
+// rulesLock protects rules and strategy, which can be replaced while logs
+// are being processed.
+var rulesLock sync.RWMutex
+
+// Strategies for choosing between several rules which match a line.
+const (
+	firstMatch = "first"   // the first matching rule wins
+	mostSevere = "highest" // the most severe matching rule wins
+)
+
+var strategy = firstMatch
+
+// The active rules. Initially these are the default profile's.
+var rules []Rule
+
+// Syslog priority names as used in rules files, following syslog.conf.
+var priorityNames = map[string]Priority{
+	"emerg":   LOG_EMERG,
+	"alert":   LOG_ALERT,
+	"crit":    LOG_CRIT,
+	"err":     LOG_ERR,
+	"error":   LOG_ERR,
+	"warning": LOG_WARNING,
+	"warn":    LOG_WARNING,
+	"notice":  LOG_NOTICE,
+	"info":    LOG_INFO,
+	"debug":   LOG_DEBUG,
+}
+
+// Syslog facility names as used in rules files.
+var facilityNames = map[string]Priority{
+	"kern":     LOG_KERN,
+	"user":     LOG_USER,
+	"mail":     LOG_MAIL,
+	"daemon":   LOG_DAEMON,
+	"auth":     LOG_AUTH,
+	"syslog":   LOG_SYSLOG,
+	"lpr":      LOG_LPR,
+	"news":     LOG_NEWS,
+	"uucp":     LOG_UUCP,
+	"cron":     LOG_CRON,
+	"authpriv": LOG_AUTHPRIV,
+	"ftp":      LOG_FTP,
+	"local0":   LOG_LOCAL0,
+	"local1":   LOG_LOCAL1,
+	"local2":   LOG_LOCAL2,
+	"local3":   LOG_LOCAL3,
+	"local4":   LOG_LOCAL4,
+	"local5":   LOG_LOCAL5,
+	"local6":   LOG_LOCAL6,
+	"local7":   LOG_LOCAL7,
+}
+
+// facilityName returns the name of a syslog facility.
+func facilityName(fac Priority) string {
+	for name, f := range facilityNames {
+		if f == fac {
+			return name
+		}
+	}
+	return fmt.Sprintf("facility%d", fac>>3)
+}
+
+// parseFacility converts a facility name such as "auth" or "LOG_LOCAL3" to a
+// syslog facility.
+func parseFacility(name string) (Priority, error) {
+	key := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "log_")
+	if fac, ok := facilityNames[key]; ok {
+		return fac, nil
+	}
+	return 0, fmt.Errorf("unknown facility %q", name)
+}
+
+// priorityName returns the name of a syslog priority level.
+func priorityName(lvl Priority) string {
+	names := [...]string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+	return names[lvl&7]
+}
+
+// parsePriority converts a priority name such as "crit" or "LOG_CRIT" to a
+// syslog priority level.
+func parsePriority(name string) (Priority, error) {
+	key := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "log_")
+	if lvl, ok := priorityNames[key]; ok {
+		return lvl, nil
+	}
+	return 0, fmt.Errorf("unknown priority %q", name)
+}
+
+// ruleSet is a complete set of rules loaded from a rules file, along with
+// the strategy for applying them.
+type ruleSet struct {
+	rules      []Rule
+	strategy   string
+	taskLimits map[string]taskLimit
+}
+
+// taskLimit restricts the priority of every message from a Domino task,
+// whatever rules it matches. Remember that more severe priorities have
+// lower numbers.
+type taskLimit struct {
+	most  Priority // most severe priority allowed
+	least Priority // least severe priority allowed
+}
+
+// Limits on priority by Domino task, keyed by lower case task name.
+// Protected by rulesLock.
+var taskLimits map[string]taskLimit
+
+// setRules replaces the active rule set.
+func setRules(rs ruleSet) {
+	rulesLock.Lock()
+	rules = rs.rules
+	strategy = rs.strategy
+	taskLimits = rs.taskLimits
+	rulesLock.Unlock()
+}
+
+// limitPriority applies any limits set for a Domino task to a priority.
+func limitPriority(task string, pri Priority) Priority {
+	rulesLock.RLock()
+	limit, ok := taskLimits[strings.ToLower(task)]
+	rulesLock.RUnlock()
+	if !ok {
+		return pri
+	}
+	if pri < limit.most {
+		pri = limit.most
+	}
+	if pri > limit.least {
+		pri = limit.least
+	}
+	return pri
+}
+
+// taskLimitSpec is a limit on a task's priorities as written in a rules file.
+type taskLimitSpec struct {
+	Max string `yaml:"max"`
+	Min string `yaml:"min"`
+}
+
+// ruleSpec is a single rule as written in a rules file.
+type ruleSpec struct {
+	Match     string `yaml:"match"`
+	Type      string `yaml:"type"`
+	Fold      bool   `yaml:"case_insensitive"`
+	Priority  string `yaml:"priority"`
+	Facility  string `yaml:"facility"`
+	Action    string `yaml:"action"`
+	Replace   string `yaml:"replace"`
+	Unless    string `yaml:"unless"`
+	Task      string `yaml:"task"`
+	RateLimit string `yaml:"rate_limit"`
+	Expires   string `yaml:"expires"`
+	Expr      string `yaml:"expr"`
+	line      int    // where the rule starts in the file, for error messages
+}
+
+// UnmarshalYAML decodes a rule, recording which line of the file it's on.
+func (spec *ruleSpec) UnmarshalYAML(node *yaml.Node) error {
+	type plain ruleSpec
+	if err := node.Decode((*plain)(spec)); err != nil {
+		return err
+	}
+	spec.line = node.Line
+	return nil
+}
+
+// ruleFile is the top level structure of a rules file, for example:
+//
+//	rules:
+//	  - match: "Server not reachable on Cluster Port"
+//	    priority: crit
+//	  - match: "ATTEMPT TO ACCESS SERVER by .* was denied"
+//	    priority: err
+//	    facility: auth
+//	  - match: "^Router: Message .* delivered to"
+//	    action: drop
+//	  - match: "/local/notesdata/"
+//	    action: rewrite
+//	    replace: ""
+//	  - match: '\berror\b'
+//	    unless: "^Compacting"
+//	    priority: err
+//	  - match: "cluster replicator"
+//	    type: substring
+//	    case_insensitive: true
+//	    priority: notice
+//	  - match: "*pool is full*"
+//	    type: glob
+//	    priority: crit
+//	  - match: "not responding"
+//	    action: expr
+//	    expr: 'hour >= 1 && hour < 3 ? "drop" : "crit"'
+//	  - match: "not responding"
+//	    task: Cluster Replicator
+//	    priority: crit
+//	  - match: "Server not reachable on Cluster Port"
+//	    priority: crit
+//	    rate_limit: 5/10m
+//	  - match: "Unable to find path to server OLDHUB"
+//	    action: drop
+//	    expires: 2026-11-01
+//
+// Strategy is optional, and defaults to firstMatch. Tasks limits the
+// priorities of messages from Domino tasks, after the rules have been
+// applied; max is the most severe priority allowed and min the least:
+//
+//	tasks:
+//	  Stats:
+//	    max: info
+//
+// Include lists other rules files, relative to this one, whose rules are
+// added after this file's own; that way a local file can include a shared
+// base set and override it. Strategies and task limits in included files are
+// ignored.
+type ruleFile struct {
+	Strategy string                   `yaml:"strategy"`
+	Tasks    map[string]taskLimitSpec `yaml:"tasks"`
+	Include  []string                 `yaml:"include"`
+	Rules    []ruleSpec               `yaml:"rules"`
+}
+
+// compile turns a rule as written in a rules file into a Rule.
+func (spec ruleSpec) compile() (Rule, error) {
+	if spec.Match == "" {
+		return Rule{}, fmt.Errorf("rule has no match pattern")
+	}
+	kind, ok := matchKinds[spec.Type]
+	if !ok {
+		return Rule{}, fmt.Errorf("unknown rule type %q", spec.Type)
+	}
+	rule := Rule{hits: new(uint64), kind: kind, fold: spec.Fold, fac: noFacility, task: spec.Task}
+	// Case insensitivity applies to the unless pattern too
+	flags := ""
+	if spec.Fold {
+		flags = "(?i)"
+	}
+	pattern := spec.Match
+	switch kind {
+	case matchGlob:
+		pattern = globToRegexp(spec.Match)
+	case matchSubstring, matchPrefix:
+		rule.text = spec.Match
+		if spec.Fold {
+			rule.text = strings.ToLower(rule.text)
+		}
+		pattern = regexp.QuoteMeta(spec.Match)
+		if kind == matchPrefix {
+			pattern = "^" + pattern
+		}
+	}
+	var err error
+	rule.re, err = regexp.Compile(flags + pattern)
+	if err != nil {
+		return Rule{}, fmt.Errorf("bad pattern %q: %s", spec.Match, err)
+	}
+	if spec.Unless != "" {
+		rule.unless, err = regexp.Compile(flags + spec.Unless)
+		if err != nil {
+			return Rule{}, fmt.Errorf("bad unless pattern %q: %s", spec.Unless, err)
+		}
+	}
+	if spec.Expires != "" {
+		rule.expires, err = parseExpiry(spec.Expires)
+		if err != nil {
+			return Rule{}, err
+		}
+	}
+	switch spec.Action {
+	case "", "log":
+		rule.action = actionLog
+	case "drop":
+		// Dropped lines don't need a priority
+		rule.action = actionDrop
+		return rule, spec.checkUnlogged()
+	case "rewrite":
+		// Nor do rewrites, which just change the message for later rules
+		rule.action = actionRewrite
+		rule.repl = spec.Replace
+		return rule, spec.checkUnlogged()
+	case "expr":
+		// The expression decides the priority; the facility still applies
+		rule.action = actionExpr
+		rule.program, err = compileExpr(spec.Expr)
+		if err != nil {
+			return Rule{}, err
+		}
+		spec.Priority = "info"
+	default:
+		return Rule{}, fmt.Errorf("unknown action %q", spec.Action)
+	}
+	rule.lvl, err = parsePriority(spec.Priority)
+	if err != nil {
+		return Rule{}, err
+	}
+	if spec.Facility != "" {
+		rule.fac, err = parseFacility(spec.Facility)
+		if err != nil {
+			return Rule{}, err
+		}
+	}
+	if spec.RateLimit != "" {
+		rule.limiter, err = parseRateLimit(spec.RateLimit)
+		if err != nil {
+			return Rule{}, err
+		}
+	}
+	return rule, nil
+}
+
+// checkUnlogged complains about the settings of a rule which doesn't log the
+// lines it matches, and so can't use them.
+func (spec ruleSpec) checkUnlogged() error {
+	for _, key := range []struct{ name, value string }{
+		{"priority", spec.Priority},
+		{"facility", spec.Facility},
+		{"rate_limit", spec.RateLimit},
+	} {
+		if key.value != "" {
+			return fmt.Errorf("%s rules can't have a %s", spec.Action, key.name)
+		}
+	}
+	return nil
+}
+
+// loadConfiguredRules loads the rules file and profile chosen with -rules and
+// -profile. If both are given, the rules file's rules are checked first, as
+// if it included the profile.
+func loadConfiguredRules() (ruleSet, error) {
+	switch {
+	case rulesFile != "" && profileName != "":
+		rs, err := loadRules(rulesFile)
+		if err != nil {
+			return rs, err
+		}
+		profrs, err := loadRules(profilePrefix + profileName)
+		rs.rules = append(rs.rules, profrs.rules...)
+		return rs, err
+	case rulesFile != "":
+		return loadRules(rulesFile)
+	case profileName != "":
+		return loadRules(profilePrefix + profileName)
+	}
+	return loadRules(profilePrefix + defaultProfile)
+}
+
+// Layouts accepted for rule expiry times. Those without a time zone are in
+// local time.
+var expiryLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseExpiry parses the time a rule expires.
+func parseExpiry(s string) (time.Time, error) {
+	for _, layout := range expiryLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad expiry time %q, should be like 2006-01-02 15:04", s)
+}
+
+// readRules reads a YAML rules file and compiles its rules, along with those
+// of any files it includes. Rather than stopping at the first problem, it
+// returns every error it finds, each prefixed with the file name and line
+// number.
+func readRules(filename string) (ruleSet, []error) {
+	rf, errs := readRuleFile(filename)
+	if rf == nil {
+		return ruleSet{}, errs
+	}
+	rs := ruleSet{strategy: firstMatch}
+	switch rf.Strategy {
+	case "", firstMatch:
+	case mostSevere:
+		rs.strategy = mostSevere
+	default:
+		errs = append(errs, fmt.Errorf("%s: unknown strategy %q", filename, rf.Strategy))
+	}
+	for task, spec := range rf.Tasks {
+		limit := taskLimit{most: LOG_EMERG, least: LOG_DEBUG}
+		var err error
+		if spec.Max != "" {
+			if limit.most, err = parsePriority(spec.Max); err != nil {
+				errs = append(errs, fmt.Errorf("%s: task %s: %s", filename, task, err))
+			}
+		}
+		if spec.Min != "" {
+			if limit.least, err = parsePriority(spec.Min); err != nil {
+				errs = append(errs, fmt.Errorf("%s: task %s: %s", filename, task, err))
+			}
+		}
+		if rs.taskLimits == nil {
+			rs.taskLimits = make(map[string]taskLimit)
+		}
+		rs.taskLimits[strings.ToLower(task)] = limit
+	}
+	seen := map[string]bool{}
+	rs.rules, errs = compileRuleFile(filename, rf, seen, errs)
+	return rs, errs
+}
+
+// readRuleFile parses a rules file without compiling anything. The file can
+// be a bundled profile.
+func readRuleFile(filename string) (*ruleFile, []error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(filename, profilePrefix) {
+		data, err = readProfile(filename)
+	} else {
+		data, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		return nil, []error{err}
+	}
+	var rf ruleFile
+	if err := yaml.Unmarshal(data, &rf); err != nil {
+		return nil, []error{fmt.Errorf("%s: %s", filename, err)}
+	}
+	return &rf, nil
+}
+
+// compileRuleFile compiles the rules in a parsed rules file, followed by the
+// rules of the files it includes. Files already in seen are skipped, so that
+// include loops don't recurse forever. Errors are appended to errs.
+func compileRuleFile(filename string, rf *ruleFile, seen map[string]bool, errs []error) ([]Rule, []error) {
+	isProfile := strings.HasPrefix(filename, profilePrefix)
+	if abs, err := filepath.Abs(filename); err == nil && !isProfile {
+		filename = abs
+	}
+	seen[filename] = true
+	newrules := make([]Rule, 0, len(rf.Rules))
+	for _, spec := range rf.Rules {
+		rule, err := spec.compile()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %s", filename, spec.line, err))
+			continue
+		}
+		rule.src = fmt.Sprintf("%s:%d", filename, spec.line)
+		newrules = append(newrules, rule)
+	}
+	for _, inc := range rf.Include {
+		if !filepath.IsAbs(inc) && !strings.HasPrefix(inc, profilePrefix) {
+			inc = filepath.Join(filepath.Dir(filename), inc)
+		}
+		if seen[inc] {
+			errs = append(errs, fmt.Errorf("%s: %s is already included (duplicate or loop)", filename, inc))
+			continue
+		}
+		incrf, incerrs := readRuleFile(inc)
+		errs = append(errs, incerrs...)
+		if incrf == nil {
+			continue
+		}
+		var incrules []Rule
+		incrules, errs = compileRuleFile(inc, incrf, seen, errs)
+		newrules = append(newrules, incrules...)
+	}
+	return newrules, errs
+}
+
+// loadRules reads and compiles the rules in a YAML rules file, failing if
+// there are any errors.
+func loadRules(filename string) (ruleSet, error) {
+	rs, errs := readRules(filename)
+	if len(errs) > 0 {
+		return ruleSet{}, errs[0]
+	}
+	return rs, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// compileRules loads the rules in a rules file with the given contents.
+func compileRules(t *testing.T, yaml string) (ruleSet, error) {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(filename, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return loadRules(filename)
+}
+
+func TestRuleExpires(t *testing.T) {
+	tests := []struct {
+		name string
+		rule string
+	}{
+		{name: "log", rule: "priority: crit"},
+		{name: "drop", rule: "action: drop"},
+		{name: "rewrite", rule: "action: rewrite\n    replace: NEWHUB"},
+		{name: "expr", rule: "action: expr\n    expr: '\"crit\"'"},
+	}
+	defer func() { timeNow = time.Now }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := compileRules(t, "rules:\n  - match: OLDHUB\n    expires: 2026-11-01 09:00\n    "+tt.rule+"\n")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rule := rs.rules[0]
+			for _, now := range []struct {
+				time string
+				want bool
+			}{
+				{"2026-11-01 08:59", true},
+				{"2026-11-01 09:01", false},
+			} {
+				at, err := time.ParseInLocation("2006-01-02 15:04", now.time, time.Local)
+				if err != nil {
+					t.Fatal(err)
+				}
+				timeNow = func() time.Time { return at }
+				if got := rule.matches("Unable to find path to server OLDHUB", ""); got != now.want {
+					t.Errorf("at %s, matches = %v, want %v", now.time, got, now.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRuleUnusableKeys(t *testing.T) {
+	tests := []struct {
+		rule    string
+		wantErr string // "" if the rule is fine
+	}{
+		{rule: "action: drop"},
+		{rule: "action: drop\n    priority: crit", wantErr: "drop rules can't have a priority"},
+		{rule: "action: drop\n    facility: auth", wantErr: "drop rules can't have a facility"},
+		{rule: "action: drop\n    rate_limit: 5/10m", wantErr: "drop rules can't have a rate_limit"},
+		{rule: "action: rewrite\n    replace: x"},
+		{rule: "action: rewrite\n    replace: x\n    priority: crit", wantErr: "rewrite rules can't have a priority"},
+		{rule: "action: rewrite\n    replace: x\n    rate_limit: 5/10m", wantErr: "rewrite rules can't have a rate_limit"},
+		{rule: "priority: crit\n    facility: auth\n    rate_limit: 5/10m"},
+		{rule: "action: drop\n    expires: soon", wantErr: `bad expiry time "soon"`},
+	}
+	for _, tt := range tests {
+		_, err := compileRules(t, "rules:\n  - match: OLDHUB\n    "+tt.rule+"\n")
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%q: unexpected error %v", tt.rule, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%q: error %v, want %q", tt.rule, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+// Whether to stamp each event with a sequence number and a unique ID, as
+// the fields seq and uuid, so that lost and reordered messages can be spotted
+// downstream.
+var sequenceIDs bool
+
+// Sequence number of the last event stamped, counting from 1 each run.
+var lastSequence uint64
+
+// stamp gives an event the next sequence number and a new unique ID, if
+// -sequence is set. Events which already have them, such as those being
+// replayed from a dead-letter file, keep their original ones.
+func stamp(ev *Event) {
+	if !sequenceIDs {
+		return
+	}
+	if _, ok := ev.Fields["seq"]; ok {
+		return
+	}
+	fields := make(map[string]string, len(ev.Fields)+2)
+	for k, v := range ev.Fields {
+		fields[k] = v
+	}
+	fields["seq"] = strconv.FormatUint(atomic.AddUint64(&lastSequence, 1), 10)
+	fields["uuid"] = newUUID()
+	ev.Fields = fields
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		// Can't happen on any system we run on
+		panic(err)
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Address the sidecar command serves its health checks on.
+var healthAddr = ":8080"
+
+// How long the file being followed can go without being checked before the
+// sidecar's liveness check fails, since something's stuck, probably an
+// output.
+const livenessTimeout = 30 * time.Second
+
+// runSidecar follows Domino's console log, or the file given, on a volume
+// shared with Domino's container, as tail does, while serving liveness and
+// readiness checks for Kubernetes. Unless outputs are given, events are
+// written to standard output as JSON lines, for the cluster's log collector.
+// It stops cleanly when the pod's terminated. It returns the exit status for
+// the program.
+func runSidecar(args []string) int {
+	path := defaultConsoleLog
+	switch len(args) {
+	case 0:
+	case 1:
+		path = args[0]
+	default:
+		fmt.Fprintln(os.Stderr, "sidecar: only one file can be followed")
+		return 2
+	}
+	if len(outputSpecs) == 0 {
+		outputSpecs = stringList{"jsonl"}
+	}
+	l, err := net.Listen("tcp", healthAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sidecar: can't serve health checks: %s\n", err)
+		return 1
+	}
+
+	// Unix nanoseconds of the last check of the file, and whether it was
+	// open then
+	var polled int64
+	var open int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if last := atomic.LoadInt64(&polled); last != 0 && time.Since(time.Unix(0, last)) > livenessTimeout {
+			http.Error(w, fmt.Sprintf("%s hasn't been checked since %s", path, time.Unix(0, last).Format(time.RFC3339)), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&open) == 0 {
+			http.Error(w, fmt.Sprintf("waiting for %s", path), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(l)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	stop := stopOnSignal()
+	return logInput(func(out Output) error {
+		t := &tailer{path: path}
+		t.polled = func(isOpen bool) {
+			atomic.StoreInt64(&polled, time.Now().UnixNano())
+			if isOpen {
+				atomic.StoreInt32(&open, 1)
+			} else {
+				atomic.StoreInt32(&open, 0)
+			}
+		}
+		err := t.follow(out, stop, io.SeekEnd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return err
+	})
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDs every SNMPv2 trap starts with: the sender's uptime, and the trap's
+// own OID.
+const (
+	snmpSysUpTime = "1.3.6.1.2.1.1.3.0"
+	snmpTrapOID   = "1.3.6.1.6.3.1.1.4.1.0"
+)
+
+// ASN.1 BER tags used in SNMP messages.
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berOID         = 0x06
+	berSequence    = 0x30
+	berTimeTicks   = 0x43
+	snmpTrapPDU    = 0xa7
+)
+
+// Message flags for SNMPv3.
+const (
+	snmpFlagAuth = 0x01
+	snmpFlagPriv = 0x02
+)
+
+func init() {
+	outputTypes["snmptrap"] = func(arg string) (Output, error) {
+		return openSNMPTrap(arg)
+	}
+}
+
+// snmpTrap sends an SNMP trap for each event at or above a severity, for
+// network management systems which work with traps.
+type snmpTrap struct {
+	conn     net.Conn
+	severity Priority
+	oid      string // of the trap, under which its variables are numbered
+	started  time.Time
+
+	// SNMPv2c
+	community string
+
+	// SNMPv3, if user is set. We're the authoritative engine for traps we
+	// send, so the keys are localized with our own engine ID.
+	user     string
+	engineID []byte
+	boots    int
+	authHash func() hash.Hash // nil for noAuthNoPriv
+	authKey  []byte
+	privKey  []byte // AES-128 key, nil without privacy
+
+	mu    sync.Mutex
+	msgID int32
+}
+
+// openSNMPTrap opens an output which sends SNMP traps to the manager at the
+// given address, such as udp://nms:162?oid=1.3.6.1.4.1.99999.1. Query
+// parameters give the trap's OID, which is required; the least severe
+// priority to send a trap for, crit by default; and either the community
+// for SNMPv2c, public by default, or for SNMPv3 the user, auth=MD5 or SHA
+// with authpass, priv=AES with privpass, and the engine ID in hex.
+func openSNMPTrap(addr string) (Output, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "udp" || u.Host == "" {
+		return nil, fmt.Errorf("SNMP trap address %q should be a URL, such as udp://nms:162", addr)
+	}
+	hostport := u.Host
+	if u.Port() == "" {
+		hostport = net.JoinHostPort(u.Hostname(), "162")
+	}
+	params := queryParams(u.RawQuery)
+	st := &snmpTrap{
+		oid:       params.Get("oid"),
+		severity:  LOG_CRIT,
+		community: params.Get("community"),
+		user:      params.Get("user"),
+		started:   time.Now(),
+	}
+	if _, err := appendBEROID(nil, st.oid); err != nil || st.oid == "" {
+		return nil, fmt.Errorf("SNMP trap needs an OID, such as oid=1.3.6.1.4.1.99999.1")
+	}
+	if s := params.Get("severity"); s != "" {
+		if st.severity, err = parsePriority(s); err != nil {
+			return nil, err
+		}
+	}
+	if st.community == "" {
+		st.community = "public"
+	}
+	if st.user != "" {
+		if err := st.setupUSM(params); err != nil {
+			return nil, err
+		}
+	}
+	if st.conn, err = net.Dial("udp", hostport); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// setupUSM sets up SNMPv3's user-based security from the query parameters.
+func (st *snmpTrap) setupUSM(params url.Values) error {
+	if e := params.Get("engine"); e != "" {
+		var err error
+		if st.engineID, err = hex.DecodeString(strings.TrimPrefix(e, "0x")); err != nil || len(st.engineID) < 5 || len(st.engineID) > 32 {
+			return fmt.Errorf("SNMP engine ID %q should be 5 to 32 bytes in hex", e)
+		}
+	} else {
+		// Net-SNMP's enterprise number, with the host name as text
+		st.engineID = append([]byte{0x80, 0x00, 0x1f, 0x88, 0x04}, hostname...)
+		if len(st.engineID) > 32 {
+			st.engineID = st.engineID[:32]
+		}
+	}
+	// The engine's boot count isn't kept anywhere, so the start time
+	// stands in for it, to make sure it goes up each time
+	st.boots = int(st.started.Unix() & 0x7fffffff)
+	switch strings.ToUpper(params.Get("auth")) {
+	case "":
+		if params.Get("priv") != "" {
+			return fmt.Errorf("SNMPv3 privacy needs authentication too")
+		}
+		return nil
+	case "MD5":
+		st.authHash = md5.New
+	case "SHA":
+		st.authHash = sha1.New
+	default:
+		return fmt.Errorf("SNMPv3 auth %q should be MD5 or SHA", params.Get("auth"))
+	}
+	authPass := params.Get("authpass")
+	if len(authPass) < 8 {
+		return fmt.Errorf("SNMPv3 authpass should be at least 8 characters")
+	}
+	st.authKey = snmpLocalizedKey(st.authHash, authPass, st.engineID)
+	switch strings.ToUpper(params.Get("priv")) {
+	case "":
+	case "AES":
+		privPass := params.Get("privpass")
+		if len(privPass) < 8 {
+			return fmt.Errorf("SNMPv3 privpass should be at least 8 characters")
+		}
+		st.privKey = snmpLocalizedKey(st.authHash, privPass, st.engineID)[:16]
+	default:
+		return fmt.Errorf("SNMPv3 priv %q should be AES", params.Get("priv"))
+	}
+	return nil
+}
+
+// snmpLocalizedKey turns a password into a key for an engine, as RFC 3414
+// describes: the password is repeated to make a megabyte, which is hashed,
+// and the hash is hashed again along with the engine ID.
+func snmpLocalizedKey(newHash func() hash.Hash, password string, engineID []byte) []byte {
+	h := newHash()
+	buf := make([]byte, 64)
+	for i := 0; i < 1048576; i += 64 {
+		for j := range buf {
+			buf[j] = password[(i+j)%len(password)]
+		}
+		h.Write(buf)
+	}
+	ku := h.Sum(nil)
+	h.Reset()
+	h.Write(ku)
+	h.Write(engineID)
+	h.Write(ku)
+	return h.Sum(nil)
+}
+
+// appendBERLength appends the length of a BER value.
+func appendBERLength(b []byte, n int) []byte {
+	switch {
+	case n < 0x80:
+		return append(b, byte(n))
+	case n <= 0xff:
+		return append(b, 0x81, byte(n))
+	case n <= 0xffff:
+		return append(b, 0x82, byte(n>>8), byte(n))
+	}
+	return append(b, 0x83, byte(n>>16), byte(n>>8), byte(n))
+}
+
+// appendBER appends a BER value, given its tag and contents.
+func appendBER(b []byte, tag byte, contents []byte) []byte {
+	b = append(b, tag)
+	b = appendBERLength(b, len(contents))
+	return append(b, contents...)
+}
+
+// appendBERInt appends an integer, with the given tag since SNMP has
+// several types of them.
+func appendBERInt(b []byte, tag byte, n int64) []byte {
+	var buf [9]byte
+	i := len(buf)
+	for {
+		i--
+		buf[i] = byte(n)
+		n >>= 8
+		// Stop once the rest is just the sign
+		if (n == 0 && buf[i]&0x80 == 0) || (n == -1 && buf[i]&0x80 != 0) {
+			break
+		}
+	}
+	return appendBER(b, tag, buf[i:])
+}
+
+// appendBEROID appends an object identifier, given in dotted form.
+func appendBEROID(b []byte, oid string) ([]byte, error) {
+	parts := strings.Split(strings.TrimPrefix(oid, "."), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("bad OID %q", oid)
+	}
+	arcs := make([]uint64, len(parts))
+	for i, p := range parts {
+		var err error
+		if arcs[i], err = strconv.ParseUint(p, 10, 32); err != nil {
+			return nil, fmt.Errorf("bad OID %q", oid)
+		}
+	}
+	if arcs[0] > 2 || (arcs[0] < 2 && arcs[1] >= 40) {
+		return nil, fmt.Errorf("bad OID %q", oid)
+	}
+	contents := appendBase128(nil, arcs[0]*40+arcs[1])
+	for _, arc := range arcs[2:] {
+		contents = appendBase128(contents, arc)
+	}
+	return appendBER(b, berOID, contents), nil
+}
+
+// appendBase128 appends an OID arc, seven bits to a byte, most significant
+// first, with the top bit set on all but the last.
+func appendBase128(b []byte, n uint64) []byte {
+	var buf [10]byte
+	i := len(buf) - 1
+	buf[i] = byte(n & 0x7f)
+	for n >>= 7; n > 0; n >>= 7 {
+		i--
+		buf[i] = byte(n&0x7f) | 0x80
+	}
+	return append(b, buf[i:]...)
+}
+
+// appendVarBind appends a variable binding: an OID and its value, already
+// encoded.
+func appendVarBind(b []byte, oid string, value []byte) []byte {
+	name, _ := appendBEROID(nil, oid)
+	return appendBER(b, berSequence, append(name, value...))
+}
+
+// trapPDU encodes an SNMPv2 trap for an event. After the usual uptime and
+// trap OID, the variables under the trap's OID are the message, the
+// severity as a number and as a name, the Domino task, and the host.
+func (st *snmpTrap) trapPDU(ev *Event, requestID int32) []byte {
+	trapOID, _ := appendBEROID(nil, st.oid)
+	uptime := appendBERInt(nil, berTimeTicks, int64(timeNow().Sub(st.started)/(10*time.Millisecond)))
+	vbs := appendVarBind(nil, snmpSysUpTime, uptime)
+	vbs = appendVarBind(vbs, snmpTrapOID, trapOID)
+	vbs = appendVarBind(vbs, st.oid+".1", appendBER(nil, berOctetString, []byte(ev.text())))
+	vbs = appendVarBind(vbs, st.oid+".2", appendBERInt(nil, berInteger, int64(ev.Priority)))
+	vbs = appendVarBind(vbs, st.oid+".3", appendBER(nil, berOctetString, []byte(priorityName(ev.Priority))))
+	vbs = appendVarBind(vbs, st.oid+".4", appendBER(nil, berOctetString, []byte(ev.Task)))
+	vbs = appendVarBind(vbs, st.oid+".5", appendBER(nil, berOctetString, []byte(ev.host())))
+	pdu := appendBERInt(nil, berInteger, int64(requestID))
+	pdu = appendBERInt(pdu, berInteger, 0) // error-status
+	pdu = appendBERInt(pdu, berInteger, 0) // error-index
+	pdu = appendBER(pdu, berSequence, vbs)
+	return appendBER(nil, snmpTrapPDU, pdu)
+}
+
+// message wraps a PDU in an SNMPv2c message, or an SNMPv3 one with the
+// user-based security model.
+func (st *snmpTrap) message(pdu []byte, msgID int32) ([]byte, error) {
+	if st.user == "" {
+		msg := appendBERInt(nil, berInteger, 1) // version 2c
+		msg = appendBER(msg, berOctetString, []byte(st.community))
+		msg = append(msg, pdu...)
+		return appendBER(nil, berSequence, msg), nil
+	}
+	engineTime := int64(timeNow().Sub(st.started) / time.Second)
+	var flags byte
+	authParams, privParams := []byte{}, []byte{}
+	if st.authHash != nil {
+		flags |= snmpFlagAuth
+		authParams = make([]byte, 12)
+	}
+	scoped := appendBER(nil, berOctetString, st.engineID) // contextEngineID
+	scoped = appendBER(scoped, berOctetString, nil)       // contextName
+	scoped = appendBER(nil, berSequence, append(scoped, pdu...))
+	data := scoped
+	if st.privKey != nil {
+		flags |= snmpFlagPriv
+		// RFC 3826: the IV is the engine's boots and time, then a salt
+		privParams = make([]byte, 8)
+		if _, err := rand.Read(privParams); err != nil {
+			return nil, err
+		}
+		iv := make([]byte, 0, 16)
+		iv = binary.BigEndian.AppendUint32(iv, uint32(st.boots))
+		iv = binary.BigEndian.AppendUint32(iv, uint32(engineTime))
+		iv = append(iv, privParams...)
+		block, err := aes.NewCipher(st.privKey)
+		if err != nil {
+			return nil, err
+		}
+		encrypted := make([]byte, len(scoped))
+		cipher.NewCFBEncrypter(block, iv).XORKeyStream(encrypted, scoped)
+		data = appendBER(nil, berOctetString, encrypted)
+	}
+
+	header := appendBERInt(nil, berInteger, int64(msgID))
+	header = appendBERInt(header, berInteger, 65507) // msgMaxSize
+	header = appendBER(header, berOctetString, []byte{flags})
+	header = appendBERInt(header, berInteger, 3) // user-based security model
+	usm := appendBER(nil, berOctetString, st.engineID)
+	usm = appendBERInt(usm, berInteger, int64(st.boots))
+	usm = appendBERInt(usm, berInteger, engineTime)
+	usm = appendBER(usm, berOctetString, []byte(st.user))
+	usm = appendBER(usm, berOctetString, authParams)
+	usm = appendBER(usm, berOctetString, privParams)
+
+	msg := appendBERInt(nil, berInteger, 3) // version 3
+	msg = appendBER(msg, berSequence, header)
+	msg = appendBER(msg, berOctetString, appendBER(nil, berSequence, usm))
+	msg = append(msg, data...)
+	msg = appendBER(nil, berSequence, msg)
+	if st.authHash != nil {
+		// The MAC is worked out with the authentication parameters zeroed,
+		// then put in their place
+		mac := hmac.New(st.authHash, st.authKey)
+		mac.Write(msg)
+		placeholder := append([]byte{berOctetString, 12}, authParams...)
+		i := bytes.Index(msg, placeholder)
+		copy(msg[i+2:], mac.Sum(nil)[:12])
+	}
+	return msg, nil
+}
+
+// Write sends a trap for an event, if it's severe enough.
+func (st *snmpTrap) Write(ev *Event) error {
+	// Lower numbers are more severe
+	if ev.Priority > st.severity {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.msgID++
+	msg, err := st.message(st.trapPDU(ev, st.msgID), st.msgID)
+	if err != nil {
+		return err
+	}
+	_, err = st.conn.Write(msg)
+	return err
+}
+
+func (st *snmpTrap) Close() error {
+	return st.conn.Close()
+}
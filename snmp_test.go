@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"hash"
+	"testing"
+	"time"
+)
+
+func TestAppendBER(t *testing.T) {
+	tests := []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"zero", appendBERInt(nil, berInteger, 0), "020100"},
+		{"127", appendBERInt(nil, berInteger, 127), "02017f"},
+		{"128", appendBERInt(nil, berInteger, 128), "02020080"},
+		{"256", appendBERInt(nil, berInteger, 256), "02020100"},
+		{"65507", appendBERInt(nil, berInteger, 65507), "020300ffe3"},
+		{"-1", appendBERInt(nil, berInteger, -1), "0201ff"},
+		{"-128", appendBERInt(nil, berInteger, -128), "020180"},
+		{"-129", appendBERInt(nil, berInteger, -129), "0202ff7f"},
+		{"time ticks", appendBERInt(nil, berTimeTicks, 1234), "430204d2"},
+		{"empty string", appendBER(nil, berOctetString, nil), "0400"},
+		{"long form length", appendBER(nil, berOctetString, make([]byte, 128))[:3], "048180"},
+		{"two byte length", appendBER(nil, berOctetString, make([]byte, 256))[:4], "04820100"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(tt.got); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAppendBEROID(t *testing.T) {
+	tests := []struct {
+		oid  string
+		want string // "" for an error
+	}{
+		{"1.3.6.1.2.1.1.3.0", "06082b06010201010300"},
+		{"1.3.6.1.6.3.1.1.4.1.0", "060a2b060106030101040100"},
+		{".1.3.6.1.4.1.311", "06072b060104018237"},
+		{"1.3.6.1.4.1.99999.1", "06092b06010401868d1f01"},
+		{"2.100.3", "0603813403"},
+		{"1.3.6.1.4.1.4294967295", "060a2b060104018fffffff7f"},
+		{"1", ""},
+		{"1.40", ""},
+		{"3.1", ""},
+		{"1.3.x", ""},
+		{"1.3.6.1.4.1.4294967296", ""},
+	}
+	for _, tt := range tests {
+		got, err := appendBEROID(nil, tt.oid)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("%s: got %x, want an error", tt.oid, got)
+		case tt.want != "" && err != nil:
+			t.Errorf("%s: %v", tt.oid, err)
+		case tt.want != "" && hex.EncodeToString(got) != tt.want:
+			t.Errorf("%s: got %x, want %s", tt.oid, got, tt.want)
+		}
+	}
+}
+
+// RFC 3414 A.3's examples of localizing the password "maplesyrup".
+func TestSNMPLocalizedKey(t *testing.T) {
+	engineID, _ := hex.DecodeString("000000000000000000000002")
+	tests := []struct {
+		name    string
+		newHash func() hash.Hash
+		want    string
+	}{
+		{"MD5", md5.New, "526f5eed9fcce26f8964c2930787d82b"},
+		{"SHA", sha1.New, "6695febc9288e36282235fc7151f128497b38f3f"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(snmpLocalizedKey(tt.newHash, "maplesyrup", engineID)); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// testTrap returns a trap output, without a connection, whose clock says
+// it started 12.34 seconds ago.
+func testTrap(t *testing.T) *snmpTrap {
+	t.Helper()
+	now := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	t.Cleanup(func() { timeNow = time.Now })
+	return &snmpTrap{oid: "1.3.6.1.4.1.99999.1", community: "public", started: now.Add(-12340 * time.Millisecond)}
+}
+
+var testTrapEvent = Event{Priority: LOG_CRIT, Task: "Server", Message: "PANIC", Host: "mail1"}
+
+// The trap PDU for testTrapEvent from testTrap, with request ID 1.
+const testTrapPDU = "a7819a02010102010002010030818e300e06082b06010201010300430204d23017060a2b06010603010104010006092b06010401868d1f01" +
+	"3013060a2b06010401868d1f0101040550414e4943300f060a2b06010401868d1f0102020102" +
+	"3012060a2b06010401868d1f01030404637269743014060a2b06010401868d1f01040406536572766572" +
+	"3013060a2b06010401868d1f010504056d61696c31"
+
+func TestSNMPv2cTrap(t *testing.T) {
+	st := testTrap(t)
+	msg, err := st.message(st.trapPDU(&testTrapEvent, 1), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "3081a802010104067075626c6963" + testTrapPDU
+	if got := hex.EncodeToString(msg); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestSNMPv3Trap(t *testing.T) {
+	st := testTrap(t)
+	st.user = "domino"
+	st.engineID, _ = hex.DecodeString("8000000001020304")
+	st.boots = 1700000000
+	msg, err := st.message(st.trapPDU(&testTrapEvent, 1), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "3081e2020103300e020101020300ffe30401000201030421301f0408800000000102030402046553f10002010c0406646f6d696e6f04000400" +
+		"3081a9040880000000010203040400" + testTrapPDU
+	if got := hex.EncodeToString(msg); got != want {
+		t.Errorf("noAuthNoPriv:\ngot  %s\nwant %s", got, want)
+	}
+
+	// With authentication, the MAC is of the message with the parameters
+	// zeroed
+	st.authHash = md5.New
+	st.authKey = snmpLocalizedKey(md5.New, "maplesyrup", st.engineID)
+	msg, err = st.message(st.trapPDU(&testTrapEvent, 1), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The USM parameters start after the version, the header, and the
+	// octet string and sequence they're wrapped in
+	usm := 3 + 3 + 16 + 2 + 2
+	usmWant := "04088000000001020304" + "02046553f100" + "02010c" + "0406646f6d696e6f" + "040c"
+	if got := hex.EncodeToString(msg[usm : usm+len(usmWant)/2]); got != usmWant {
+		t.Fatalf("authNoPriv USM parameters start %s, want %s", got, usmWant)
+	}
+	at := usm + len(usmWant)/2
+	zeroed := append([]byte{}, msg...)
+	copy(zeroed[at:at+12], make([]byte, 12))
+	mac := hmac.New(md5.New, st.authKey)
+	mac.Write(zeroed)
+	if got, want := msg[at:at+12], mac.Sum(nil)[:12]; !bytes.Equal(got, want) {
+		t.Errorf("authNoPriv MAC %x, want %x", got, want)
+	}
+	if msg[18] != snmpFlagAuth {
+		t.Errorf("authNoPriv flags %#x", msg[18])
+	}
+
+	// With privacy, the scoped PDU is encrypted with AES-128 in CFB mode,
+	// with the boots, time and salt as the IV
+	st.privKey = snmpLocalizedKey(md5.New, "maplesyrup", st.engineID)
+	msg, err = st.message(st.trapPDU(&testTrapEvent, 1), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg[18] != snmpFlagAuth|snmpFlagPriv {
+		t.Errorf("authPriv flags %#x", msg[18])
+	}
+	salt := msg[at+12+2 : at+12+2+8]
+	encrypted := msg[at+12+2+8:]
+	if hex.EncodeToString(encrypted[:3]) != "0481ac" {
+		t.Fatalf("encrypted scoped PDU starts %x", encrypted[:3])
+	}
+	encrypted = encrypted[3:]
+	iv := append([]byte{0x65, 0x53, 0xf1, 0x00, 0, 0, 0, 12}, salt...)
+	block, _ := aes.NewCipher(st.privKey)
+	scoped := make([]byte, len(encrypted))
+	cipher.NewCFBDecrypter(block, iv).XORKeyStream(scoped, encrypted)
+	if got, want := hex.EncodeToString(scoped), "3081a9040880000000010203040400"+testTrapPDU; got != want {
+		t.Errorf("authPriv decrypted scoped PDU\ngot  %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// spoolOutput saves events to a file on disk when an output fails, so
+// they're not lost, and delivers them in order once it's working again.
+// Once anything is spooled, later events are spooled behind it until the
+// spool has been delivered. The spool is kept across restarts, so events
+// still in it when we stop are delivered next time. How far delivery has
+// got is kept in a second file alongside, so that after a restart the events
+// already delivered aren't sent again.
+type spoolOutput struct {
+	Output
+	path     string
+	template *template.Template // the output's own, which isn't spooled
+
+	mu      sync.Mutex
+	f       *os.File // the spool, which events are appended to
+	offsetf *os.File // how much of the spool has been delivered
+	offset  int64
+	pending bool          // whether there are events in the spool
+	wake    chan struct{} // signalled when events are spooled
+	stop    chan struct{} // closed to make run return
+	done    chan struct{} // closed when run returns
+}
+
+// overflowSpooler is an output which queues events, and can be told to
+// refuse them when the queue is full, rather than dropping the oldest, so
+// that they can be spooled instead.
+type overflowSpooler interface {
+	spoolOverflow()
+}
+
+// newSpoolOutput wraps an output with a spool in the named file, which is
+// created if need be. Anything already in it is delivered first. The
+// output's template, if any, is given to events delivered from the spool.
+func newSpoolOutput(out Output, path string, tmpl *template.Template) (*spoolOutput, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	offsetf, err := os.OpenFile(path+".offset", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	so := &spoolOutput{
+		Output:   out,
+		path:     path,
+		template: tmpl,
+		f:        f,
+		offsetf:  offsetf,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if q, ok := out.(overflowSpooler); ok {
+		q.spoolOverflow()
+	}
+	var buf [8]byte
+	if n, _ := offsetf.ReadAt(buf[:], 0); n == len(buf) {
+		so.offset = int64(binary.BigEndian.Uint64(buf[:]))
+	}
+	if fi, err := f.Stat(); err == nil && fi.Size() > so.offset {
+		so.pending = true
+		so.wake <- struct{}{}
+	}
+	go so.run()
+	return so, nil
+}
+
+// Write delivers an event, or spools it if the output fails, or if there
+// are events spooled ahead of it.
+func (so *spoolOutput) Write(ev *Event) error {
+	so.mu.Lock()
+	defer so.mu.Unlock()
+	if !so.pending {
+		err := so.Output.Write(ev)
+		if err == nil {
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "%s, spooling to %s\n", err, so.path)
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if _, err := so.f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("error spooling to %s: %s", so.path, err)
+	}
+	so.pending = true
+	select {
+	case so.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// run delivers spooled events whenever there are some, retrying with
+// exponential backoff while the output is failing.
+func (so *spoolOutput) run() {
+	defer close(so.done)
+	var delay retryDelay
+	for {
+		select {
+		case <-so.wake:
+		case <-so.stop:
+			return
+		}
+		for {
+			err := so.drain()
+			if err == nil {
+				delay.reset()
+				break
+			}
+			wait := delay.wait()
+			fmt.Fprintf(os.Stderr, "%s, retrying spool %s in %s\n", err, so.path, wait.Round(time.Millisecond))
+			select {
+			case <-time.After(wait):
+			case <-so.stop:
+				return
+			}
+		}
+	}
+}
+
+// drain delivers the events in the spool, in order, until it's empty or
+// the output fails. Once it's empty, it's truncated.
+func (so *spoolOutput) drain() error {
+	so.mu.Lock()
+	defer so.mu.Unlock()
+	r := bufio.NewReader(io.NewSectionReader(so.f, so.offset, 1<<62))
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// Anything after the last newline is an event which was only
+			// partly written, probably when we were killed
+			break
+		}
+		if err != nil {
+			return err
+		}
+		ev := Event{Template: so.template}
+		if err := json.Unmarshal(line, &ev); err != nil {
+			fmt.Fprintf(os.Stderr, "skipping unreadable event in spool %s: %s\n", so.path, err)
+		} else if err := so.Output.Write(&ev); err != nil {
+			return err
+		}
+		if err := so.setOffset(so.offset + int64(len(line))); err != nil {
+			return err
+		}
+	}
+	if err := so.f.Truncate(0); err != nil {
+		return err
+	}
+	so.pending = false
+	return so.setOffset(0)
+}
+
+// setOffset records how much of the spool has been delivered.
+func (so *spoolOutput) setOffset(offset int64) error {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(offset))
+	if _, err := so.offsetf.WriteAt(buf[:], 0); err != nil {
+		return fmt.Errorf("error updating spool %s: %s", so.path, err)
+	}
+	so.offset = offset
+	return nil
+}
+
+// Close stops delivering spooled events, leaving any which are left for
+// next time, and closes the output.
+func (so *spoolOutput) Close() error {
+	close(so.stop)
+	<-so.done
+	so.mu.Lock()
+	defer so.mu.Unlock()
+	so.f.Close()
+	so.offsetf.Close()
+	return so.Output.Close()
+}
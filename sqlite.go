@@ -0,0 +1,167 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// How long events are kept in an SQLite archive by default, in days, and
+// how often old ones are pruned.
+const (
+	sqliteKeepDays   = 30
+	sqlitePruneEvery = 10 * time.Minute
+)
+
+// Layout of times in an SQLite archive, which is the one SQLite's own date
+// and time functions use, so that they can be compared with them.
+const sqliteTimeLayout = "2006-01-02 15:04:05.000"
+
+// Schema of an SQLite archive. Fields are stored as a JSON object, which
+// SQLite's JSON functions can pick apart.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS events (
+	id INTEGER PRIMARY KEY,
+	time TEXT NOT NULL,
+	host TEXT NOT NULL,
+	severity TEXT NOT NULL,
+	facility TEXT NOT NULL,
+	tag TEXT NOT NULL,
+	task TEXT,
+	thread TEXT,
+	domino_timestamp TEXT,
+	message TEXT NOT NULL,
+	rule TEXT,
+	fields TEXT
+);
+CREATE INDEX IF NOT EXISTS events_time ON events (time);
+`
+
+func init() {
+	outputTypes["sqlite"] = func(arg string) (Output, error) {
+		return openSQLite(arg)
+	}
+}
+
+// sqliteArchive stores events in a local SQLite database, deleting them
+// once they're older than a number of days.
+type sqliteArchive struct {
+	db        *sql.DB
+	keep      time.Duration
+	out       *batchOutput
+	lastPrune time.Time // only touched by send
+}
+
+// openSQLite opens an SQLite archive, given the database file, which is
+// created if need be, such as /var/lib/domino2syslog/console.db?days=7.
+// With days=0, events are kept forever.
+func openSQLite(arg string) (Output, error) {
+	filename, query, _ := strings.Cut(arg, "?")
+	if filename == "" {
+		return nil, fmt.Errorf("SQLite output needs a database file, such as sqlite:/var/lib/domino2syslog/console.db")
+	}
+	days := sqliteKeepDays
+	if s := queryParams(query).Get("days"); s != "" {
+		var err error
+		if days, err = strconv.Atoi(s); err != nil || days < 0 {
+			return nil, fmt.Errorf("SQLite days %q should be a number of days, or 0 to keep events forever", s)
+		}
+	}
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only has one writer at a time anyway, and the pragmas are
+	// per connection
+	db.SetMaxOpenConns(1)
+	// With write-ahead logging, the archive can be queried while it's
+	// being written to
+	for _, stmt := range []string{"PRAGMA journal_mode=WAL", "PRAGMA busy_timeout=5000", sqliteSchema} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("can't set up SQLite database %s: %s", filename, err)
+		}
+	}
+	sa := &sqliteArchive{db: db, keep: time.Duration(days) * 24 * time.Hour}
+	sa.out = newBatchOutput("SQLite database "+filename, sa.send)
+	return sa, nil
+}
+
+// send inserts a batch of events in one transaction, then prunes old
+// events if it's time to.
+func (sa *sqliteArchive) send(evs []*Event) error {
+	tx, err := sa.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO events (time, host, severity, facility, tag, task, thread, domino_timestamp, message, rule, fields)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, ev := range evs {
+		var fields interface{}
+		if len(ev.Fields) > 0 {
+			js, err := json.Marshal(ev.Fields)
+			if err != nil {
+				return permanentError{err}
+			}
+			fields = string(js)
+		}
+		_, err := stmt.Exec(ev.Time.UTC().Format(sqliteTimeLayout), ev.host(), priorityName(ev.Priority), facilityName(ev.Facility),
+			ev.Tag, nullString(ev.Task), nullString(ev.Thread), nullString(ev.Timestamp), ev.Message, nullString(ev.Rule), fields)
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	sa.prune()
+	return nil
+}
+
+// nullString returns nil for an empty string, so that it's stored as NULL.
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// prune deletes events older than the archive keeps, at most every
+// sqlitePruneEvery.
+func (sa *sqliteArchive) prune() {
+	if sa.keep == 0 || time.Since(sa.lastPrune) < sqlitePruneEvery {
+		return
+	}
+	sa.lastPrune = time.Now()
+	cutoff := time.Now().Add(-sa.keep).UTC().Format(sqliteTimeLayout)
+	if _, err := sa.db.Exec("DELETE FROM events WHERE time < ?", cutoff); err != nil {
+		fmt.Fprintf(os.Stderr, "error pruning SQLite database: %s\n", err)
+	}
+}
+
+func (sa *sqliteArchive) Write(ev *Event) error {
+	return sa.out.Write(ev)
+}
+
+func (sa *sqliteArchive) spoolOverflow() {
+	sa.out.spoolOverflow()
+}
+
+func (sa *sqliteArchive) Close() error {
+	err := sa.out.Close()
+	if cerr := sa.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+//go:build !windows
+
+package main
+
+import (
+	"log/syslog"
+	"sync"
+)
+
+// stdSyslog sends messages to the local syslog daemon using the standard
+// library's syslog package. A syslog.Writer always logs to the facility and
+// with the tag it was opened with, so a connection is opened for each
+// combination as needed.
+type stdSyslog struct {
+	mu      sync.Mutex
+	writers map[stdSyslogKey]*syslog.Writer
+}
+
+type stdSyslogKey struct {
+	fac Priority
+	tag string
+}
+
+// newStdSyslog returns a local syslog output using the standard library.
+// The connection for the default facility is opened straight away, so that
+// problems show up at startup.
+func newStdSyslog() (*senderOutput, error) {
+	s := &stdSyslog{writers: map[stdSyslogKey]*syslog.Writer{}}
+	if _, err := s.writer(facility, logTag); err != nil {
+		return nil, err
+	}
+	return &senderOutput{s}, nil
+}
+
+// writer returns the syslog writer for a facility and tag, opening it if
+// need be.
+func (s *stdSyslog) writer(fac Priority, tag string) (*syslog.Writer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := stdSyslogKey{fac, tag}
+	if w, ok := s.writers[key]; ok {
+		return w, nil
+	}
+	w, err := syslog.New(syslog.Priority(fac|LOG_INFO), tag)
+	if err != nil {
+		return nil, err
+	}
+	s.writers[key] = w
+	return w, nil
+}
+
+func (s *stdSyslog) send(ev *Event) error {
+	w, err := s.writer(ev.Facility, ev.Tag)
+	if err != nil {
+		return err
+	}
+	return writeSyslog(w, ev.Priority, ev.text())
+}
+
+func (s *stdSyslog) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for key, w := range s.writers {
+		if cerr := w.Close(); cerr != nil {
+			err = cerr
+		}
+		delete(s.writers, key)
+	}
+	return err
+}
+
+// writeSyslog writes a message to syslog at the given priority level.
+func writeSyslog(slog *syslog.Writer, pri Priority, msg string) error {
+	switch pri {
+	case LOG_EMERG:
+		return slog.Emerg(msg)
+	case LOG_ALERT:
+		return slog.Alert(msg)
+	case LOG_CRIT:
+		return slog.Crit(msg)
+	case LOG_ERR:
+		return slog.Err(msg)
+	case LOG_WARNING:
+		return slog.Warning(msg)
+	case LOG_NOTICE:
+		return slog.Notice(msg)
+	case LOG_DEBUG:
+		return slog.Debug(msg)
+	default:
+		return slog.Info(msg)
+	}
+}
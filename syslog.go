@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Address of a remote syslog server, such as udp://collector:514. If it's
+// empty, the local syslog daemon is used.
+var syslogAddr string
+
+// Default ports for each kind of syslog address.
+var syslogPorts = map[string]string{
+	"udp":  "514",
+	"tcp":  "514",
+	"tls":  "6514",
+	"relp": "2514",
+}
+
+// parseSyslogAddr splits a syslog address URL into network and address, as
+// needed by syslog.Dial. The network is "tls" for syslog over TLS, and
+// "relp" for RELP. The port defaults to 514, or 6514 for TLS and 2514 for
+// RELP. For a local syslog daemon's socket, the network is "unix" for a
+// stream socket or "unixgram" for a datagram one, and the address is the
+// socket's path.
+func parseSyslogAddr(addr string) (network, hostport string, err error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme == "unix" || u.Scheme == "unixgram" {
+		if u.Host != "" || u.Path == "" {
+			return "", "", fmt.Errorf("syslog socket address %q should have a path, such as %s:///dev/log", addr, u.Scheme)
+		}
+		return u.Scheme, u.Path, nil
+	}
+	port, ok := syslogPorts[u.Scheme]
+	if !ok {
+		return "", "", fmt.Errorf("syslog address %q should start udp://, tcp://, tls://, relp://, unix:// or unixgram://", addr)
+	}
+	if u.Host == "" {
+		return "", "", fmt.Errorf("syslog address %q has no host", addr)
+	}
+	hostport = u.Host
+	if u.Port() == "" {
+		hostport = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u.Scheme, hostport, nil
+}
+
+// sender is a connection to a server, such as a syslog server, which
+// sends events with whatever facility, severity and tag they need.
+type sender interface {
+	send(ev *Event) error
+	close() error
+}
+
+// senderOutput delivers events to a sender.
+type senderOutput struct {
+	sender sender
+}
+
+// How messages sent to a syslog server over TCP or TLS are framed, as RFC
+// 6587 describes: each preceded by its length, or followed by a newline.
+const (
+	framingOctet = "octet"
+	framingLF    = "lf"
+)
+
+// openSyslogOutput opens syslog, either the local syslog daemon if addr is
+// empty, or the remote one at addr, sending messages in the named format.
+// The standard library is only used for RFC 3164 messages to the local
+// daemon, since it stamps messages with the current time rather than the
+// event's; we send everything else ourselves. Over TCP or TLS, messages are
+// framed as given, or by default with newlines for TCP and with their length
+// for TLS, as RFC 5425 requires.
+func openSyslogOutput(addr, formatName, framing string) (*senderOutput, error) {
+	format, ok := syslogFormatters[formatName]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog format %q", formatName)
+	}
+	network, hostport := "", ""
+	if addr != "" {
+		var err error
+		network, hostport, err = parseSyslogAddr(addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if framing != "" {
+		if network != "tcp" && network != "tls" && network != "unix" {
+			return nil, fmt.Errorf("framing can only be chosen for syslog over TCP, TLS or a stream socket")
+		}
+		if framing != framingOctet && framing != framingLF {
+			return nil, fmt.Errorf("unknown syslog framing %q; should be octet or lf", framing)
+		}
+	}
+	switch {
+	case network == "tcp":
+		transport := plainTransport{octetCount: framing == framingOctet, terminator: '\n'}
+		return &senderOutput{newNetStream("syslog at "+hostport, hostport, nil, format, transport)}, nil
+	case network == "tls":
+		conf, err := syslogTLSConfig(hostport)
+		if err != nil {
+			return nil, err
+		}
+		transport := plainTransport{octetCount: framing != framingLF, terminator: '\n'}
+		return &senderOutput{newNetStream("syslog at "+hostport, hostport, conf, format, transport)}, nil
+	case network == "unix":
+		transport := plainTransport{octetCount: framing == framingOctet, terminator: '\n'}
+		dial := func() (net.Conn, error) { return net.DialTimeout("unix", hostport, 10*time.Second) }
+		return &senderOutput{startNetStream("syslog at "+hostport, dial, format, transport)}, nil
+	case network == "relp":
+		return &senderOutput{newNetStream("RELP syslog at "+hostport, hostport, nil, format, &relpTransport{})}, nil
+	case network != "" || formatName != "3164":
+		ds, err := newDgramSyslog(network, hostport, format)
+		if err != nil {
+			return nil, err
+		}
+		return &senderOutput{ds}, nil
+	}
+	if replaying {
+		return nil, fmt.Errorf("the local syslog daemon stamps messages with the time it gets them, so old messages can't be sent to it; give the address of a syslog server")
+	}
+	return newStdSyslog()
+}
+
+// spoolOverflow passes on a spool's request to refuse events when the
+// queue is full, if the sender has a queue.
+func (o *senderOutput) spoolOverflow() {
+	if q, ok := o.sender.(overflowSpooler); ok {
+		q.spoolOverflow()
+	}
+}
+
+func (o *senderOutput) Write(ev *Event) error {
+	return o.sender.send(ev)
+}
+
+func (o *senderOutput) Close() error {
+	return o.sender.close()
+}
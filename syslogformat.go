@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Format of messages sent to syslog: "3164" for the traditional BSD format
+// of RFC 3164, "5424" for RFC 5424, which has structured data, "leef" for
+// IBM QRadar, or "cef" for ArcSight and other SIEMs.
+var syslogFormat = "3164"
+
+// eventFormatter formats an event as a message, such as a syslog message,
+// without framing.
+type eventFormatter func(ev *Event) string
+
+// syslogFormatters maps the names of syslog message formats to their
+// formatters.
+var syslogFormatters = map[string]eventFormatter{
+	"3164": formatRFC3164,
+	"5424": formatRFC5424,
+}
+
+// Host name sent in syslog messages.
+var hostname, _ = os.Hostname()
+
+// formatRFC3164 formats an event in the traditional BSD syslog format, as
+// the standard library does for network connections.
+func formatRFC3164(ev *Event) string {
+	return fmt.Sprintf("<%d>%s %s %s[%d]: %s", ev.Facility|ev.Priority, ev.Time.Format("2006-01-02T15:04:05Z07:00"),
+		ev.host(), ev.Tag, os.Getpid(), strings.TrimRight(ev.text(), "\n"))
+}
+
+// UTF-8 byte order mark, which RFC 5424 uses to mark messages as UTF-8.
+const bom = "\ufeff"
+
+// formatRFC5424 formats an event as an RFC 5424 syslog message. The Domino
+// task is used as the MSGID, and Domino's thread ID, task and timestamp go
+// in the structured data along with the fields. Messages are marked as
+// UTF-8 with a BOM only if they aren't plain ASCII, since plenty of tools
+// show the BOM as junk, and ASCII reads the same either way.
+func formatRFC5424(ev *Event) string {
+	sd := make(map[string]string, len(ev.Fields)+3)
+	for k, v := range ev.Fields {
+		sd[k] = v
+	}
+	if ev.Thread != "" {
+		sd["thread"] = ev.Thread
+	}
+	if ev.Task != "" {
+		sd["task"] = ev.Task
+	}
+	if ev.Timestamp != "" {
+		sd["timestamp"] = ev.Timestamp
+	}
+	sdText := "-"
+	if len(sd) > 0 {
+		sdText = formatSD(sd)
+	}
+	msg := strings.TrimPrefix(ev.Message, bom)
+	if tmpl := ev.template(); tmpl != nil {
+		msg = strings.TrimPrefix(ev.applyTemplate(tmpl), bom)
+		if !isASCII(msg) {
+			msg = bom + msg
+		}
+	} else if ev.jsonFields() {
+		msg = formatCEE(msg, ev.Timestamp, ev.Thread, ev.Fields)
+	} else if !isASCII(msg) {
+		msg = bom + msg
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s", ev.Facility|ev.Priority,
+		ev.Time.Format("2006-01-02T15:04:05.000000Z07:00"), headerField(ev.host(), 255),
+		headerField(ev.Tag, 48), os.Getpid(), headerField(ev.Task, 32), sdText,
+		strings.TrimRight(msg, "\n"))
+}
+
+// headerField makes a value fit for an RFC 5424 header field, which must be
+// printable ASCII with no spaces, no longer than max, and "-" if empty.
+func headerField(s string, max int) string {
+	if s == "" {
+		return "-"
+	}
+	b := []byte(s)
+	for i, c := range b {
+		if c <= ' ' || c > '~' {
+			b[i] = '_'
+		}
+	}
+	if len(b) > max {
+		b = b[:max]
+	}
+	return string(b)
+}
+
+// isASCII reports whether a string is entirely ASCII.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
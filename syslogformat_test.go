@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSyslogTime = time.Date(2026, 10, 16, 9, 0, 0, 123456000, time.UTC)
+
+func TestFormatRFC3164(t *testing.T) {
+	pid := os.Getpid()
+	tests := []struct {
+		name string
+		ev   Event
+		want string
+	}{
+		{
+			name: "plain",
+			ev:   Event{Time: testSyslogTime, Host: "mail1", Facility: LOG_DAEMON, Priority: LOG_ERR, Tag: "domino", Message: "Router: Unable to send mail"},
+			want: fmt.Sprintf("<27>2026-10-16T09:00:00Z mail1 domino[%d]: Router: Unable to send mail", pid),
+		},
+		{
+			name: "old, with thread and fields",
+			ev: Event{Time: testSyslogTime.AddDate(-6, 0, 0), Host: "mail1", Facility: LOG_LOCAL3, Priority: LOG_INFO, Tag: "domino",
+				Message: "old message", Timestamp: "10/16/2020 09:00:00 AM", Thread: "0A1C:0002-0B3C",
+				Fields: map[string]string{"user": `CN=Joe "JB" Bloggs/O=Example]`}},
+			want: fmt.Sprintf(`<158>2020-10-16T09:00:00Z mail1 domino[%d]: old message (@ 10/16/2020 09:00:00 AM) [0A1C:0002-0B3C] [domino@32473 user="CN=Joe \"JB\" Bloggs/O=Example\]"]`, pid),
+		},
+		{
+			name: "trailing newline",
+			ev:   Event{Time: testSyslogTime, Host: "mail1", Facility: LOG_USER, Priority: LOG_NOTICE, Tag: "domino", Message: "two\nlines\n"},
+			want: fmt.Sprintf("<13>2026-10-16T09:00:00Z mail1 domino[%d]: two\nlines", pid),
+		},
+	}
+	for _, tt := range tests {
+		if got := formatRFC3164(&tt.ev); got != tt.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFormatRFC5424(t *testing.T) {
+	pid := os.Getpid()
+	tests := []struct {
+		name string
+		ev   Event
+		want string
+	}{
+		{
+			name: "no structured data",
+			ev:   Event{Time: testSyslogTime, Host: "mail1", Facility: LOG_DAEMON, Priority: LOG_ERR, Tag: "domino", Message: "Unable to send mail"},
+			want: fmt.Sprintf("<27>1 2026-10-16T09:00:00.123456Z mail1 domino %d - - Unable to send mail", pid),
+		},
+		{
+			name: "structured data",
+			ev: Event{Time: testSyslogTime, Host: "mail1", Facility: LOG_AUTH, Priority: LOG_WARNING, Tag: "domino", Task: "HTTP Server",
+				Thread: "0A1C:0002-0B3C", Timestamp: "10/16/2026 09:00:00 AM", Message: "HTTP Server: denied",
+				Fields: map[string]string{"user": `CN=Joe "JB" Bloggs/O=Example\]`}},
+			want: fmt.Sprintf(`<36>1 2026-10-16T09:00:00.123456Z mail1 domino %d HTTP_Server [domino@32473 task="HTTP Server" thread="0A1C:0002-0B3C" timestamp="10/16/2026 09:00:00 AM" user="CN=Joe \"JB\" Bloggs/O=Example\\\]"] HTTP Server: denied`, pid),
+		},
+		{
+			name: "not ASCII",
+			ev:   Event{Time: testSyslogTime.In(time.FixedZone("", 2*60*60)), Host: "mail1", Facility: LOG_USER, Priority: LOG_INFO, Tag: "domino", Message: "Café"},
+			want: fmt.Sprintf("<14>1 2026-10-16T11:00:00.123456+02:00 mail1 domino %d - - \ufeffCafé", pid),
+		},
+		{
+			name: "BOM already",
+			ev:   Event{Time: testSyslogTime, Host: "mail1", Facility: LOG_USER, Priority: LOG_INFO, Tag: "domino", Message: "\ufeffServer started"},
+			want: fmt.Sprintf("<14>1 2026-10-16T09:00:00.123456Z mail1 domino %d - - Server started", pid),
+		},
+		{
+			name: "header fields",
+			ev: Event{Time: testSyslogTime, Host: "mail 1", Facility: LOG_USER, Priority: LOG_INFO, Tag: strings.Repeat("t", 50),
+				Task: "Agent Manager élan", Message: "m"},
+			want: fmt.Sprintf(`<14>1 2026-10-16T09:00:00.123456Z mail_1 %s %d Agent_Manager___lan [domino@32473 task="Agent Manager élan"] m`, strings.Repeat("t", 48), pid),
+		},
+	}
+	for _, tt := range tests {
+		if got := formatRFC5424(&tt.ev); got != tt.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPlainTransportFraming(t *testing.T) {
+	msgs := [][]byte{[]byte("<14>one"), []byte("<14>two\nlines")}
+	tests := []struct {
+		name      string
+		transport plainTransport
+		want      string
+	}{
+		{"octet counting", plainTransport{octetCount: true, terminator: '\n'}, "7 <14>one13 <14>two\nlines"},
+		{"newlines", plainTransport{terminator: '\n'}, "<14>one\n<14>two#012lines\n"},
+		{"NUL", plainTransport{terminator: 0}, "<14>one\x00<14>two\nlines\x00"},
+	}
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		got := make(chan string)
+		go func() {
+			b, _ := io.ReadAll(server)
+			got <- string(b)
+		}()
+		if err := tt.transport.write(client, msgs); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		client.Close()
+		if g := <-got; g != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, g, tt.want)
+		}
+	}
+}
+
+func TestSyslogUDPKeepsTime(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	out, err := openSyslogOutput("udp://"+conn.LocalAddr().String(), "3164", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	ev := Event{Time: testSyslogTime.AddDate(-6, 0, 0), Host: "mail1", Facility: LOG_DAEMON, Priority: LOG_ERR, Tag: "domino", Message: "old message"}
+	if err := out.Write(&ev); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("<27>2020-10-16T09:00:00Z mail1 domino[%d]: old message", os.Getpid()); string(buf[:n]) != want {
+		t.Errorf("got %q, want %q", buf[:n], want)
+	}
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Number of messages which can be queued for a network syslog server. This
+// is what stops messages being lost while the server is unavailable.
+var syslogBuffer = 10000
+
+// How long to wait for queued messages to be sent when closing, and for
+// a write to a network syslog server to complete.
+var drainTimeout = 5 * time.Second
+
+// Whether sending to an output whose queue is full waits for room, rather
+// than dropping the oldest message, as when replaying old messages, which
+// are read much faster than they can be sent. Closing the output then waits
+// for everything queued to be sent, for as long as it's being sent.
+var queueWait bool
+
+// How long to wait for room in a full queue when queueWait is set, and how
+// long closing waits for a queue which isn't getting any shorter.
+var queueWaitTimeout = time.Minute
+
+// waitToQueue queues an event once there's room, giving up if closing is
+// closed or there's no room for queueWaitTimeout.
+func waitToQueue(queue chan<- *Event, ev *Event, closing <-chan struct{}, name string) error {
+	timer := time.NewTimer(queueWaitTimeout)
+	defer timer.Stop()
+	select {
+	case queue <- ev:
+		return nil
+	case <-closing:
+		return fmt.Errorf("output to %s closed while waiting for room in its queue", name)
+	case <-timer.C:
+		return fmt.Errorf("queue for %s has been full for %s", name, queueWaitTimeout)
+	}
+}
+
+// waitDrained waits for done to be closed once an output's queue has been
+// closed, and reports whether it was. It gives up after drainTimeout, or if
+// queueWait is set, once the queue has stopped getting shorter for
+// queueWaitTimeout.
+func waitDrained(queue chan *Event, done <-chan struct{}) bool {
+	if !queueWait {
+		select {
+		case <-done:
+			return true
+		case <-time.After(drainTimeout):
+			return false
+		}
+	}
+	ticker := time.NewTicker(queueWaitTimeout)
+	defer ticker.Stop()
+	left := len(queue)
+	for {
+		select {
+		case <-done:
+			return true
+		case <-ticker.C:
+			if len(queue) >= left {
+				return false
+			}
+			left = len(queue)
+		}
+	}
+}
+
+const writeTimeout = 30 * time.Second
+
+// Settings for syslog over TLS: PEM files for the certificate authorities
+// to trust and the client certificate and key to present, and the server
+// name to verify, if it's not the host name in syslogAddr.
+var (
+	syslogCA         string
+	syslogCert       string
+	syslogKey        string
+	syslogServerName string
+)
+
+// syslogTLSConfig builds the TLS configuration for connecting to the syslog
+// server at hostport.
+func syslogTLSConfig(hostport string) (*tls.Config, error) {
+	return tlsSettings{syslogCA, syslogCert, syslogKey, syslogServerName}.config(hostport)
+}
+
+// tlsSettings are the settings for a TLS connection: PEM files for the
+// certificate authorities to trust and the client certificate and key to
+// present, and the server name to verify, if it's not the host name.
+type tlsSettings struct {
+	ca, cert, key string
+	serverName    string
+}
+
+// config builds the TLS configuration for connecting to the server at
+// hostport. The server's certificate is always verified, against the
+// system's certificate authorities unless ca is set.
+func (ts tlsSettings) config(hostport string) (*tls.Config, error) {
+	conf := &tls.Config{ServerName: ts.serverName, MinVersion: tls.VersionTLS12}
+	if conf.ServerName == "" {
+		host, _, err := net.SplitHostPort(hostport)
+		if err != nil {
+			return nil, err
+		}
+		conf.ServerName = host
+	}
+	if ts.ca != "" {
+		pem, err := os.ReadFile(ts.ca)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", ts.ca)
+		}
+	}
+	if (ts.cert == "") != (ts.key == "") {
+		return nil, fmt.Errorf("a client certificate needs both a certificate and a key file")
+	}
+	if ts.cert != "" {
+		cert, err := tls.LoadX509KeyPair(ts.cert, ts.key)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// streamTransport is the protocol spoken over a stream connection, such as
+// to a syslog server.
+type streamTransport interface {
+	// open starts a session on a new connection.
+	open(conn net.Conn) error
+	// write sends a batch of messages, returning once the server has
+	// them, as far as the protocol can tell.
+	write(conn net.Conn, msgs [][]byte) error
+	// close ends a session before the connection is closed.
+	close(conn net.Conn)
+}
+
+// Most messages sent to a stream syslog server in one go.
+const syslogBatch = 128
+
+// netStream sends messages to a server over a stream connection, such as a
+// syslog server over TCP, reconnecting with exponential backoff whenever the
+// connection fails, and giving up on a batch of messages after
+// retryAttempts, if that's set. Messages are queued while the server is
+// unavailable, up to syslogBuffer of them; beyond that the oldest are
+// dropped, and the number dropped is reported once the connection is back,
+// unless the output is spooled, in which case new messages are refused. A
+// batch of messages which fails is sent again in full, so after a failure
+// the server may see some twice.
+type netStream struct {
+	name      string // what we're connected to, for messages
+	dial      func() (net.Conn, error)
+	format    eventFormatter
+	transport streamTransport
+
+	mu      sync.Mutex
+	closed  bool
+	refuse  bool // whether to refuse messages when the queue is full
+	queue   chan *Event
+	closing chan struct{}  // closed to stop sends waiting for room
+	waiting sync.WaitGroup // sends waiting for room, without holding mu
+	stop    chan struct{}  // closed to make run give up
+	done    chan struct{}  // closed when run returns
+	dropped uint64
+}
+
+// newNetStream starts sending messages to the server at addr using
+// transport, over TCP, or over TLS if conf isn't nil.
+func newNetStream(name, addr string, conf *tls.Config, format eventFormatter, transport streamTransport) *netStream {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	dial := func() (net.Conn, error) { return dialer.Dial("tcp", addr) }
+	if conf != nil {
+		dial = func() (net.Conn, error) { return tls.DialWithDialer(dialer, "tcp", addr, conf) }
+	}
+	return startNetStream(name, dial, format, transport)
+}
+
+// startNetStream starts sending messages using transport over connections
+// made with dial.
+func startNetStream(name string, dial func() (net.Conn, error), format eventFormatter, transport streamTransport) *netStream {
+	ns := &netStream{
+		name:      name,
+		dial:      dial,
+		format:    format,
+		transport: transport,
+		queue:     make(chan *Event, syslogBuffer),
+		closing:   make(chan struct{}),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go ns.run()
+	return ns
+}
+
+func (ns *netStream) send(ev *Event) error {
+	ns.mu.Lock()
+	if queueWait && !ns.closed {
+		// Wait without the lock, so as not to hold up close, which
+		// doesn't close the queue until we're done
+		ns.waiting.Add(1)
+		ns.mu.Unlock()
+		defer ns.waiting.Done()
+		return waitToQueue(ns.queue, ev, ns.closing, ns.name)
+	}
+	defer ns.mu.Unlock()
+	if ns.closed {
+		return fmt.Errorf("connection to %s is closed", ns.name)
+	}
+	for {
+		select {
+		case ns.queue <- ev:
+			return nil
+		default:
+			if ns.refuse {
+				return fmt.Errorf("queue for %s is full", ns.name)
+			}
+			// Full, so make room by dropping the oldest message
+			select {
+			case <-ns.queue:
+				atomic.AddUint64(&ns.dropped, 1)
+			default:
+			}
+		}
+	}
+}
+
+// spoolOverflow makes the stream refuse messages when its queue is full,
+// for a spool to keep, rather than dropping the oldest.
+func (ns *netStream) spoolOverflow() {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	ns.refuse = true
+}
+
+// run sends queued messages until the queue is closed, connecting and
+// reconnecting as necessary.
+func (ns *netStream) run() {
+	defer close(ns.done)
+	var conn net.Conn
+	var delay retryDelay
+	for batch := ns.next(); batch != nil; batch = ns.next() {
+		for attempt := 1; ; attempt++ {
+			var err error
+			if conn == nil {
+				if conn, err = ns.connect(); err != nil {
+					err = fmt.Errorf("error connecting to %s: %s", ns.name, err)
+				} else if n := atomic.SwapUint64(&ns.dropped, 0); n > 0 {
+					batch = append([]*Event{newEvent(LOG_WARNING,
+						fmt.Sprintf("dropped %d messages while %s was unavailable", n, ns.name))}, batch...)
+				}
+			}
+			if err == nil {
+				msgs := make([][]byte, len(batch))
+				for i, ev := range batch {
+					msgs[i] = []byte(ns.format(ev))
+				}
+				conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+				if err = ns.transport.write(conn, msgs); err == nil {
+					delay.reset()
+					break
+				}
+				err = fmt.Errorf("error writing to %s: %s", ns.name, err)
+				conn.Close()
+				conn = nil
+			}
+			if retryAttempts > 0 && attempt >= retryAttempts {
+				fmt.Fprintf(os.Stderr, "%s, giving up on %d messages after %d attempts\n", err, len(batch), attempt)
+				countFailed(ns.name, len(batch))
+				deadLetter(ns.name, batch, err)
+				delay.reset()
+				break
+			}
+			wait := delay.wait()
+			fmt.Fprintf(os.Stderr, "%s, retrying in %s\n", err, wait.Round(time.Millisecond))
+			countRetry(ns.name)
+			select {
+			case <-time.After(wait):
+			case <-ns.stop:
+				return
+			}
+		}
+	}
+	if conn != nil {
+		conn.SetDeadline(time.Now().Add(writeTimeout))
+		ns.transport.close(conn)
+		conn.Close()
+	}
+}
+
+// next waits for a message to send, then returns it along with any others
+// queued up to syslogBatch. It returns nil once the queue is closed and
+// empty.
+func (ns *netStream) next() []*Event {
+	ev, ok := <-ns.queue
+	if !ok {
+		return nil
+	}
+	batch := []*Event{ev}
+	for len(batch) < syslogBatch {
+		select {
+		case ev, ok := <-ns.queue:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, ev)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// connect connects to the server and opens a session.
+func (ns *netStream) connect() (net.Conn, error) {
+	conn, err := ns.dial()
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(writeTimeout))
+	if err := ns.transport.open(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// Transports which wait for replies set their own read deadlines
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// close sends any queued messages, giving up as waitDrained says.
+func (ns *netStream) close() error {
+	ns.mu.Lock()
+	if ns.closed {
+		ns.mu.Unlock()
+		return nil
+	}
+	ns.closed = true
+	close(ns.closing)
+	ns.mu.Unlock()
+	ns.waiting.Wait()
+	close(ns.queue)
+	if waitDrained(ns.queue, ns.done) {
+		return nil
+	}
+	close(ns.stop)
+	<-ns.done
+	return fmt.Errorf("gave up sending %d queued messages to %s", len(ns.queue)+1, ns.name)
+}
+
+// plainTransport sends messages over a plain stream with no replies, as
+// RFC 6587 describes for syslog. Messages are framed with their length if
+// octetCount is set, as RFC 5425 requires for TLS; otherwise each is ended
+// with the terminator, normally a newline.
+type plainTransport struct {
+	octetCount bool
+	terminator byte
+}
+
+func (pt plainTransport) open(conn net.Conn) error {
+	return nil
+}
+
+func (pt plainTransport) write(conn net.Conn, msgs [][]byte) error {
+	var buf []byte
+	for _, msg := range msgs {
+		if pt.octetCount {
+			buf = strconv.AppendInt(buf, int64(len(msg)), 10)
+			buf = append(buf, ' ')
+			buf = append(buf, msg...)
+		} else {
+			// Messages of several lines would be split up, so the
+			// terminator is escaped in them as rsyslog does
+			escaped := []byte(fmt.Sprintf("#%03o", pt.terminator))
+			buf = append(buf, bytes.ReplaceAll(msg, []byte{pt.terminator}, escaped)...)
+			buf = append(buf, pt.terminator)
+		}
+	}
+	_, err := conn.Write(buf)
+	return err
+}
+
+func (pt plainTransport) close(conn net.Conn) {}
+
+// Where the local syslog daemon might be listening, as the standard library
+// looks for it.
+var localSyslogPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// dgramSyslog sends messages to a syslog server over UDP, or to the local
+// syslog daemon's socket if network is empty, one message per datagram.
+type dgramSyslog struct {
+	network, addr string
+	format        eventFormatter
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// newDgramSyslog connects to a syslog server over UDP, or to the local
+// syslog daemon.
+func newDgramSyslog(network, addr string, format eventFormatter) (*dgramSyslog, error) {
+	ds := &dgramSyslog{network: network, addr: addr, format: format}
+	if err := ds.connect(); err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+func (ds *dgramSyslog) connect() error {
+	var err error
+	if ds.network != "" {
+		ds.conn, err = net.Dial(ds.network, ds.addr)
+		return err
+	}
+	for _, path := range localSyslogPaths {
+		if ds.conn, err = net.Dial("unixgram", path); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("can't connect to local syslog: %s", err)
+}
+
+func (ds *dgramSyslog) send(ev *Event) error {
+	msg := []byte(ds.format(ev))
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.conn != nil {
+		if _, err := ds.conn.Write(msg); err == nil {
+			return nil
+		}
+		ds.conn.Close()
+		ds.conn = nil
+	}
+	// The local daemon may have been restarted, so try again
+	if err := ds.connect(); err != nil {
+		return err
+	}
+	_, err := ds.conn.Write(msg)
+	return err
+}
+
+func (ds *dgramSyslog) close() error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.conn == nil {
+		return nil
+	}
+	err := ds.conn.Close()
+	ds.conn = nil
+	return err
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// How often to check a file being tailed for more output.
+const tailInterval = 250 * time.Millisecond
+
+// How many of the last bytes read from a file being tailed are kept, to
+// recognize the copy made of it when it's rotated.
+const tailRecent = 256
+
+// tailConsoleLog follows Domino's console log, or the file given, logging
+// lines as they're added, until we're interrupted or terminated. It returns
+// the exit status for the program.
+func tailConsoleLog(args []string) int {
+	path := defaultConsoleLog
+	switch len(args) {
+	case 0:
+	case 1:
+		path = args[0]
+	default:
+		fmt.Fprintln(os.Stderr, "tail: only one file can be followed")
+		return 2
+	}
+	stop := stopOnSignal()
+	return logInput(func(out Output) error {
+		err := tailFile(path, nil, out, stop)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return err
+	})
+}
+
+// tailer follows a file as it's written to, as tail -F does: if it's
+// truncated, it's read again from the start, and if it's replaced by a new
+// file, the rest of the old one is read and then the new one from the start.
+// Domino rotates its console log either by renaming it, in which case the
+// rest of it is read before the new file, or by copying it to a dated file,
+// such as console_2026_10_16@10_00_00.log, and truncating it, in which case
+// the rest is read from the copy.
+type tailer struct {
+	path    string
+	src     *source
+	f       *os.File // nil until the file exists
+	fi      os.FileInfo
+	r       *bufio.Reader
+	offset  int64  // how much of the file has been read
+	partial []byte // the start of a line which hasn't been finished yet
+	recent  []byte // the last bytes read, up to tailRecent of them
+	utf16   bool   // whether the file is UTF-16LE, as on Windows
+	// Whether the rest of a line which was too long is being skipped
+	discarding bool
+	// Whether the last byte read ended a line with a carriage return, so
+	// a newline straight after it is part of the same line ending
+	afterCR bool
+
+	checkpoint string // file to save how far we've got to, if any
+	dirty      bool   // whether anything's been read since it was saved
+	warned     bool   // whether we've complained it can't be saved
+
+	// Stop following the file when it's removed, rather than waiting for
+	// it to be created again
+	untilGone bool
+	// What to do with each line, if not process it as Domino output
+	handle func(line []byte, src *source, out Output)
+	// Called each time the file's been read, with whether it exists
+	polled func(open bool)
+}
+
+// errGone is returned by check when a file which is only followed until
+// it's removed has been.
+var errGone = errors.New("file removed")
+
+// tailFile logs lines as they're added to a file, until stop is closed.
+// Lines already in the file are skipped, unless it doesn't exist yet, in
+// which case it's read from the start once it does, or there's a checkpoint
+// for it in the -tail-state directory, in which case it's read from where
+// the checkpoint says we'd got to.
+func tailFile(path string, src *source, out Output, stop <-chan struct{}) error {
+	t := &tailer{path: path, src: src}
+	return t.follow(out, stop, io.SeekEnd)
+}
+
+// follow logs lines as they're added to the file, until stop is closed,
+// starting from its start or end, unless there's a checkpoint for it.
+func (t *tailer) follow(out Output, stop <-chan struct{}, whence int) error {
+	resumed, err := t.resume(out)
+	if err != nil {
+		return err
+	}
+	if !resumed {
+		err := t.open(whence)
+		if os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "waiting for %s to be created\n", t.path)
+		} else if err != nil {
+			return err
+		}
+	}
+	defer t.close()
+	ticker := time.NewTicker(tailInterval)
+	defer ticker.Stop()
+	for {
+		if err := t.read(out); err != nil {
+			return err
+		}
+		t.save()
+		if t.polled != nil {
+			t.polled(t.f != nil)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return nil
+		}
+		if err := t.check(out); err == errGone {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// open opens the file, seeking to the start or the end of it.
+func (t *tailer) open(whence int) error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	offset, err := f.Seek(0, whence)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	t.f, t.fi, t.offset = f, fi, offset
+	t.r = bufio.NewReader(f)
+	t.utf16 = isUTF16(f) || wideEncoding
+	t.dirty = true
+	return nil
+}
+
+// close closes the file, if it's open.
+func (t *tailer) close() {
+	if t.f != nil {
+		t.f.Close()
+		t.f = nil
+	}
+	t.r = nil
+}
+
+// read logs the complete lines which have been added to the file since it
+// was last read, keeping any partial line at the end until it's finished.
+func (t *tailer) read(out Output) error {
+	if t.r == nil {
+		return nil
+	}
+	if t.offset == 0 && t.f != nil {
+		// It may have been empty when it was opened
+		t.utf16 = isUTF16(t.f) || wideEncoding
+	}
+	for {
+		var chunk []byte
+		var err error
+		if t.splitNewline() {
+			// The other half of the newline
+			var b byte
+			if b, err = t.r.ReadByte(); err == nil {
+				chunk = []byte{b}
+			}
+		} else {
+			chunk, err = t.r.ReadSlice('\n')
+		}
+		if len(chunk) > 0 {
+			t.dirty = true
+		}
+		t.offset += int64(len(chunk))
+		t.recent = append(t.recent, chunk...)
+		if n := len(t.recent) - tailRecent; n > 0 {
+			t.recent = append(t.recent[:0], t.recent[n:]...)
+		}
+		if t.afterCR && len(chunk) > 0 {
+			chunk = bytes.TrimPrefix(chunk, []byte{'\n'})
+			t.afterCR = false
+		}
+		t.partial = append(t.partial, chunk...)
+		if !t.utf16 && bytes.IndexByte(chunk, '\r') >= 0 {
+			t.flushCR(out)
+		}
+		if t.tooLong() {
+			t.cut(out)
+		}
+		switch err {
+		case nil:
+			if t.lineEnded() {
+				t.flush(out)
+			}
+		case bufio.ErrBufferFull:
+		case io.EOF:
+			return nil
+		default:
+			return fmt.Errorf("error reading %s: %s", t.path, err)
+		}
+	}
+}
+
+// lineEnded reports whether what's been read so far ends with a newline. In
+// UTF-16LE, that's a newline byte followed by a zero at an even offset from
+// the start of the line, since a newline byte could be half of some other
+// character.
+func (t *tailer) lineEnded() bool {
+	n := len(t.partial)
+	if !t.utf16 {
+		return n > 0 && t.partial[n-1] == '\n'
+	}
+	return n >= 2 && n%2 == 0 && t.partial[n-2] == '\n' && t.partial[n-1] == 0
+}
+
+// splitNewline reports whether the last byte read from a UTF-16LE file is
+// the first half of a newline, so the next byte read decides whether the line
+// has ended.
+func (t *tailer) splitNewline() bool {
+	n := len(t.partial)
+	return t.utf16 && n%2 == 1 && t.partial[n-1] == '\n'
+}
+
+// tooLong reports whether the line read so far is unfinished, and already
+// longer than -max-line, allowing two bytes a character in UTF-16LE.
+func (t *tailer) tooLong() bool {
+	limit := maxLineSize
+	if t.utf16 {
+		limit *= 2
+	}
+	return len(t.partial) > limit && !t.lineEnded()
+}
+
+// cut logs the start of a line which is too long, cut short, and skips the
+// rest of it.
+func (t *tailer) cut(out Output) {
+	var odd []byte
+	if t.utf16 && len(t.partial)%2 == 1 {
+		// Keep what's left in step with the characters
+		odd = []byte{t.partial[len(t.partial)-1]}
+	}
+	t.flush(out)
+	t.partial = append(t.partial, odd...)
+	t.discarding = true
+}
+
+// flush logs the line read so far, if there is one, cutting it short if it's
+// too long.
+func (t *tailer) flush(out Output) {
+	if len(t.partial) == 0 {
+		return
+	}
+	if t.discarding {
+		// The end of a line which was too long
+		t.partial, t.discarding = t.partial[:0], false
+		return
+	}
+	line := t.partial
+	if t.utf16 {
+		line = fromUTF16(line)
+	} else {
+		line = bytes.TrimSuffix(line, []byte{'\n'})
+	}
+	for _, line := range splitCR(line) {
+		lineOut := out
+		if len(line) > maxLineSize {
+			line, lineOut = cutLine(line), truncatedOutput{out}
+		}
+		if t.handle != nil {
+			t.handle(line, t.src, lineOut)
+		} else {
+			process(line, t.src, lineOut)
+		}
+	}
+	t.partial = t.partial[:0]
+}
+
+// flushCR logs the lines read so far which have been ended by carriage
+// returns, without waiting for a newline, which may never come if the file
+// was written with old Mac line endings.
+func (t *tailer) flushCR(out Output) {
+	if t.discarding {
+		// The end of a line which was too long
+		i := bytes.IndexByte(t.partial, '\r')
+		if i < 0 {
+			return
+		}
+		end := crEnd(t.partial, i)
+		t.afterCR = end == i+1 && end == len(t.partial)
+		t.partial = append(t.partial[:0], t.partial[end:]...)
+		t.discarding = false
+	}
+	i := bytes.LastIndexByte(t.partial, '\r')
+	if i < 0 {
+		return
+	}
+	end := crEnd(t.partial, i)
+	rest := append([]byte{}, t.partial[end:]...)
+	t.partial = t.partial[:end]
+	t.flush(out)
+	t.partial = append(t.partial, rest...)
+	t.afterCR = end == i+1 && len(rest) == 0
+}
+
+// check looks for the file having been truncated, replaced or created,
+// and starts reading it again from the start if so.
+func (t *tailer) check(out Output) error {
+	fi, err := os.Stat(t.path)
+	if os.IsNotExist(err) && t.untilGone && t.f != nil {
+		if err := t.read(out); err != nil {
+			return err
+		}
+		t.flush(out)
+		return errGone
+	}
+	if err != nil {
+		// Perhaps it's being replaced; keep reading the old one meanwhile
+		return nil
+	}
+	switch {
+	case t.f == nil:
+		fmt.Fprintf(os.Stderr, "following %s\n", t.path)
+	case !os.SameFile(fi, t.fi):
+		// Finish the old file before going on to the new one
+		if err := t.read(out); err != nil {
+			return err
+		}
+		t.flush(out)
+		t.close()
+		fmt.Fprintf(os.Stderr, "%s was replaced, following the new file\n", t.path)
+	case fi.Size() < t.offset:
+		if err := t.readRotated(out); err != nil {
+			return err
+		}
+		t.flush(out)
+		t.close()
+		fmt.Fprintf(os.Stderr, "%s was truncated, reading it from the start\n", t.path)
+	default:
+		return nil
+	}
+	if err := t.open(io.SeekStart); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// readRotated reads the rest of the file from the copy made when it was
+// rotated, if there is one, so that the lines written just before it was
+// truncated aren't lost. The copy is the newest file alongside it, named
+// like it with an underscore and something else added, and perhaps gzipped
+// since, which has the last bytes read at the same offset.
+func (t *tailer) readRotated(out Output) error {
+	ext := filepath.Ext(t.path)
+	pattern := strings.TrimSuffix(t.path, ext) + "_*" + ext
+	copies, _ := filepath.Glob(pattern)
+	gzipped, _ := filepath.Glob(pattern + ".gz")
+	var newest string
+	var newestTime time.Time
+	for _, path := range append(copies, gzipped...) {
+		fi, err := os.Stat(path)
+		if err != nil || !fi.ModTime().After(newestTime) {
+			continue
+		}
+		// A gzipped copy's size says nothing about how much it holds
+		if !strings.HasSuffix(path, ".gz") && fi.Size() < t.offset {
+			continue
+		}
+		newest, newestTime = path, fi.ModTime()
+	}
+	if newest == "" {
+		return nil
+	}
+	if strings.HasSuffix(newest, ".gz") {
+		r, err := openGzipped(newest)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		if !skipTo(r, t.offset, t.recent) {
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "%s was rotated, reading the rest of it from %s\n", t.path, newest)
+		t.r = bufio.NewReader(r)
+		return t.read(out)
+	}
+	f, err := os.Open(newest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Make sure it's a copy of what we've read
+	if !matchesAt(f, t.offset, t.recent) {
+		return nil
+	}
+	if _, err := f.Seek(t.offset, io.SeekStart); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s was rotated, reading the rest of it from %s\n", t.path, newest)
+	t.r = bufio.NewReader(f)
+	return t.read(out)
+}
+
+// matchesAt reports whether a file has the bytes given just before an
+// offset.
+func matchesAt(f *os.File, offset int64, recent []byte) bool {
+	buf := make([]byte, len(recent))
+	_, err := f.ReadAt(buf, offset-int64(len(recent)))
+	return err == nil && bytes.Equal(buf, recent)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Time zone Domino's timestamps are in, given with -domino-tz, if it isn't
+// the local one; for example when running in a container on UTC while the
+// server writes its own local time.
+var dominoTZ string
+
+// The time zone given with -domino-tz, or the local one.
+var dominoLocation = time.Local
+
+// setDominoTZ sets the time zone Domino's timestamps are parsed in, from an
+// IANA name such as Europe/Berlin, or Local or nothing for the local one.
+func setDominoTZ(name string) error {
+	dominoLocation = time.Local
+	if name == "" || name == "Local" {
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("unknown time zone %q", name)
+	}
+	dominoLocation = loc
+	return nil
+}
+
+// The parts of a Domino timestamp, when no -timestamp-format is given: the
+// date as three numbers, with the year first or last, then the time, with
+// any fraction of a second, and AM or PM if it's on a 12-hour clock.
+var timestampPartsRegex = regexp.MustCompile(`^(\d{1,4})[/.-](\d{1,2})[/.-](\d{1,4})\s+(\d{1,2}):(\d\d):(\d\d)(?:[.,](\d+))?(?:\s+([AP]M))?$`)
+
+// Names which can be given with -timestamp-format instead of time layouts,
+// for the formats Domino uses in different countries, each on a 12 or 24-hour
+// clock. Hours on a 12-hour clock needn't have a leading zero, as they don't
+// on some servers.
+var timestampPresets = map[string][]string{
+	"us": {
+		"01/02/2006 3:04:05 PM", "01/02/2006 15:04:05",
+	},
+	"european": {
+		"02/01/2006 3:04:05 PM", "02/01/2006 15:04:05",
+		"02.01.2006 3:04:05 PM", "02.01.2006 15:04:05",
+	},
+	"iso": {
+		"2006-01-02 3:04:05 PM", "2006-01-02 15:04:05",
+		"2006/01/02 3:04:05 PM", "2006/01/02 15:04:05",
+	},
+}
+
+// expandTimestampFormats returns the time layouts to parse Domino's
+// timestamps with, given -timestamp-format, with any names of formats
+// replaced by their layouts.
+func expandTimestampFormats(formats []string) []string {
+	var layouts []string
+	for _, f := range formats {
+		if preset, ok := timestampPresets[strings.ToLower(f)]; ok {
+			layouts = append(layouts, preset...)
+		} else {
+			layouts = append(layouts, f)
+		}
+	}
+	return layouts
+}
+
+// How far a timestamp can be from now for it to count as current, when
+// deciding which way round a date with the day and month both 12 or less is.
+const currentTimestamp = 36 * time.Hour
+
+// Which way round the day and month are in dates with the year last: "mdy"
+// as in the US, or "dmy" as in most other places, once it's been worked out
+// from a day after the 12th.
+var (
+	dateOrderLock sync.Mutex
+	dateOrder     string
+)
+
+// detectTimestamp parses a Domino timestamp without being told its format,
+// as servers in different countries write them differently. Dates with the
+// year first are taken to be year, month, day. Which way round the day and
+// month are in dates with the year last is worked out from the first one
+// where one of them is more than 12; until then, each date is taken the way
+// round which makes it current, or failing that the way round the locale
+// suggests. Both 12 and 24-hour clocks are understood.
+func detectTimestamp(stime string) (time.Time, error) {
+	m := timestampPartsRegex.FindStringSubmatch(stime)
+	if m == nil {
+		return time.Time{}, fmt.Errorf("unrecognized timestamp")
+	}
+	n := make([]int, 6)
+	for i := range n {
+		n[i], _ = strconv.Atoi(m[i+1])
+	}
+	hour, err := clockHour(n[3], m[8])
+	if err != nil {
+		return time.Time{}, err
+	}
+	nsec := 0
+	if m[7] != "" {
+		frac := (m[7] + "000000000")[:9]
+		nsec, _ = strconv.Atoi(frac)
+	}
+	at := func(year, month, day int) (time.Time, bool) {
+		t := time.Date(year, time.Month(month), day, hour, n[4], n[5], nsec, dominoLocation)
+		ok := month >= 1 && month <= 12 && t.Day() == day && n[4] < 60 && n[5] < 61
+		return t, ok
+	}
+	if len(m[1]) > 2 {
+		if t, ok := at(n[0], n[1], n[2]); ok {
+			return t, nil
+		}
+		return time.Time{}, fmt.Errorf("bad date")
+	}
+	year := n[2]
+	if len(m[3]) <= 2 {
+		year += 2000
+	}
+	mdy, mdyOK := at(year, n[0], n[1])
+	dmy, dmyOK := at(year, n[1], n[0])
+	order := learnDateOrder(mdyOK, dmyOK)
+	switch {
+	case order == "mdy" && mdyOK:
+		return mdy, nil
+	case order == "dmy" && dmyOK:
+		return dmy, nil
+	case order != "":
+		return time.Time{}, fmt.Errorf("bad date, since dates have been %s", strings.ToUpper(order))
+	case !mdyOK && !dmyOK:
+		return time.Time{}, fmt.Errorf("bad date")
+	case !dmyOK:
+		return mdy, nil
+	case !mdyOK:
+		return dmy, nil
+	}
+	// Both make sense
+	mdyCurrent := isCurrent(mdy)
+	dmyCurrent := isCurrent(dmy)
+	switch {
+	case mdyCurrent && !dmyCurrent:
+		return mdy, nil
+	case dmyCurrent && !mdyCurrent:
+		return dmy, nil
+	case localeDateOrder() == "dmy":
+		return dmy, nil
+	}
+	return mdy, nil
+}
+
+// The current time, which tests can fix.
+var timeNow = time.Now
+
+// isCurrent reports whether a time is close enough to now that it's likely
+// to be the time of a message just written.
+func isCurrent(t time.Time) bool {
+	d := timeNow().Sub(t)
+	return d < currentTimestamp && d > -currentTimestamp
+}
+
+// clockHour returns the hour of the day on a 24-hour clock, given the hour
+// and AM or PM, if it's on a 12-hour clock.
+func clockHour(hour int, ampm string) (int, error) {
+	switch {
+	case ampm == "" && hour < 24:
+		return hour, nil
+	case ampm == "" || hour < 1 || hour > 12:
+		return 0, fmt.Errorf("bad hour %d", hour)
+	case ampm == "PM":
+		return hour%12 + 12, nil
+	}
+	return hour % 12, nil
+}
+
+// learnDateOrder returns which way round the day and month are, if that's
+// known, learning it from a date which only makes sense one way round.
+func learnDateOrder(mdyOK, dmyOK bool) string {
+	dateOrderLock.Lock()
+	defer dateOrderLock.Unlock()
+	if dateOrder != "" || mdyOK == dmyOK {
+		return dateOrder
+	}
+	dateOrder = "mdy"
+	layout := "MM/DD/YYYY"
+	if dmyOK {
+		dateOrder = "dmy"
+		layout = "DD/MM/YYYY"
+	}
+	fmt.Fprintf(os.Stderr, "Domino's timestamps look like %s\n", layout)
+	return dateOrder
+}
+
+// localeDateOrder returns which way round the day and month probably are,
+// going by the locale: US English, or no locale at all, puts the month first,
+// and most others the day.
+func localeDateOrder() string {
+	for _, name := range []string{"LC_ALL", "LC_TIME", "LANG"} {
+		locale := os.Getenv(name)
+		if locale == "" {
+			continue
+		}
+		if locale == "C" || locale == "POSIX" || strings.HasPrefix(locale, "C.") || strings.HasPrefix(strings.ToLower(locale), "en_us") {
+			return "mdy"
+		}
+		return "dmy"
+	}
+	return "mdy"
+}
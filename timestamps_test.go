@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDetectTimestamp(t *testing.T) {
+	const layout = "2006-01-02 15:04:05.999999999"
+	tests := []struct {
+		name      string
+		stamp     string
+		order     string // dateOrder already learned
+		now       string // if not today
+		locale    string // LANG
+		want      string // in layout, or "" for an error
+		wantOrder string // dateOrder afterwards
+	}{
+		{name: "MDY", stamp: "10/16/2026 09:00:00 AM", want: "2026-10-16 09:00:00", wantOrder: "mdy"},
+		{name: "DMY", stamp: "16/10/2026 21:00:00", want: "2026-10-16 21:00:00", wantOrder: "dmy"},
+		{name: "DMY dots", stamp: "16.10.2026 21:00:00", want: "2026-10-16 21:00:00", wantOrder: "dmy"},
+		{name: "DMY two-digit year", stamp: "16.10.26 21:00:00", want: "2026-10-16 21:00:00", wantOrder: "dmy"},
+		{name: "MDY learned", stamp: "03/04/2026 10:00:00", order: "mdy", want: "2026-03-04 10:00:00", wantOrder: "mdy"},
+		{name: "DMY learned", stamp: "03/04/2026 10:00:00", order: "dmy", want: "2026-04-03 10:00:00", wantOrder: "dmy"},
+		{name: "DMY date once MDY learned", stamp: "16/10/2026 09:00:00", order: "mdy", wantOrder: "mdy"},
+		{name: "MDY date once DMY learned", stamp: "10/16/2026 09:00:00", order: "dmy", wantOrder: "dmy"},
+		{name: "current as MDY", stamp: "04/03/2026 10:00:00", now: "2026-04-03 12:00:00", locale: "de_DE.UTF-8", want: "2026-04-03 10:00:00"},
+		{name: "current as DMY", stamp: "03/04/2026 10:00:00", now: "2026-04-03 12:00:00", locale: "en_US.UTF-8", want: "2026-04-03 10:00:00"},
+		{name: "US locale", stamp: "03/04/2026 10:00:00", locale: "en_US.UTF-8", want: "2026-03-04 10:00:00"},
+		{name: "C locale", stamp: "03/04/2026 10:00:00", locale: "C.UTF-8", want: "2026-03-04 10:00:00"},
+		{name: "no locale", stamp: "03/04/2026 10:00:00", want: "2026-03-04 10:00:00"},
+		{name: "German locale", stamp: "03/04/2026 10:00:00", locale: "de_DE.UTF-8", want: "2026-04-03 10:00:00"},
+		{name: "no such date", stamp: "31/04/2026 10:00:00"},
+		{name: "year first", stamp: "2026-10-16 13:05:06", want: "2026-10-16 13:05:06"},
+		{name: "year first slashes", stamp: "2026/04/03 10:00:00 PM", want: "2026-04-03 22:00:00"},
+		{name: "year first no such date", stamp: "2026-02-30 10:00:00"},
+		{name: "midnight", stamp: "2026-10-16 12:00:00 AM", want: "2026-10-16 00:00:00"},
+		{name: "midnight past", stamp: "2026-10-16 12:30:00 AM", want: "2026-10-16 00:30:00"},
+		{name: "noon", stamp: "2026-10-16 12:00:00 PM", want: "2026-10-16 12:00:00"},
+		{name: "afternoon", stamp: "2026-10-16 1:00:00 PM", want: "2026-10-16 13:00:00"},
+		{name: "24-hour midnight", stamp: "2026-10-16 00:00:00", want: "2026-10-16 00:00:00"},
+		{name: "24-hour evening", stamp: "2026-10-16 23:59:59", want: "2026-10-16 23:59:59"},
+		{name: "24-hour hour with PM", stamp: "2026-10-16 13:00:00 PM"},
+		{name: "zero hour with AM", stamp: "2026-10-16 00:00:00 AM"},
+		{name: "hour 24", stamp: "2026-10-16 24:00:00"},
+		{name: "minute 60", stamp: "2026-10-16 10:60:00"},
+		{name: "fraction", stamp: "2026-10-16 13:05:06.5", want: "2026-10-16 13:05:06.5"},
+		{name: "fraction with comma", stamp: "2026-10-16 13:05:06,123", want: "2026-10-16 13:05:06.123"},
+		{name: "fraction in nanoseconds", stamp: "10/16/2026 01:05:06.123456789 PM", want: "2026-10-16 13:05:06.123456789", wantOrder: "mdy"},
+		{name: "not a timestamp", stamp: "yesterday at 10"},
+	}
+	defer func() {
+		timeNow = time.Now
+		dateOrder = ""
+	}()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LC_ALL", "")
+			t.Setenv("LC_TIME", "")
+			t.Setenv("LANG", tt.locale)
+			now := "2026-10-16 12:00:00"
+			if tt.now != "" {
+				now = tt.now
+			}
+			fixed, err := time.ParseInLocation(layout, now, time.Local)
+			if err != nil {
+				t.Fatal(err)
+			}
+			timeNow = func() time.Time { return fixed }
+			dateOrder = tt.order
+
+			got, err := detectTimestamp(tt.stamp)
+			switch {
+			case tt.want == "" && err == nil:
+				t.Errorf("detectTimestamp(%q) = %s, want an error", tt.stamp, got.Format(layout))
+			case tt.want != "" && err != nil:
+				t.Errorf("detectTimestamp(%q): %s", tt.stamp, err)
+			case tt.want != "" && got.Format(layout) != tt.want:
+				t.Errorf("detectTimestamp(%q) = %s, want %s", tt.stamp, got.Format(layout), tt.want)
+			}
+			if dateOrder != tt.wantOrder {
+				t.Errorf("after %q, dateOrder = %q, want %q", tt.stamp, dateOrder, tt.wantOrder)
+			}
+		})
+	}
+}
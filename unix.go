@@ -0,0 +1,104 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// Where the Domino server script usually is.
+const defaultDominoServer = "/opt/ibm/domino/bin/server"
+
+// Where the Domino server writes its console log.
+const defaultConsoleLog = "/local/notesdata/IBM_TECHNICAL_SUPPORT/console.log"
+
+// Where events go if no output is given.
+const defaultOutput = "syslog"
+
+// serverCommand returns the command line to run the Domino server script.
+// Oddly, the Domino 'server' command is a shell script for unspecified
+// shell.
+func serverCommand(path string) []string {
+	return []string{"/bin/sh", path}
+}
+
+// runAs arranges for a command to be run as another user, with their home
+// directory, as su would. Only root can do that.
+func runAs(cmd *exec.Cmd, username string) error {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return err
+	}
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	gids, _ := u.GroupIds()
+	for _, g := range gids {
+		if n, err := strconv.ParseUint(g, 10, 32); err == nil {
+			cred.Groups = append(cred.Groups, uint32(n))
+		}
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+	// The last of each variable is the one used
+	cmd.Env = append(os.Environ(), "HOME="+u.HomeDir, "USER="+u.Username, "LOGNAME="+u.Username)
+	return nil
+}
+
+// newProcessGroup does nothing, since commands get the signals sent to the
+// terminal's process group anyway.
+func newProcessGroup(cmd *exec.Cmd) {}
+
+// commandStop returns nil, so that the command run by server or run is left
+// to handle the signals it gets itself.
+func commandStop() <-chan struct{} {
+	return nil
+}
+
+// terminate asks a command we're running to stop.
+func terminate(p *os.Process) {
+	p.Signal(syscall.SIGTERM)
+}
+
+// handleSignals deals with the signals we use for control: SIGHUP re-reads
+// the rules, so they can be changed without restarting Domino, and SIGUSR1
+// logs how many lines each rule has matched, and how often each output has
+// had to retry. If the rules can't be loaded, the old rules stay in effect.
+func handleSignals(out Output) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGUSR1)
+	for sig := range sigs {
+		if sig == syscall.SIGUSR1 {
+			reportHits(out)
+			reportRetries(out)
+			continue
+		}
+		newrules, err := loadConfiguredRules()
+		var srcrules [][]Rule
+		if err == nil {
+			srcrules, err = loadSourceRules()
+		}
+		if err != nil {
+			msg := fmt.Sprintf("error reloading rules, keeping old rules: %s", err)
+			fmt.Fprintln(os.Stderr, msg)
+			notify(out, LOG_ERR, msg)
+			continue
+		}
+		// Counts are kept with the rules, so report them before they go
+		reportHits(out)
+		setRules(newrules)
+		setSourceRules(srcrules)
+		notify(out, LOG_NOTICE, fmt.Sprintf("reloaded %d rules", len(newrules.rules)))
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often to look for new files to follow.
+const watchInterval = time.Second
+
+func init() {
+	inputTypes["watch"] = func(pattern string) (input, error) {
+		if pattern == "" {
+			return nil, fmt.Errorf("watch input needs a pattern for the files to follow")
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q for watch input: %s", pattern, err)
+		}
+		return func(src *source, out Output, stop <-chan struct{}) error {
+			watchFiles(pattern, src, out, stop)
+			return nil
+		}, nil
+	}
+}
+
+// watchFiles follows every file matching a glob pattern, such as Domino's
+// NSD logs, until stop is closed, tagging messages from each with its name,
+// without the extension.
+func watchFiles(pattern string, src *source, out Output, stop <-chan struct{}) {
+	followMatching(pattern, stop, func(t *tailer) Output {
+		t.src = src
+		base := filepath.Base(t.path)
+		return tagOutput{out, strings.TrimSuffix(base, filepath.Ext(base))}
+	})
+}
+
+// followMatching follows every file matching a glob pattern until stop is
+// closed. Files created after we start are read from the start, and those
+// already there from the end. Each is followed until it's removed. Before
+// a file is followed, setup is called to set up its tailer, and returns the
+// output for its lines.
+func followMatching(pattern string, stop <-chan struct{}, setup func(t *tailer) Output) {
+	var mu sync.Mutex
+	following := make(map[string]bool)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	whence := io.SeekEnd
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		// The only error is a bad pattern, which has been checked
+		matches, _ := filepath.Glob(pattern)
+		for _, path := range matches {
+			mu.Lock()
+			if following[path] {
+				mu.Unlock()
+				continue
+			}
+			following[path] = true
+			mu.Unlock()
+			if whence == io.SeekStart {
+				fmt.Fprintf(os.Stderr, "following new file %s\n", path)
+			}
+			t := &tailer{path: path, untilGone: true}
+			out := setup(t)
+			wg.Add(1)
+			go func(path string, whence int) {
+				defer wg.Done()
+				if err := t.follow(out, stop, whence); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				}
+				mu.Lock()
+				delete(following, path)
+				mu.Unlock()
+			}(path, whence)
+		}
+		whence = io.SeekStart
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// tagOutput gives events the syslog tag of the file they came from.
+type tagOutput struct {
+	Output
+	tag string
+}
+
+func (to tagOutput) Write(ev *Event) error {
+	ev.Tag = to.tag
+	return to.Output.Write(ev)
+}
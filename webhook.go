@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Defaults for webhooks: the least severe priority posted, the text of the
+// message, and how many times to try posting it.
+const (
+	webhookSeverity = "err"
+	webhookTemplate = "{{.Host}} {{.Tag}}: {{.Message}}"
+	webhookAttempts = 5
+)
+
+func init() {
+	outputTypes["webhook"] = func(arg string) (Output, error) {
+		return openWebhooks(arg)
+	}
+}
+
+// webhook posts events at or above a severity to a URL, one at a time.
+type webhook struct {
+	url      string
+	severity Priority
+	template *template.Template
+	out      *batchOutput
+}
+
+// webhooks are the webhooks an output posts to.
+type webhooks []*webhook
+
+// All the webhooks opened, so that crashes can be posted to them straight
+// away with -crash-webhooks.
+var allWebhooks webhooks
+
+// openWebhooks opens a webhook output, given one or more URLs separated by
+// spaces. The settings for each go in its fragment, which is never sent to
+// the server, such as
+// https://hooks.example.com/T0123#severity=crit&template={{.Message}}&attempts=3.
+func openWebhooks(arg string) (Output, error) {
+	var whs webhooks
+	for _, addr := range strings.Fields(arg) {
+		wh, err := newWebhook(addr)
+		if err != nil {
+			whs.Close()
+			return nil, err
+		}
+		whs = append(whs, wh)
+	}
+	if len(whs) == 0 {
+		return nil, fmt.Errorf("webhook output needs a URL, such as webhook:https://hooks.example.com/T0123")
+	}
+	allWebhooks = append(allWebhooks, whs...)
+	return whs, nil
+}
+
+// newWebhook starts posting to a webhook, given its URL and settings.
+func newWebhook(addr string) (*webhook, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("webhook %q should be an http or https URL", addr)
+	}
+	params := queryParams(u.EscapedFragment())
+	u.Fragment, u.RawFragment = "", ""
+	wh := &webhook{url: u.String()}
+	severity := params.Get("severity")
+	if severity == "" {
+		severity = webhookSeverity
+	}
+	if wh.severity, err = parsePriority(severity); err != nil {
+		return nil, err
+	}
+	text := params.Get("template")
+	if text == "" {
+		text = webhookTemplate
+	}
+	if wh.template, err = template.New(u.Host).Parse(text); err != nil {
+		return nil, fmt.Errorf("bad template for webhook at %s: %s", u.Host, err)
+	}
+	attempts := webhookAttempts
+	if s := params.Get("attempts"); s != "" {
+		if attempts, err = strconv.Atoi(s); err != nil || attempts < 0 {
+			return nil, fmt.Errorf("webhook attempts %q should be a count, or 0 to keep trying", s)
+		}
+	}
+	wh.out = newBatchOutput("webhook at "+u.Host, wh.send)
+	wh.out.attempts = attempts
+	return wh, nil
+}
+
+// webhookPayload is what's posted to a webhook: the event as a JSON object
+// like those of the JSON Lines output, along with the text from the
+// template, as a "text" field which chat services such as Slack display.
+type webhookPayload struct {
+	Text string `json:"text"`
+	*jsonEvent
+}
+
+// send posts events, one request each. If one fails, it and the rest are
+// returned to be tried again.
+func (wh *webhook) send(evs []*Event) error {
+	for i, ev := range evs {
+		jev := newJSONEvent(ev)
+		jev.Host = ev.host()
+		var text bytes.Buffer
+		if err := wh.template.Execute(&text, jev); err != nil {
+			return permanentError{err}
+		}
+		body, err := json.Marshal(webhookPayload{text.String(), jev})
+		if err != nil {
+			return permanentError{err}
+		}
+		req, err := http.NewRequest("POST", wh.url, bytes.NewReader(body))
+		if err != nil {
+			return permanentError{err}
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if _, err := doHTTP(req); err != nil {
+			return retryFrom(evs[i:], err)
+		}
+	}
+	return nil
+}
+
+// Write queues an event for each webhook whose severity it's at or above.
+func (whs webhooks) Write(ev *Event) error {
+	for _, wh := range whs {
+		// Lower numbers are more severe
+		if ev.Priority <= wh.severity {
+			if err := wh.out.Write(ev); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// postToWebhooks posts an event to every webhook whose severity it's at or
+// above straight away, rather than queueing it to be sent with others, and
+// without retrying if that fails.
+func postToWebhooks(ev *Event) {
+	stamp(ev)
+	for _, wh := range allWebhooks {
+		if ev.Priority > wh.severity {
+			continue
+		}
+		if err := wh.send([]*Event{ev}); err != nil {
+			fmt.Fprintf(os.Stderr, "error posting to %s: %s\n", wh.out.name, err)
+		}
+	}
+}
+
+func (whs webhooks) Close() error {
+	var err error
+	for _, wh := range whs {
+		if cerr := wh.out.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// Where the Domino server program usually is.
+const defaultDominoServer = `C:\Program Files\HCL\Domino\nserver.exe`
+
+// Where the Domino server writes its console log.
+const defaultConsoleLog = `C:\Program Files\HCL\Domino\Data\IBM_TECHNICAL_SUPPORT\console.log`
+
+// Where events go if no output is given, since Windows has no syslog.
+const defaultOutput = "eventlog"
+
+// serverCommand returns the command line to run the Domino server program.
+func serverCommand(path string) []string {
+	return []string{path}
+}
+
+// runAs would run a command as another user, but that needs their password
+// on Windows, so domino2syslog has to be run as them instead.
+func runAs(cmd *exec.Cmd, username string) error {
+	return fmt.Errorf("can't run Domino as %s on Windows; run domino2syslog as that user instead", username)
+}
+
+// How long a command we've asked to stop has to shut down before it's
+// killed. Domino can take a while.
+const terminateTimeout = 2 * time.Minute
+
+// newProcessGroup starts a command in a process group of its own, so that
+// Ctrl+C and Ctrl+Break in the console only reach us, and we can pass them
+// on once we're ready to log the command's last output.
+func newProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+}
+
+// commandStop returns a channel which is closed when we're asked to stop,
+// by Ctrl+C or Ctrl+Break, the console being closed, or Windows shutting
+// down, so that the command run by server or run can be stopped in turn.
+func commandStop() <-chan struct{} {
+	return stopOnSignal()
+}
+
+// terminate asks a command we're running to stop, by sending it Ctrl+Break
+// as if it had been pressed in its console, and kills it if it hasn't
+// stopped within terminateTimeout. Without a console to send it with, as
+// when we're run as a service, it's killed straight away.
+func terminate(p *os.Process) {
+	if err := windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(p.Pid)); err != nil {
+		p.Kill()
+		return
+	}
+	go func() {
+		time.Sleep(terminateTimeout)
+		// Does nothing if it's exited
+		p.Kill()
+	}()
+}
+
+// handleSignals does nothing, since Windows has no signals to control us
+// with. The rules can't be reloaded without restarting, and the number of
+// lines each has matched is only reported when the server stops.
+func handleSignals(out Output) {}
+
+// newStdSyslog fails, since there's no local syslog daemon on Windows, nor
+// the standard library's syslog package.
+func newStdSyslog() (*senderOutput, error) {
+	return nil, fmt.Errorf("there's no local syslog on Windows; give the address of a syslog server, or use the eventlog output")
+}